@@ -0,0 +1,209 @@
+package quic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// ackThreshold is the default number of ack-eliciting packets that may
+// arrive before an acknowledgment is sent immediately.
+const ackThreshold = 2
+
+// defaultMaxAckDelay is the max_ack_delay this endpoint advertises: the
+// longest a single ack-eliciting packet may wait before an ACK is flushed
+// (RFC 9000 section 18.2).
+const defaultMaxAckDelay = 25 * time.Millisecond
+
+// defaultAckDelayExponent is the ack_delay_exponent used when the peer does
+// not advertise one (RFC 9000 section 18.2).
+const defaultAckDelayExponent = 3
+
+// pnRange is an inclusive range of received packet numbers.
+type pnRange struct {
+	start uint64
+	end   uint64
+}
+
+// receivedPacketTracker records which packet numbers have been received and
+// builds ACK frames describing them, including any holes.
+type receivedPacketTracker struct {
+	mutex sync.Mutex
+	// ranges is kept sorted ascending and fully merged, so adjacent
+	// entries always have a gap of at least one packet number.
+	ranges []pnRange
+	// ackElicitingPending counts ack-eliciting packets received since the
+	// last ACK was sent; ackEvery is how many may accumulate before an ACK
+	// goes out immediately.
+	ackElicitingPending int
+	ackEvery            int
+	// ackDelayExponent scales the AckDelay field of generated ACKs.
+	ackDelayExponent uint64
+	// largestRecvTime is when the largest received packet number arrived;
+	// it anchors the AckDelay measurement.
+	largestPN       uint64
+	largestRecvTime time.Time
+	// ECN counters report how many packets arrived with each codepoint
+	// (RFC 9000 section 19.3.2).
+	ect0Count  uint64
+	ect1Count  uint64
+	ecnceCount uint64
+}
+
+// newReceivedPacketTracker creates a tracker acknowledging every ackEvery-th
+// ack-eliciting packet; zero or less means the default of every second one.
+func newReceivedPacketTracker(ackDelayExponent uint64, ackEvery int) *receivedPacketTracker {
+	if ackEvery <= 0 {
+		ackEvery = ackThreshold
+	}
+	return &receivedPacketTracker{ackDelayExponent: ackDelayExponent, ackEvery: ackEvery}
+}
+
+// OnECN records the ECN codepoint a packet arrived with.
+func (t *receivedPacketTracker) OnECN(codepoint uint8) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch codepoint {
+	case ecnECT0:
+		t.ect0Count++
+	case ecnECT1:
+		t.ect1Count++
+	case ecnCE:
+		t.ecnceCount++
+	}
+}
+
+// OnPacketReceived records pn as received. It reports whether the packet
+// arrived out of order — skipping past the previous largest or filling in
+// behind it — which warrants an immediate ACK to speed the peer's loss
+// recovery (RFC 9000 section 13.2.1), and whether pn is a duplicate of a
+// packet number already recorded, whose frames must not be processed again.
+func (t *receivedPacketTracker) OnPacketReceived(pn uint64) (outOfOrder, duplicate bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	outOfOrder = len(t.ranges) > 0 && pn != t.largestPN+1
+	if pn >= t.largestPN {
+		t.largestPN = pn
+		t.largestRecvTime = time.Now()
+	}
+	for i := range t.ranges {
+		r := &t.ranges[i]
+		if pn >= r.start && pn <= r.end {
+			return false, true
+		}
+		if pn+1 == r.start {
+			r.start = pn
+			t.mergeLocked(i)
+			return outOfOrder, false
+		}
+		if pn == r.end+1 {
+			r.end = pn
+			t.mergeLocked(i)
+			return outOfOrder, false
+		}
+		if pn < r.start {
+			t.ranges = append(t.ranges, pnRange{})
+			copy(t.ranges[i+1:], t.ranges[i:])
+			t.ranges[i] = pnRange{start: pn, end: pn}
+			return outOfOrder, false
+		}
+	}
+	t.ranges = append(t.ranges, pnRange{start: pn, end: pn})
+	return outOfOrder, false
+}
+
+// mergeLocked merges the range at index i with its neighbours if they became
+// adjacent after an extension.
+func (t *receivedPacketTracker) mergeLocked(i int) {
+	if i > 0 && t.ranges[i-1].end+1 >= t.ranges[i].start {
+		t.ranges[i-1].end = t.ranges[i].end
+		t.ranges = append(t.ranges[:i], t.ranges[i+1:]...)
+		i--
+	}
+	if i+1 < len(t.ranges) && t.ranges[i].end+1 >= t.ranges[i+1].start {
+		t.ranges[i].end = t.ranges[i+1].end
+		t.ranges = append(t.ranges[:i+1], t.ranges[i+2:]...)
+	}
+}
+
+// OnAckEliciting records that an ack-eliciting packet arrived and reports
+// whether an ACK should be sent now.
+func (t *receivedPacketTracker) OnAckEliciting() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.ackElicitingPending++
+	return t.ackElicitingPending >= t.ackEvery
+}
+
+// OnAckSent resets the ack-eliciting counter after an ACK has been sent.
+func (t *receivedPacketTracker) OnAckSent() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.ackElicitingPending = 0
+}
+
+// BuildAckFrame constructs an ACK frame describing the received packets.
+// maxSize caps the encoded frame size; when the ranges do not all fit, the
+// oldest are dropped so the frame always reports the most recent packets,
+// which matter most for the peer's loss recovery. Zero means unbounded. It
+// returns nil if no packets have been received.
+func (t *receivedPacketTracker) BuildAckFrame(maxSize int) *packet.AckFrame {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.ranges) == 0 {
+		return nil
+	}
+
+	// Ranges are encoded from the largest packet number downwards. The gap
+	// to the next range is the number of unacknowledged packets between
+	// them minus one (RFC 9000 section 19.3.1).
+	last := t.ranges[len(t.ranges)-1]
+	frame := &packet.AckFrame{
+		LargestAcked:  last.end,
+		FirstAckRange: last.end - last.start,
+		ECT0Count:     t.ect0Count,
+		ECT1Count:     t.ect1Count,
+		ECNCECount:    t.ecnceCount,
+	}
+	if !t.largestRecvTime.IsZero() {
+		delay := time.Since(t.largestRecvTime).Microseconds()
+		if delay > 0 {
+			frame.AckDelay = uint64(delay) >> t.ackDelayExponent
+		}
+	}
+	smallest := last.start
+	for i := len(t.ranges) - 2; i >= 0; i-- {
+		r := t.ranges[i]
+		frame.AckRanges = append(frame.AckRanges, packet.AckRange{
+			Gap:    smallest - r.end - 2,
+			Length: r.end - r.start,
+		})
+		if maxSize > 0 && frame.Length() > maxSize {
+			frame.AckRanges = frame.AckRanges[:len(frame.AckRanges)-1]
+			break
+		}
+		smallest = r.start
+	}
+	return frame
+}
+
+// ackFrameCovers reports whether the frame acknowledges pn. It walks the
+// ranges in the wire encoding produced by BuildAckFrame: from the largest
+// packet number downwards, each gap skipping Gap+1 unacknowledged packets.
+func ackFrameCovers(f *packet.AckFrame, pn uint64) bool {
+	largest := f.LargestAcked
+	smallest := largest - f.FirstAckRange
+	if pn >= smallest && pn <= largest {
+		return true
+	}
+	for _, r := range f.AckRanges {
+		largest = smallest - r.Gap - 2
+		smallest = largest - r.Length
+		if pn >= smallest && pn <= largest {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/QUIC/packet"
+)
+
+func TestBuildAckFrameWithGaps(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	for _, pn := range []uint64{1, 2, 3, 7, 8, 10} {
+		tracker.OnPacketReceived(pn)
+	}
+
+	frame := tracker.BuildAckFrame(0)
+	if frame == nil {
+		t.Fatal("expected an ACK frame")
+	}
+	if frame.LargestAcked != 10 {
+		t.Errorf("LargestAcked = %d, want 10", frame.LargestAcked)
+	}
+	if frame.FirstAckRange != 0 {
+		t.Errorf("FirstAckRange = %d, want 0", frame.FirstAckRange)
+	}
+	want := []packet.AckRange{
+		{Gap: 0, Length: 1}, // packets 7-8
+		{Gap: 2, Length: 2}, // packets 1-3
+	}
+	if len(frame.AckRanges) != len(want) {
+		t.Fatalf("got %d ack ranges, want %d: %+v", len(frame.AckRanges), len(want), frame.AckRanges)
+	}
+	for i, r := range want {
+		if frame.AckRanges[i] != r {
+			t.Errorf("AckRanges[%d] = %+v, want %+v", i, frame.AckRanges[i], r)
+		}
+	}
+}
+
+func TestBuildAckFrameContiguous(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	for pn := uint64(1); pn <= 5; pn++ {
+		tracker.OnPacketReceived(pn)
+	}
+	frame := tracker.BuildAckFrame(0)
+	if frame == nil {
+		t.Fatal("expected an ACK frame")
+	}
+	if frame.LargestAcked != 5 || frame.FirstAckRange != 4 {
+		t.Errorf("got largest %d first range %d, want 5 and 4",
+			frame.LargestAcked, frame.FirstAckRange)
+	}
+	if len(frame.AckRanges) != 0 {
+		t.Errorf("unexpected ack ranges: %+v", frame.AckRanges)
+	}
+}
+
+func TestBuildAckFrameEmpty(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	if frame := tracker.BuildAckFrame(0); frame != nil {
+		t.Errorf("expected nil frame, got %+v", frame)
+	}
+}
+
+func TestTrackerOutOfOrderInsert(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	for _, pn := range []uint64{10, 2, 5, 4, 3, 1} {
+		tracker.OnPacketReceived(pn)
+	}
+	frame := tracker.BuildAckFrame(0)
+	if frame.LargestAcked != 10 || frame.FirstAckRange != 0 {
+		t.Fatalf("got largest %d first range %d", frame.LargestAcked, frame.FirstAckRange)
+	}
+	// Packets 1-5 should have merged into one range.
+	want := packet.AckRange{Gap: 3, Length: 4}
+	if len(frame.AckRanges) != 1 || frame.AckRanges[0] != want {
+		t.Errorf("AckRanges = %+v, want [%+v]", frame.AckRanges, want)
+	}
+}
+
+func TestAckElicitingSchedule(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	if tracker.OnAckEliciting() {
+		t.Error("first ack-eliciting packet should not trigger an ACK")
+	}
+	if !tracker.OnAckEliciting() {
+		t.Error("second ack-eliciting packet should trigger an ACK")
+	}
+	tracker.OnAckSent()
+	if tracker.OnAckEliciting() {
+		t.Error("counter should reset after an ACK is sent")
+	}
+}
+
+func TestAckWithinMaxAckDelay(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Let any ACKs still owed from the handshake drain first.
+	time.Sleep(2 * defaultMaxAckDelay)
+
+	before := conn.GetStats().PacketsReceived
+	start := time.Now()
+	if _, err := s.Write([]byte("lone packet")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server owes an ACK for this single data packet within
+	// max_ack_delay, without waiting for a second ack-eliciting packet.
+	deadline := time.Now().Add(defaultMaxAckDelay + 100*time.Millisecond)
+	for conn.GetStats().PacketsReceived == before {
+		if time.Now().After(deadline) {
+			t.Fatal("no ACK arrived for a single data packet")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed > defaultMaxAckDelay+100*time.Millisecond {
+		t.Errorf("ACK took %v, want within max_ack_delay (%v)", elapsed, defaultMaxAckDelay)
+	}
+}
+
+func TestImmediateAckOnReordering(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	if ooo, _ := tracker.OnPacketReceived(1); ooo {
+		t.Error("first packet should not count as reordered")
+	}
+	if ooo, _ := tracker.OnPacketReceived(3); !ooo {
+		t.Error("skipping packet 2 should request an immediate ACK")
+	}
+	frame := tracker.BuildAckFrame(0)
+	if frame == nil || frame.LargestAcked != 3 || frame.FirstAckRange != 0 {
+		t.Fatalf("unexpected frame %+v", frame)
+	}
+	// The ACK must report the hole at packet 2.
+	want := packet.AckRange{Gap: 0, Length: 0}
+	if len(frame.AckRanges) != 1 || frame.AckRanges[0] != want {
+		t.Errorf("AckRanges = %+v, want [%+v]", frame.AckRanges, want)
+	}
+	// Filling the gap is also a reordered arrival.
+	if ooo, _ := tracker.OnPacketReceived(2); !ooo {
+		t.Error("late packet 2 should request an immediate ACK")
+	}
+	if ooo, _ := tracker.OnPacketReceived(4); ooo {
+		t.Error("in-order packet 4 should not request an immediate ACK")
+	}
+}
+
+func TestBuildAckFrameDropsOldestRangesWhenFull(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	// Every second packet number, so each received packet is its own range.
+	const ranges = 200
+	for pn := uint64(0); pn < 2*ranges; pn += 2 {
+		tracker.OnPacketReceived(pn)
+	}
+
+	const maxSize = 100
+	frame := tracker.BuildAckFrame(maxSize)
+	if frame == nil {
+		t.Fatal("no frame built")
+	}
+	if frame.Length() > maxSize {
+		t.Errorf("frame is %d bytes, want at most %d", frame.Length(), maxSize)
+	}
+	if len(frame.AckRanges) >= ranges-1 {
+		t.Fatalf("frame kept all %d ranges; the limit did not bite", len(frame.AckRanges))
+	}
+	// The newest packets must survive the trim, the oldest must not.
+	if frame.LargestAcked != 2*ranges-2 {
+		t.Errorf("LargestAcked = %d, want %d", frame.LargestAcked, 2*ranges-2)
+	}
+	if !ackFrameCovers(frame, 2*ranges-2) {
+		t.Error("frame does not cover the newest packet")
+	}
+	if ackFrameCovers(frame, 0) {
+		t.Error("frame still covers packet 0; the oldest range should have been dropped")
+	}
+}
+
+func TestAckFrequencySpacesImmediateAcks(t *testing.T) {
+	const every = 5
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, every)
+
+	// In-order ack-eliciting packets: only every fifth forces an ACK out.
+	var immediate int
+	for pn := uint64(0); pn < 20; pn++ {
+		tracker.OnPacketReceived(pn)
+		if tracker.OnAckEliciting() {
+			immediate++
+			tracker.OnAckSent()
+		}
+	}
+	if immediate != 4 {
+		t.Errorf("%d immediate ACKs over 20 packets, want one every %d", immediate, every)
+	}
+}
@@ -0,0 +1,31 @@
+package quic
+
+import (
+	"net"
+	"net/netip"
+)
+
+// addrKey returns a canonical string form of a UDP address for use as a map
+// key and for peer matching. IPv4-mapped IPv6 addresses (as produced by a
+// dual-stack socket) are unmapped so that 127.0.0.1:x and
+// [::ffff:127.0.0.1]:x refer to the same peer.
+func addrKey(addr *net.UDPAddr) string {
+	if ap := udpAddrPort(addr); ap.IsValid() {
+		return ap.String()
+	}
+	return addr.String()
+}
+
+// udpAddrPort converts a *net.UDPAddr to a comparable netip.AddrPort,
+// unmapping IPv4-mapped IPv6 addresses. The zero AddrPort is returned for
+// invalid input.
+func udpAddrPort(addr *net.UDPAddr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(ip.Unmap(), uint16(addr.Port))
+}
@@ -0,0 +1,91 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddrKeyUnmapsV4MappedV6(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4242}
+	mapped := &net.UDPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 4242}
+	if addrKey(v4) != addrKey(mapped) {
+		t.Errorf("addrKey(%v) = %q, addrKey(%v) = %q; want equal",
+			v4, addrKey(v4), mapped, addrKey(mapped))
+	}
+	other := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 4242}
+	if addrKey(v4) == addrKey(other) {
+		t.Error("distinct addresses must not collide")
+	}
+}
+
+func TestDualStackListener(t *testing.T) {
+	ln, err := ListenAddr("[::]:0", nil, &Config{Network: "udp"})
+	if err != nil {
+		t.Skipf("IPv6 not supported: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 64)
+		n, _ := s.Read(buf)
+		s.Write(buf[:n])
+	}()
+
+	// Connect over IPv4 to the dual-stack listener; the server sees the
+	// client as a v4-mapped IPv6 address.
+	port := ln.Addr().(*net.UDPAddr).Port
+	conn, err := DialContext(context.Background(), fmt.Sprintf("127.0.0.1:%d", port),
+		&tls.Config{InsecureSkipVerify: true}, &Config{Network: "udp4"})
+	if err != nil {
+		t.Skipf("dual-stack dial failed (possibly no v4-mapped support): %v", err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	s.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 16)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("echo over dual-stack failed: n=%d err=%v", n, err)
+	}
+}
+
+func TestIsFromPeerAcceptsEquivalentForms(t *testing.T) {
+	c := &Connection{
+		remoteAddrPort: udpAddrPort(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9000}),
+	}
+	equivalents := []*net.UDPAddr{
+		{IP: net.ParseIP("127.0.0.1"), Port: 9000},
+		{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 9000},
+		{IP: net.IPv4(127, 0, 0, 1), Port: 9000},
+	}
+	for _, addr := range equivalents {
+		if !c.isFromPeer(addr) {
+			t.Errorf("isFromPeer(%v) = false, want true", addr)
+		}
+	}
+	if c.isFromPeer(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9001}) {
+		t.Error("different port must not match")
+	}
+	if c.isFromPeer(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 9000}) {
+		t.Error("different address must not match")
+	}
+}
@@ -0,0 +1,170 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// clientConnection is the client side of a dialed connection.
+type clientConnection struct {
+	*Connection
+}
+
+// DialAddr connects to a QUIC server at the given address.
+func DialAddr(addr string, tlsConf *tls.Config, config *Config) (*Connection, error) {
+	return DialContext(context.Background(), addr, tlsConf, config)
+}
+
+// DialContext connects to a QUIC server at the given address. It blocks
+// until the handshake completes.
+func DialContext(ctx context.Context, addr string, tlsConf *tls.Config, config *Config) (*Connection, error) {
+	return dial(ctx, addr, tlsConf, config, false)
+}
+
+// DialAddrEarly connects to a QUIC server but returns as soon as the first
+// flight has been sent, without waiting for the handshake to complete.
+// Streams may be opened and written to immediately; the data is delivered
+// once the handshake finishes. Use HandshakeComplete to await establishment.
+func DialAddrEarly(addr string, tlsConf *tls.Config, config *Config) (*Connection, error) {
+	return DialEarly(context.Background(), addr, tlsConf, config)
+}
+
+// DialEarly is the context-aware variant of DialAddrEarly.
+func DialEarly(ctx context.Context, addr string, tlsConf *tls.Config, config *Config) (*Connection, error) {
+	return dial(ctx, addr, tlsConf, config, true)
+}
+
+func dial(ctx context.Context, addr string, tlsConf *tls.Config, config *Config, early bool) (*Connection, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config = populateConfig(config)
+
+	if config.HappyEyeballs && !early {
+		return dialHappyEyeballs(ctx, addr, tlsConf, config)
+	}
+
+	remoteAddr, err := net.ResolveUDPAddr(config.Network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", addr, err)
+	}
+	return dialUDPAddr(ctx, remoteAddr, tlsConf, config, early)
+}
+
+// dialHappyEyeballs resolves the hostname and races a connection attempt per
+// candidate address (RFC 8305 style), keeping the first one whose handshake
+// completes and cancelling the rest. Attempt starts are staggered so a
+// responsive address is not penalized by a black-holed one.
+func dialHappyEyeballs(ctx context.Context, addr string, tlsConf *tls.Config, config *Config) (*Connection, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("splitting %s: %w", addr, err)
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	portNum, err := net.DefaultResolver.LookupPort(ctx, "udp", port)
+	if err != nil {
+		return nil, fmt.Errorf("resolving port %s: %w", port, err)
+	}
+	if len(ips) == 1 {
+		return dialUDPAddr(ctx, &net.UDPAddr{IP: ips[0], Port: portNum}, tlsConf, config, false)
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	const stagger = 250 * time.Millisecond
+	results := make(chan dialResult, len(ips))
+	for i, ip := range ips {
+		go func(delay time.Duration, remoteAddr *net.UDPAddr) {
+			select {
+			case <-attemptCtx.Done():
+				results <- dialResult{err: attemptCtx.Err()}
+				return
+			case <-time.After(delay):
+			}
+			conn, err := dialUDPAddr(attemptCtx, remoteAddr, tlsConf, config, false)
+			results <- dialResult{conn: conn, err: err}
+		}(time.Duration(i)*stagger, &net.UDPAddr{IP: ip, Port: portNum})
+	}
+
+	var firstErr error
+	for range ips {
+		res := <-results
+		if res.err == nil {
+			// Losing attempts are cancelled and close themselves.
+			return res.conn, nil
+		}
+		if firstErr == nil {
+			firstErr = res.err
+		}
+	}
+	return nil, firstErr
+}
+
+type dialResult struct {
+	conn *Connection
+	err  error
+}
+
+func dialUDPAddr(ctx context.Context, remoteAddr *net.UDPAddr, tlsConf *tls.Config, config *Config, early bool) (*Connection, error) {
+	udpConn, err := net.ListenUDP(config.Network, nil)
+	if err != nil {
+		return nil, fmt.Errorf("binding local socket: %w", err)
+	}
+	setSocketBuffers(udpConn, config)
+	if !config.DisableECN {
+		// Best effort: without OOB support every datagram counts as Not-ECT.
+		enableECNReceiving(udpConn)
+	}
+
+	localConnID, err := generateConnectionIDFor(config)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	destConnID, err := generateConnectionID()
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+
+	conn, err := newConnection(udpConn, remoteAddr, true, true, config, tlsConf,
+		localConnID, destConnID, destConnID)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	cc := &clientConnection{Connection: conn}
+	return startClientConnection(ctx, cc.Connection, early)
+}
+
+// startClientConnection launches the connection's loops and runs the client
+// side of the handshake. With early set it returns as soon as the first
+// flight is queued.
+func startClientConnection(ctx context.Context, conn *Connection, early bool) (*Connection, error) {
+	conn.Start()
+
+	if err := conn.tlsManager.StartHandshake(); err != nil {
+		conn.closeWithLocalError(err)
+		return nil, err
+	}
+	conn.signalSend()
+
+	if early {
+		return conn, nil
+	}
+	if err := conn.tlsManager.WaitForHandshake(ctx); err != nil {
+		conn.closeWithLocalError(err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classifyHandshakeError(err)
+	}
+	return conn, nil
+}
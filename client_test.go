@@ -0,0 +1,350 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeCompleteChannel(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.Accept(context.Background())
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// DialAddr blocks until the handshake completes, so the channel must
+	// already be closed.
+	select {
+	case <-conn.HandshakeComplete():
+	default:
+		t.Fatal("HandshakeComplete channel not closed after successful dial")
+	}
+}
+
+func TestHandshakeCompleteClosedOnConnectionFailure(t *testing.T) {
+	// Dial a blackhole so the handshake never finishes, then close the
+	// connection and check the channel unblocks.
+	conn, err := DialAddrEarly("127.0.0.1:1",
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case <-conn.HandshakeComplete():
+	case <-time.After(time.Second):
+		t.Fatal("HandshakeComplete channel not closed after connection failure")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	// A bare UDP socket that never answers stands in for an unresponsive
+	// server.
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	start := time.Now()
+	_, err = DialAddr(sink.LocalAddr().String(),
+		&tls.Config{InsecureSkipVerify: true},
+		&Config{HandshakeIdleTimeout: 200 * time.Millisecond})
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("DialAddr error = %v, want ErrHandshakeTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("timeout took %v, want roughly 200ms", elapsed)
+	}
+}
+
+func TestDialContextCancellation(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := DialContext(ctx, sink.LocalAddr().String(),
+			&tls.Config{InsecureSkipVerify: true}, nil)
+		errCh <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("DialContext error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("DialContext did not return after cancellation")
+	}
+}
+
+func TestHappyEyeballsDialsReachableAddress(t *testing.T) {
+	ips, err := net.DefaultResolver.LookupIP(context.Background(), "ip", "localhost")
+	if err != nil || len(ips) == 0 {
+		t.Skipf("cannot resolve localhost: %v", err)
+	}
+
+	// The server listens on IPv4 only, so any IPv6 candidate for localhost
+	// is effectively black-holed.
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	port := ln.Addr().(*net.UDPAddr).Port
+	conn, err := DialAddr(fmt.Sprintf("localhost:%d", port),
+		&tls.Config{InsecureSkipVerify: true},
+		&Config{HappyEyeballs: true, HandshakeIdleTimeout: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("happy eyeballs dial failed: %v", err)
+	}
+	defer conn.Close()
+	if !conn.ConnectionState().HandshakeComplete {
+		t.Error("handshake not complete on winning connection")
+	}
+}
+
+func TestZeroRTTReplayDeliversExactlyOnce(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 256)
+		for {
+			n, err := s.Read(buf)
+			if n > 0 {
+				s.Write(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddrEarly(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const payload = "zero-rtt data"
+	if _, err := s.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate the server rejecting 0-RTT: the buffered early writes are
+	// replayed through the 1-RTT path.
+	conn.replayZeroRTT()
+
+	// The echo must contain the payload exactly once even though the bytes
+	// were transmitted twice.
+	s.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2*len(payload))
+	n, err := s.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if m, err := s.Read(buf[n:]); err == nil {
+		n += m
+	}
+	if string(buf[:n]) != payload {
+		t.Fatalf("echoed %q, want %q exactly once", buf[:n], payload)
+	}
+}
+
+func TestDialEarlyOpensStreamBeforeHandshake(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 64)
+		n, _ := s.Read(buf)
+		s.Write(buf[:n])
+	}()
+
+	conn, err := DialAddrEarly(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The stream must be usable before the handshake has completed.
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream before handshake: %v", err)
+	}
+	if _, err := s.Write([]byte("early")); err != nil {
+		t.Fatalf("Write before handshake: %v", err)
+	}
+
+	select {
+	case <-conn.HandshakeComplete():
+	case <-time.After(3 * time.Second):
+		t.Fatal("handshake did not complete")
+	}
+	if !conn.ConnectionState().HandshakeComplete {
+		t.Fatal("handshake channel closed but state reports incomplete")
+	}
+
+	s.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 16)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "early" {
+		t.Fatalf("echo of early data failed: n=%d err=%v", n, err)
+	}
+}
+
+func TestWaitUntilEstablished(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddrEarly(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := conn.WaitUntilEstablished(ctx); err != nil {
+		t.Fatalf("WaitUntilEstablished = %v, want nil", err)
+	}
+}
+
+func TestWaitUntilEstablishedSurfacesHandshakeError(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	conn, err := DialAddrEarly(sink.LocalAddr().String(),
+		&tls.Config{InsecureSkipVerify: true},
+		&Config{HandshakeIdleTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WaitUntilEstablished(context.Background()); !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("WaitUntilEstablished = %v, want ErrHandshakeTimeout", err)
+	}
+}
+
+func TestHandshakeErrorCertificateCategory(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.Accept(context.Background())
+
+	// Without InsecureSkipVerify the client rejects the listener's
+	// self-signed certificate.
+	_, err = DialAddr(ln.Addr().String(), &tls.Config{}, nil)
+	var hsErr *HandshakeError
+	if !errors.As(err, &hsErr) {
+		t.Fatalf("DialAddr = %v, want HandshakeError", err)
+	}
+	if hsErr.Category != HandshakeCertificate {
+		t.Errorf("Category = %v, want %v", hsErr.Category, HandshakeCertificate)
+	}
+	if hsErr.Alert != alertBadCertificate {
+		t.Errorf("Alert = %d, want %d", hsErr.Alert, alertBadCertificate)
+	}
+}
+
+func TestHandshakeErrorALPNMismatchCategory(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", &tls.Config{NextProtos: []string{"alpha"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.Accept(context.Background())
+
+	_, err = DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"beta"}}, nil)
+	var hsErr *HandshakeError
+	if !errors.As(err, &hsErr) {
+		t.Fatalf("DialAddr = %v, want HandshakeError", err)
+	}
+	if hsErr.Category != HandshakeProtocol {
+		t.Errorf("Category = %v, want %v", hsErr.Category, HandshakeProtocol)
+	}
+	if hsErr.Alert != alertNoApplicationProtocol {
+		t.Errorf("Alert = %d, want %d", hsErr.Alert, alertNoApplicationProtocol)
+	}
+}
@@ -0,0 +1,27 @@
+package quic
+
+import "time"
+
+// Clock is the time source behind a connection's timers. The default is the
+// system clock; tests substitute a manually advanced implementation to drive
+// loss detection, pacing and idle timeouts without real delays.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is the subset of *time.Timer the connection needs from timers
+// created through a Clock.
+type ClockTimer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// systemClock is the default Clock, backed by package time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,96 @@
+package quic
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockClock is a manually advanced Clock: timers fire only when Advance
+// crosses their deadline, so timer-dependent logic runs without sleeping.
+type mockClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+type mockTimer struct {
+	clock   *mockClock
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func newMockClock() *mockClock {
+	return &mockClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *mockClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &mockTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward and fires every timer that comes due, in
+// deadline order, outside the clock's lock so callbacks may re-arm timers.
+func (c *mockClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	var due []*mockTimer
+	for _, t := range c.timers {
+		if !t.stopped && !t.when.After(c.now) {
+			t.stopped = true
+			due = append(due, t)
+		}
+	}
+	c.mutex.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].when.Before(due[j].when) })
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func (t *mockTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := !t.stopped
+	t.stopped = true
+	return active
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := !t.stopped
+	t.stopped = false
+	t.when = t.clock.now.Add(d)
+	return active
+}
+
+func TestMockClockFiresTimersInOrder(t *testing.T) {
+	clock := newMockClock()
+	var fired []int
+	clock.AfterFunc(20*time.Millisecond, func() { fired = append(fired, 2) })
+	clock.AfterFunc(10*time.Millisecond, func() { fired = append(fired, 1) })
+	late := clock.AfterFunc(30*time.Millisecond, func() { fired = append(fired, 3) })
+
+	clock.Advance(5 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("timers fired %v before their deadlines", fired)
+	}
+	late.Stop()
+	clock.Advance(25 * time.Millisecond)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 2 {
+		t.Errorf("fired = %v, want [1 2] in deadline order with 3 stopped", fired)
+	}
+}
@@ -0,0 +1,390 @@
+package quic
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/phuhao00/QUIC/congestion"
+)
+
+// minUDPPayloadSize is the smallest max_udp_payload_size QUIC permits
+// (RFC 9000 section 18.2).
+const minUDPPayloadSize = 1200
+
+// Default values used to fill in unset Config fields.
+const (
+	defaultHandshakeIdleTimeout    = 5 * time.Second
+	defaultMaxIdleTimeout          = 30 * time.Second
+	defaultInitialStreamWindow     = 512 * 1024
+	defaultMaxStreamWindow         = 6 * 1024 * 1024
+	defaultInitialConnectionWindow = 768 * 1024
+	defaultMaxConnectionWindow     = 15 * 1024 * 1024
+	defaultMaxIncomingStreams      = 100
+	defaultMaxIncomingUniStreams   = 100
+	defaultMaxUDPPayloadSize       = 1452
+	// defaultInitialCongestionWindow is the RFC 9002 recommended initial
+	// congestion window, in packets.
+	defaultInitialCongestionWindow = 10
+	// maxInitialCongestionWindow bounds Config.InitialCongestionWindow;
+	// anything beyond this is a misconfiguration, not tuning.
+	maxInitialCongestionWindow = 256
+)
+
+// ClientHelloInfo describes an incoming connection attempt, as far as it is
+// known once the client hello has been parsed. It is the argument to
+// Config.GetConfigForClient.
+type ClientHelloInfo struct {
+	// ServerName is the SNI value sent by the client; empty if none.
+	ServerName string
+	// OfferedProtocols lists the ALPN protocols the client offered, in
+	// the order offered.
+	OfferedProtocols []string
+	// RemoteAddr is the client's address.
+	RemoteAddr net.Addr
+}
+
+// ConnectionIDGenerator produces the local connection IDs of new
+// connections, letting operators embed routing information in the ID for
+// load-balanced deployments. GenerateConnectionID must return IDs of exactly
+// ConnectionIDLen bytes.
+type ConnectionIDGenerator interface {
+	GenerateConnectionID() ([]byte, error)
+	ConnectionIDLen() int
+}
+
+// EarlyDataReplayFilter decides whether a 0-RTT packet is a replay, keyed by
+// the connection's original destination ID and the packet number. A shared
+// implementation lets a server fleet reject replays across machines.
+// Implementations must be safe for concurrent use.
+type EarlyDataReplayFilter interface {
+	// Seen records the packet and reports whether it was already present.
+	Seen(connID []byte, packetNumber uint64) bool
+}
+
+// CongestionControlAlgorithm selects the algorithm behind a connection's
+// congestion controller.
+type CongestionControlAlgorithm int
+
+const (
+	// CongestionCubic is the default loss-based CUBIC controller.
+	CongestionCubic CongestionControlAlgorithm = iota
+	// CongestionBBR paces around the measured bottleneck bandwidth and
+	// round-trip time instead of backing off on loss.
+	CongestionBBR
+)
+
+// ConnectionTracer bundles optional callbacks observing the internals of a
+// connection. Unset callbacks are simply not invoked.
+type ConnectionTracer struct {
+	// CongestionStateChanged is invoked when the congestion controller
+	// moves between its phases, e.g. from slow start into fast recovery
+	// after a loss.
+	CongestionStateChanged func(from, to congestion.CubicState)
+	// UDPBufferClamped is invoked when the OS grants a smaller socket
+	// buffer than the config requested. kind is "receive" or "send".
+	UDPBufferClamped func(kind string, requested, granted uint64)
+	// StreamWriteAckLatency is invoked with one sample per acknowledged
+	// range of stream data: the time from the range being handed to the
+	// send loop until the peer acknowledged it.
+	StreamWriteAckLatency func(streamID uint64, latency time.Duration)
+	// StreamReassemblyGap is invoked when a hole at a stream's read offset
+	// fills, with how long in-order delivery stalled waiting for the
+	// missing fragment.
+	StreamReassemblyGap func(streamID uint64, gap time.Duration)
+}
+
+// Config holds the tunable options of a connection. A nil Config is
+// equivalent to DefaultConfig().
+type Config struct {
+	// Versions are the QUIC versions offered during the handshake.
+	Versions []uint32
+	// Network selects the address family for the UDP socket: "udp"
+	// (dual-stack), "udp4" or "udp6". Empty means "udp".
+	Network string
+	// HappyEyeballs races a connection attempt per resolved address when
+	// dialing a hostname and keeps the first handshake that completes.
+	HappyEyeballs bool
+	// ReceiveBufferSize, when nonzero, asks the kernel for this much UDP
+	// receive buffer (SO_RCVBUF), so bursts do not overflow it and drop
+	// packets. The OS may clamp the request; ConnectionTracer's
+	// UDPBufferClamped reports when it does.
+	ReceiveBufferSize uint64
+	// SendBufferSize is the SO_SNDBUF counterpart of ReceiveBufferSize.
+	SendBufferSize uint64
+	// ReusePort binds the listener's socket with SO_REUSEPORT, letting
+	// several listeners share one port so the kernel spreads load across
+	// them, one per core. The kernel hashes the 4-tuple, not the connection
+	// ID, so every listener on the port must route connection IDs the same
+	// way — in practice, share one ConnectionIDGenerator scheme across
+	// them. Unsupported platforms reject a config with this set.
+	ReusePort bool
+	// MaxFramesPerPacket bounds how many frames one packet's payload may
+	// carry, closing the connection with PROTOCOL_VIOLATION when a peer
+	// exceeds it. Zero uses packet.DefaultMaxFramesPerPacket.
+	MaxFramesPerPacket int
+	// DebugStreamChecksums is a debugging aid for the stream reassembly
+	// path: the sender attaches a CRC-32 over each finished stream's data
+	// in a private extension frame, and the receiver verifies it once the
+	// application has consumed the stream, closing the connection on a
+	// mismatch. Both endpoints must enable it; the frame is ignored
+	// otherwise.
+	DebugStreamChecksums bool
+	// AckFrequency makes the endpoint acknowledge every Nth ack-eliciting
+	// packet instead of the default every second one, cutting ACK overhead
+	// on high-bandwidth links. Reordered packets and the delayed-ACK timer
+	// still force an ACK out, so the peer's loss recovery keeps working
+	// with sparser acknowledgments. Zero means the default.
+	AckFrequency int
+	// DisableECN stops the endpoint from reading the ECN bits of received
+	// datagrams, so ACK frames never carry ECN counts.
+	DisableECN bool
+	// MaxSendRate caps the connection's average send rate in bytes per
+	// second, on top of whatever congestion control allows. Zero means
+	// unlimited.
+	MaxSendRate uint64
+	// ConnectionIDGenerator, when set, produces the local connection IDs of
+	// new connections instead of the default random generator. Connections
+	// sharing a socket must use IDs of one length.
+	ConnectionIDGenerator ConnectionIDGenerator
+	// GetConfigForClient, set on a listener's config, is invoked for every
+	// incoming connection once the client hello arrives, analogous to
+	// tls.Config.GetConfigForClient. A non-nil result replaces the
+	// connection's flow-control and stream limits; a nil result keeps the
+	// listener's config; an error rejects the connection.
+	GetConfigForClient func(info ClientHelloInfo) (*Config, error)
+	// RequireAddressValidation, set on a listener's config, makes the server
+	// answer every Initial from an unvalidated address with a Retry and only
+	// create connections for clients that echo a valid token.
+	RequireAddressValidation bool
+	// ConnectionIDRotation, when nonzero, issues the peer a fresh connection
+	// ID at this interval and asks it to retire the earlier ones, limiting
+	// linkability across path changes. Zero disables rotation.
+	ConnectionIDRotation time.Duration
+	// ConnectionTracer, when set, receives callbacks as the connection's
+	// internal state evolves, for diagnostics such as throughput analysis.
+	ConnectionTracer *ConnectionTracer
+	// EarlyDataReplayFilter, when set, replaces the built-in per-connection
+	// anti-replay window for 0-RTT packets, e.g. with a cache shared across
+	// servers.
+	EarlyDataReplayFilter EarlyDataReplayFilter
+	// HandshakeIdleTimeout bounds the duration of the handshake.
+	HandshakeIdleTimeout time.Duration
+	// MaxIdleTimeout closes the connection if no packet is received for
+	// this long.
+	MaxIdleTimeout time.Duration
+	// InitialStreamReceiveWindow is the per-stream flow control window
+	// advertised at stream creation.
+	InitialStreamReceiveWindow uint64
+	// MaxStreamReceiveWindow caps how far the per-stream window can grow.
+	MaxStreamReceiveWindow uint64
+	// InitialConnectionReceiveWindow is the connection-level flow control
+	// window advertised during the handshake.
+	InitialConnectionReceiveWindow uint64
+	// MaxConnectionReceiveWindow caps the connection-level window.
+	MaxConnectionReceiveWindow uint64
+	// MaxIncomingStreams limits the number of bidirectional streams the
+	// peer may open.
+	MaxIncomingStreams int64
+	// MaxIncomingUniStreams limits the number of unidirectional streams
+	// the peer may open.
+	MaxIncomingUniStreams int64
+	// PreferredAddress lets a server advertise an address the client
+	// should migrate to once the handshake completes (the
+	// preferred_address transport parameter). Only servers use it; nil
+	// advertises nothing.
+	PreferredAddress net.Addr
+	// DisableActiveMigration advertises the disable_active_migration
+	// transport parameter: the peer must keep using the address it
+	// performed the handshake from, and this endpoint keeps dropping
+	// packets that arrive from any other address. A client whose peer set
+	// it gets an error from MigrateTo.
+	DisableActiveMigration bool
+	// MaxStreamWaitTimeout bounds how long OpenStreamSync waits for stream
+	// credit before giving up, even when the caller's context has no
+	// deadline. Zero waits indefinitely.
+	MaxStreamWaitTimeout time.Duration
+	// StreamReadBufferSize pre-sizes the per-stream scratch buffer Read
+	// assembles in-order data into, avoiding regrowth under a streaming
+	// workload. Zero sizes it on demand.
+	StreamReadBufferSize uint64
+	// StreamWriteBufferSize pre-sizes the per-stream send buffer so steady
+	// writes reuse one allocation. Zero grows it on demand.
+	StreamWriteBufferSize uint64
+	// CongestionControlAlgorithm selects the congestion controller. The
+	// zero value is CUBIC.
+	CongestionControlAlgorithm CongestionControlAlgorithm
+	// InitialCongestionWindow is the congestion window the connection
+	// starts with, in packets. Zero means the RFC 9002 recommended 10;
+	// deployments that know the path absorbs a larger burst may raise it.
+	InitialCongestionWindow uint64
+	// MaxUDPPayloadSize is the largest UDP payload this endpoint sends.
+	MaxUDPPayloadSize uint64
+	// InitialPacketSize caps UDP payloads below MaxUDPPayloadSize from the
+	// first packet on, for networks whose path MTU is known to be
+	// constrained. Zero means MaxUDPPayloadSize.
+	InitialPacketSize uint64
+	// DisablePathMTUDiscovery keeps the connection at InitialPacketSize for
+	// its whole lifetime: no probe packets are ever sent to grow the
+	// datagram size.
+	DisablePathMTUDiscovery bool
+	// KeepAlivePeriod, when nonzero, sends a PING whenever the connection
+	// has been idle for the given duration.
+	KeepAlivePeriod time.Duration
+	// Clock replaces the time source behind loss, acknowledgment, pacing
+	// and idle timers, so tests can drive them deterministically. Nil means
+	// the system clock.
+	Clock Clock
+}
+
+// DefaultConfig returns a Config with all fields set to their defaults.
+func DefaultConfig() *Config {
+	return &Config{
+		Versions:                       []uint32{1},
+		Network:                        "udp",
+		HandshakeIdleTimeout:           defaultHandshakeIdleTimeout,
+		MaxIdleTimeout:                 defaultMaxIdleTimeout,
+		InitialStreamReceiveWindow:     defaultInitialStreamWindow,
+		MaxStreamReceiveWindow:         defaultMaxStreamWindow,
+		InitialConnectionReceiveWindow: defaultInitialConnectionWindow,
+		MaxConnectionReceiveWindow:     defaultMaxConnectionWindow,
+		MaxIncomingStreams:             defaultMaxIncomingStreams,
+		MaxIncomingUniStreams:          defaultMaxIncomingUniStreams,
+		MaxUDPPayloadSize:              defaultMaxUDPPayloadSize,
+		InitialCongestionWindow:        defaultInitialCongestionWindow,
+		Clock:                          systemClock{},
+	}
+}
+
+// Clone returns a deep copy of the config. It accepts nil.
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	if c.Versions != nil {
+		clone.Versions = append([]uint32(nil), c.Versions...)
+	}
+	return &clone
+}
+
+// Validate checks the configuration for inconsistent or out-of-range values.
+// Zero values are allowed everywhere; they are replaced by defaults later.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Network {
+	case "", "udp", "udp4", "udp6":
+	default:
+		return fmt.Errorf("quic: Network must be \"udp\", \"udp4\" or \"udp6\", got %q", c.Network)
+	}
+	if c.HandshakeIdleTimeout < 0 {
+		return fmt.Errorf("quic: HandshakeIdleTimeout must not be negative, got %v", c.HandshakeIdleTimeout)
+	}
+	if c.MaxIdleTimeout < 0 {
+		return fmt.Errorf("quic: MaxIdleTimeout must not be negative, got %v", c.MaxIdleTimeout)
+	}
+	if c.KeepAlivePeriod < 0 {
+		return fmt.Errorf("quic: KeepAlivePeriod must not be negative, got %v", c.KeepAlivePeriod)
+	}
+	if c.AckFrequency < 0 {
+		return fmt.Errorf("quic: AckFrequency must not be negative, got %d", c.AckFrequency)
+	}
+	if c.MaxFramesPerPacket < 0 {
+		return fmt.Errorf("quic: MaxFramesPerPacket must not be negative, got %d", c.MaxFramesPerPacket)
+	}
+	if c.MaxStreamReceiveWindow != 0 && c.InitialStreamReceiveWindow > c.MaxStreamReceiveWindow {
+		return fmt.Errorf("quic: MaxStreamReceiveWindow (%d) must not be smaller than InitialStreamReceiveWindow (%d)",
+			c.MaxStreamReceiveWindow, c.InitialStreamReceiveWindow)
+	}
+	if c.MaxConnectionReceiveWindow != 0 && c.InitialConnectionReceiveWindow > c.MaxConnectionReceiveWindow {
+		return fmt.Errorf("quic: MaxConnectionReceiveWindow (%d) must not be smaller than InitialConnectionReceiveWindow (%d)",
+			c.MaxConnectionReceiveWindow, c.InitialConnectionReceiveWindow)
+	}
+	if c.MaxUDPPayloadSize != 0 && c.MaxUDPPayloadSize < minUDPPayloadSize {
+		return fmt.Errorf("quic: MaxUDPPayloadSize must be at least %d, got %d", minUDPPayloadSize, c.MaxUDPPayloadSize)
+	}
+	if c.InitialPacketSize != 0 && c.InitialPacketSize < minUDPPayloadSize {
+		return fmt.Errorf("quic: InitialPacketSize must be at least %d, got %d", minUDPPayloadSize, c.InitialPacketSize)
+	}
+	if c.MaxIncomingStreams < 0 {
+		return fmt.Errorf("quic: MaxIncomingStreams must not be negative, got %d", c.MaxIncomingStreams)
+	}
+	if c.MaxIncomingUniStreams < 0 {
+		return fmt.Errorf("quic: MaxIncomingUniStreams must not be negative, got %d", c.MaxIncomingUniStreams)
+	}
+	switch c.CongestionControlAlgorithm {
+	case CongestionCubic, CongestionBBR:
+	default:
+		return fmt.Errorf("quic: unknown CongestionControlAlgorithm %d", c.CongestionControlAlgorithm)
+	}
+	if c.InitialCongestionWindow > maxInitialCongestionWindow {
+		return fmt.Errorf("quic: InitialCongestionWindow must be at most %d packets, got %d", maxInitialCongestionWindow, c.InitialCongestionWindow)
+	}
+	if c.ConnectionIDGenerator != nil {
+		if l := c.ConnectionIDGenerator.ConnectionIDLen(); l < 1 || l > 20 {
+			return fmt.Errorf("quic: ConnectionIDGenerator length must be 1 to 20 bytes, got %d", l)
+		}
+	}
+	return nil
+}
+
+// connIDLength returns the length of locally generated connection IDs.
+func (c *Config) connIDLength() int {
+	if c != nil && c.ConnectionIDGenerator != nil {
+		return c.ConnectionIDGenerator.ConnectionIDLen()
+	}
+	return connIDLen
+}
+
+// populateConfig returns a private copy of c with defaults filled in for
+// unset fields, so later mutation by the caller cannot race with the
+// connection. It accepts nil.
+func populateConfig(c *Config) *Config {
+	if c == nil {
+		return DefaultConfig()
+	}
+	c = c.Clone()
+	defaults := DefaultConfig()
+	if len(c.Versions) == 0 {
+		c.Versions = defaults.Versions
+	}
+	if c.Network == "" {
+		c.Network = "udp"
+	}
+	if c.HandshakeIdleTimeout == 0 {
+		c.HandshakeIdleTimeout = defaults.HandshakeIdleTimeout
+	}
+	if c.MaxIdleTimeout == 0 {
+		c.MaxIdleTimeout = defaults.MaxIdleTimeout
+	}
+	if c.InitialStreamReceiveWindow == 0 {
+		c.InitialStreamReceiveWindow = defaults.InitialStreamReceiveWindow
+	}
+	if c.MaxStreamReceiveWindow == 0 {
+		c.MaxStreamReceiveWindow = defaults.MaxStreamReceiveWindow
+	}
+	if c.InitialConnectionReceiveWindow == 0 {
+		c.InitialConnectionReceiveWindow = defaults.InitialConnectionReceiveWindow
+	}
+	if c.MaxConnectionReceiveWindow == 0 {
+		c.MaxConnectionReceiveWindow = defaults.MaxConnectionReceiveWindow
+	}
+	if c.MaxIncomingStreams == 0 {
+		c.MaxIncomingStreams = defaults.MaxIncomingStreams
+	}
+	if c.MaxIncomingUniStreams == 0 {
+		c.MaxIncomingUniStreams = defaults.MaxIncomingUniStreams
+	}
+	if c.MaxUDPPayloadSize == 0 {
+		c.MaxUDPPayloadSize = defaults.MaxUDPPayloadSize
+	}
+	if c.InitialCongestionWindow == 0 {
+		c.InitialCongestionWindow = defaults.InitialCongestionWindow
+	}
+	if c.Clock == nil {
+		c.Clock = systemClock{}
+	}
+	return c
+}
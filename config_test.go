@@ -0,0 +1,126 @@
+package quic
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr string
+	}{
+		{"nil", nil, ""},
+		{"default", DefaultConfig(), ""},
+		{"zero", &Config{}, ""},
+		{
+			"negative handshake timeout",
+			&Config{HandshakeIdleTimeout: -time.Second},
+			"HandshakeIdleTimeout",
+		},
+		{
+			"negative idle timeout",
+			&Config{MaxIdleTimeout: -time.Second},
+			"MaxIdleTimeout",
+		},
+		{
+			"negative keepalive",
+			&Config{KeepAlivePeriod: -time.Second},
+			"KeepAlivePeriod",
+		},
+		{
+			"stream window inversion",
+			&Config{InitialStreamReceiveWindow: 1 << 20, MaxStreamReceiveWindow: 1 << 10},
+			"MaxStreamReceiveWindow",
+		},
+		{
+			"connection window inversion",
+			&Config{InitialConnectionReceiveWindow: 1 << 20, MaxConnectionReceiveWindow: 1 << 10},
+			"MaxConnectionReceiveWindow",
+		},
+		{
+			"udp payload below minimum",
+			&Config{MaxUDPPayloadSize: 1199},
+			"MaxUDPPayloadSize",
+		},
+		{
+			"negative incoming streams",
+			&Config{MaxIncomingStreams: -1},
+			"MaxIncomingStreams",
+		},
+		{
+			"negative incoming uni streams",
+			&Config{MaxIncomingUniStreams: -1},
+			"MaxIncomingUniStreams",
+		},
+		{
+			"oversized initial congestion window",
+			&Config{InitialCongestionWindow: maxInitialCongestionWindow + 1},
+			"InitialCongestionWindow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialRejectsInvalidConfig(t *testing.T) {
+	_, err := DialContext(context.Background(), "127.0.0.1:1", nil,
+		&Config{MaxUDPPayloadSize: 100})
+	if err == nil || !strings.Contains(err.Error(), "MaxUDPPayloadSize") {
+		t.Fatalf("DialContext = %v, want config validation error", err)
+	}
+}
+
+func TestListenRejectsInvalidConfig(t *testing.T) {
+	_, err := ListenAddr("127.0.0.1:0", nil, &Config{MaxIdleTimeout: -time.Second})
+	if err == nil || !strings.Contains(err.Error(), "MaxIdleTimeout") {
+		t.Fatalf("ListenAddr = %v, want config validation error", err)
+	}
+}
+
+func TestConfigClone(t *testing.T) {
+	original := &Config{
+		Versions:             []uint32{1, 2},
+		MaxIdleTimeout:       time.Minute,
+		MaxIncomingStreams:   7,
+		HandshakeIdleTimeout: time.Second,
+	}
+	clone := original.Clone()
+	if clone == original {
+		t.Fatal("Clone returned the same pointer")
+	}
+	original.Versions[0] = 99
+	original.MaxIdleTimeout = 0
+	if clone.Versions[0] != 1 || clone.MaxIdleTimeout != time.Minute {
+		t.Errorf("clone was affected by mutating the original: %+v", clone)
+	}
+	var nilConfig *Config
+	if nilConfig.Clone() != nil {
+		t.Error("Clone of nil should be nil")
+	}
+}
+
+func TestPopulateConfigDoesNotAliasCaller(t *testing.T) {
+	original := &Config{Versions: []uint32{1}, MaxIncomingStreams: 5}
+	populated := populateConfig(original)
+	original.MaxIncomingStreams = 50
+	original.Versions[0] = 99
+	if populated.MaxIncomingStreams != 5 || populated.Versions[0] != 1 {
+		t.Errorf("populated config aliases the caller's config: %+v", populated)
+	}
+}
@@ -0,0 +1,375 @@
+package congestion
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// CongestionControl is the surface the connection drives its congestion
+// controller through. CubicCongestionControl and BBRCongestionControl both
+// implement it.
+type CongestionControl interface {
+	CanSend(bytes uint64) bool
+	OnPacketSent(bytes uint64)
+	OnPacketAcked(bytes uint64, rtt time.Duration)
+	OnPacketLost(bytes uint64)
+	SetClock(clock Clock)
+	SetCongestionWindow(bytes uint64)
+	SetMaxDatagramSize(size uint64)
+	GetStats() CongestionStats
+}
+
+// BBRState identifies the phase the BBR controller is in.
+type BBRState int
+
+const (
+	BBRStateStartup BBRState = iota
+	BBRStateDrain
+	BBRStateProbeBW
+	BBRStateProbeRTT
+)
+
+func (s BBRState) String() string {
+	switch s {
+	case BBRStateStartup:
+		return "Startup"
+	case BBRStateDrain:
+		return "Drain"
+	case BBRStateProbeBW:
+		return "ProbeBW"
+	case BBRStateProbeRTT:
+		return "ProbeRTT"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// bbrHighGain is the startup pacing gain 2/ln(2), chosen so the send
+	// rate doubles every round while the pipe is still filling.
+	bbrHighGain = 2.885
+	// bbrDrainGain empties the queue startup built up.
+	bbrDrainGain = 1 / bbrHighGain
+	// bbrFullBwThreshold is the growth a bandwidth sample must show for
+	// startup to conclude the pipe is not yet full.
+	bbrFullBwThreshold = 1.25
+	// bbrFullBwCount is how many non-growing samples in a row mean the
+	// pipe is full.
+	bbrFullBwCount = 3
+	// bbrBandwidthFilterLen is how many recent samples the bandwidth
+	// max-filter spans.
+	bbrBandwidthFilterLen = 10
+	// bbrMinRttExpiry is how long a min-RTT measurement stays valid before
+	// the controller drains the pipe to re-measure it.
+	bbrMinRttExpiry = 10 * time.Second
+	// bbrProbeRttDuration is how long ProbeRTT holds the window at the
+	// minimum.
+	bbrProbeRttDuration = 200 * time.Millisecond
+)
+
+// bbrPacingGainCycle is the ProbeBW gain schedule: probe above the estimated
+// bandwidth for one phase, drain the resulting queue, then cruise.
+var bbrPacingGainCycle = [...]float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// BBRCongestionControl implements a model-based controller after BBR: it
+// paces around the measured bottleneck bandwidth and round-trip propagation
+// time instead of backing off on loss.
+type BBRCongestionControl struct {
+	mutex sync.Mutex
+	clock Clock
+
+	state            BBRState
+	maxDatagramSize  uint64
+	congestionWindow uint64
+	minCwnd          uint64
+	maxCwnd          uint64
+	bytesInFlight    uint64
+
+	// Bandwidth max-filter over recent delivery-rate samples.
+	bwSamples     [bbrBandwidthFilterLen]float64
+	bwSampleIndex int
+	bwSampleStart time.Time
+	bwSampleBytes uint64
+
+	// Min-RTT filter.
+	minRtt     time.Duration
+	minRttTime time.Time
+
+	// Startup pipe-full detection.
+	fullBandwidth float64
+	fullBwCount   int
+
+	// ProbeBW gain cycling and ProbeRTT timing.
+	gainCycleIndex int
+	cycleStart     time.Time
+	probeRttStart  time.Time
+}
+
+// NewBBRCongestionControl creates a BBR controller for the given datagram
+// size. initialWindow is the starting congestion window in packets; zero
+// means the RFC 9002 recommended value.
+func NewBBRCongestionControl(maxDatagramSize, initialWindow uint64) *BBRCongestionControl {
+	if initialWindow == 0 {
+		initialWindow = initialWindowPackets
+	}
+	b := &BBRCongestionControl{
+		clock:           systemClock{},
+		state:           BBRStateStartup,
+		maxDatagramSize: maxDatagramSize,
+		minCwnd:         minWindowPackets * maxDatagramSize,
+		maxCwnd:         maxWindowPackets * maxDatagramSize,
+	}
+	b.congestionWindow = initialWindow * maxDatagramSize
+	return b
+}
+
+// State returns the BBR phase the controller is in.
+func (b *BBRCongestionControl) State() BBRState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// CanSend reports whether the controller allows sending the given number of
+// additional bytes.
+func (b *BBRCongestionControl) CanSend(bytes uint64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.bytesInFlight+bytes <= b.congestionWindow
+}
+
+// SetCongestionWindow forces the window to the given size. Intended for
+// tests that need a known send budget.
+func (b *BBRCongestionControl) SetCongestionWindow(bytes uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.congestionWindow = bytes
+}
+
+// SetMaxDatagramSize adjusts the controller to a new datagram size, e.g.
+// after path MTU discovery grows the packets.
+func (b *BBRCongestionControl) SetMaxDatagramSize(size uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.maxDatagramSize = size
+	b.minCwnd = minWindowPackets * size
+	b.maxCwnd = maxWindowPackets * size
+	if b.congestionWindow < b.minCwnd {
+		b.congestionWindow = b.minCwnd
+	}
+	if b.congestionWindow > b.maxCwnd {
+		b.congestionWindow = b.maxCwnd
+	}
+}
+
+// SetClock replaces the controller's time source. Call it before the
+// controller carries traffic.
+func (b *BBRCongestionControl) SetClock(clock Clock) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.clock = clock
+}
+
+// OnPacketSent records bytes entering the network.
+func (b *BBRCongestionControl) OnPacketSent(bytes uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bytesInFlight += bytes
+}
+
+// OnPacketAcked updates the bandwidth and RTT models and advances the state
+// machine.
+func (b *BBRCongestionControl) OnPacketAcked(bytes uint64, rtt time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if bytes > b.bytesInFlight {
+		b.bytesInFlight = 0
+	} else {
+		b.bytesInFlight -= bytes
+	}
+	now := b.clock.Now()
+
+	if rtt > 0 && (b.minRtt == 0 || rtt <= b.minRtt) {
+		b.minRtt = rtt
+		b.minRttTime = now
+	}
+	if b.sampleBandwidthLocked(bytes, now) && b.state == BBRStateStartup {
+		b.checkFullPipeLocked()
+	}
+	b.advanceStateLocked(now)
+	b.updateWindowLocked(bytes)
+}
+
+// OnPacketLost releases in-flight bytes. BBR's model is rate-based, so a
+// single loss does not collapse the window the way loss-based controllers
+// do; the bandwidth filter already reflects what the path delivered.
+func (b *BBRCongestionControl) OnPacketLost(bytes uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if bytes > b.bytesInFlight {
+		b.bytesInFlight = 0
+	} else {
+		b.bytesInFlight -= bytes
+	}
+}
+
+// sampleBandwidthLocked feeds acked bytes into the max-filter and reports
+// whether a new sample completed.
+func (b *BBRCongestionControl) sampleBandwidthLocked(bytes uint64, now time.Time) bool {
+	if b.bwSampleStart.IsZero() {
+		b.bwSampleStart = now
+		return false
+	}
+	b.bwSampleBytes += bytes
+	elapsed := now.Sub(b.bwSampleStart)
+	if elapsed < bandwidthSampleInterval {
+		return false
+	}
+	sample := float64(b.bwSampleBytes) / elapsed.Seconds()
+	b.bwSamples[b.bwSampleIndex%bbrBandwidthFilterLen] = sample
+	b.bwSampleIndex++
+	b.bwSampleStart = now
+	b.bwSampleBytes = 0
+	return true
+}
+
+// bandwidthLocked returns the max-filtered delivery rate in bytes per second.
+func (b *BBRCongestionControl) bandwidthLocked() float64 {
+	var max float64
+	for _, s := range b.bwSamples {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// bdpLocked returns the bandwidth-delay product, the model's estimate of the
+// data the path holds without queueing. Before both filters have a
+// measurement it falls back to the current window.
+func (b *BBRCongestionControl) bdpLocked() uint64 {
+	bw := b.bandwidthLocked()
+	if bw == 0 || b.minRtt == 0 {
+		return b.congestionWindow
+	}
+	return uint64(bw * b.minRtt.Seconds())
+}
+
+// checkFullPipeLocked watches for the bandwidth estimate to plateau: once
+// three consecutive samples fail to grow by 25%, startup has filled the pipe.
+func (b *BBRCongestionControl) checkFullPipeLocked() {
+	bw := b.bandwidthLocked()
+	if bw >= b.fullBandwidth*bbrFullBwThreshold {
+		b.fullBandwidth = bw
+		b.fullBwCount = 0
+		return
+	}
+	b.fullBwCount++
+}
+
+// advanceStateLocked runs the BBR state machine.
+func (b *BBRCongestionControl) advanceStateLocked(now time.Time) {
+	// An expired min-RTT measurement sends any steady state into ProbeRTT
+	// to drain the pipe and re-measure.
+	if b.state != BBRStateProbeRTT && b.minRtt != 0 &&
+		now.Sub(b.minRttTime) > bbrMinRttExpiry {
+		b.state = BBRStateProbeRTT
+		b.probeRttStart = now
+		return
+	}
+	switch b.state {
+	case BBRStateStartup:
+		if b.fullBwCount >= bbrFullBwCount {
+			b.state = BBRStateDrain
+		}
+	case BBRStateDrain:
+		if b.bytesInFlight <= b.bdpLocked() {
+			b.state = BBRStateProbeBW
+			b.gainCycleIndex = 0
+			b.cycleStart = now
+		}
+	case BBRStateProbeBW:
+		// Rotate the pacing gain once per min-RTT.
+		interval := b.minRtt
+		if interval == 0 {
+			interval = bbrProbeRttDuration
+		}
+		if now.Sub(b.cycleStart) >= interval {
+			b.gainCycleIndex = (b.gainCycleIndex + 1) % len(bbrPacingGainCycle)
+			b.cycleStart = now
+		}
+	case BBRStateProbeRTT:
+		if now.Sub(b.probeRttStart) >= bbrProbeRttDuration {
+			b.minRttTime = now
+			b.state = BBRStateProbeBW
+			b.gainCycleIndex = 0
+			b.cycleStart = now
+		}
+	}
+}
+
+// updateWindowLocked sets the congestion window for the current phase.
+func (b *BBRCongestionControl) updateWindowLocked(ackedBytes uint64) {
+	switch b.state {
+	case BBRStateStartup:
+		// Grow exponentially like slow start until the pipe fills.
+		b.congestionWindow += uint64(float64(ackedBytes) * (bbrHighGain - 1))
+	case BBRStateDrain:
+		b.congestionWindow = maxUint64(b.bdpLocked(), b.minCwnd)
+	case BBRStateProbeBW:
+		gain := bbrPacingGainCycle[b.gainCycleIndex]
+		b.congestionWindow = maxUint64(uint64(2*gain*float64(b.bdpLocked())), b.minCwnd)
+	case BBRStateProbeRTT:
+		b.congestionWindow = b.minCwnd
+	}
+	if b.congestionWindow > b.maxCwnd {
+		b.congestionWindow = b.maxCwnd
+	}
+}
+
+// PacingRate returns the rate the sender should pace at, in bytes per
+// second: the filtered bandwidth scaled by the phase's gain. Zero means the
+// model has no bandwidth measurement yet.
+func (b *BBRCongestionControl) PacingRate() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var gain float64
+	switch b.state {
+	case BBRStateStartup:
+		gain = bbrHighGain
+	case BBRStateDrain:
+		gain = bbrDrainGain
+	case BBRStateProbeBW:
+		gain = bbrPacingGainCycle[b.gainCycleIndex]
+	case BBRStateProbeRTT:
+		gain = 1
+	}
+	return uint64(gain * b.bandwidthLocked())
+}
+
+// GetStats returns a snapshot of the controller state. The CUBIC-shaped
+// State field maps Startup to slow start and every other phase to
+// congestion avoidance; the exact BBR phase is available from State().
+func (b *BBRCongestionControl) GetStats() CongestionStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	state := StateCongestionAvoidance
+	if b.state == BBRStateStartup {
+		state = StateSlowStart
+	}
+	return CongestionStats{
+		CongestionWindow:   b.congestionWindow,
+		BytesInFlight:      b.bytesInFlight,
+		SlowStartThresh:    math.MaxUint64,
+		State:              state,
+		EstimatedBandwidth: uint64(b.bandwidthLocked()),
+	}
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
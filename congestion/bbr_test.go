@@ -0,0 +1,98 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+)
+
+// driveBBR feeds acks datagram-sized acknowledgments at the given spacing,
+// keeping enough data in flight that the drain condition is not met early.
+func driveBBR(b *BBRCongestionControl, clock *stepClock, acks int, spacing time.Duration, rtt time.Duration) {
+	for i := 0; i < acks; i++ {
+		b.OnPacketSent(1000)
+		clock.now = clock.now.Add(spacing)
+		b.OnPacketAcked(1000, rtt)
+	}
+}
+
+func TestBBRStartupToDrainOnBandwidthPlateau(t *testing.T) {
+	clock := &stepClock{now: time.Unix(1700000000, 0)}
+	b := NewBBRCongestionControl(1200, 0)
+	b.SetClock(clock)
+
+	if got := b.State(); got != BBRStateStartup {
+		t.Fatalf("initial state = %v, want %v", got, BBRStateStartup)
+	}
+
+	// A steady delivery rate plateaus the bandwidth filter; once three
+	// samples in a row fail to grow, startup concludes the pipe is full.
+	b.OnPacketSent(100_000)
+	driveBBR(b, clock, 100, 10*time.Millisecond, 10*time.Millisecond)
+	if got := b.State(); got != BBRStateDrain {
+		t.Fatalf("state after bandwidth plateau = %v, want %v", got, BBRStateDrain)
+	}
+
+	// Draining below the bandwidth-delay product moves to ProbeBW.
+	for i := 0; i < 100 && b.State() == BBRStateDrain; i++ {
+		clock.now = clock.now.Add(10 * time.Millisecond)
+		b.OnPacketAcked(1000, 10*time.Millisecond)
+	}
+	if got := b.State(); got != BBRStateProbeBW {
+		t.Fatalf("state after draining = %v, want %v", got, BBRStateProbeBW)
+	}
+}
+
+func TestBBRProbeBWCyclesPacingGain(t *testing.T) {
+	clock := &stepClock{now: time.Unix(1700000000, 0)}
+	b := NewBBRCongestionControl(1200, 0)
+	b.SetClock(clock)
+
+	b.OnPacketSent(100_000)
+	driveBBR(b, clock, 100, 10*time.Millisecond, 10*time.Millisecond)
+	for i := 0; i < 100 && b.State() != BBRStateProbeBW; i++ {
+		clock.now = clock.now.Add(10 * time.Millisecond)
+		b.OnPacketAcked(1000, 10*time.Millisecond)
+	}
+	if got := b.State(); got != BBRStateProbeBW {
+		t.Fatalf("state = %v, want %v", got, BBRStateProbeBW)
+	}
+
+	// The gain rotates once per min-RTT: collect the indices seen across a
+	// few cycles and expect the probe (1.25) and drain (0.75) phases both
+	// to appear.
+	seen := map[int]bool{}
+	for i := 0; i < 4*len(bbrPacingGainCycle); i++ {
+		driveBBR(b, clock, 1, 10*time.Millisecond, 10*time.Millisecond)
+		seen[b.gainCycleIndex] = true
+	}
+	if !seen[0] || !seen[1] || !seen[2] {
+		t.Errorf("gain cycle indices seen = %v, want the probe, drain and cruise phases all visited", seen)
+	}
+}
+
+func TestBBRProbeRTTOnMinRttExpiry(t *testing.T) {
+	clock := &stepClock{now: time.Unix(1700000000, 0)}
+	b := NewBBRCongestionControl(1200, 0)
+	b.SetClock(clock)
+
+	b.OnPacketSent(100_000)
+	driveBBR(b, clock, 100, 10*time.Millisecond, 10*time.Millisecond)
+
+	// Without a new RTT floor for bbrMinRttExpiry, the controller drops to
+	// the minimum window to re-measure.
+	clock.now = clock.now.Add(bbrMinRttExpiry + time.Second)
+	b.OnPacketAcked(1000, 50*time.Millisecond)
+	if got := b.State(); got != BBRStateProbeRTT {
+		t.Fatalf("state after min-RTT expiry = %v, want %v", got, BBRStateProbeRTT)
+	}
+	if got := b.GetStats().CongestionWindow; got != 2*1200 {
+		t.Errorf("ProbeRTT window = %d, want the minimum %d", got, 2*1200)
+	}
+
+	// ProbeRTT ends after its dwell time and resumes probing bandwidth.
+	clock.now = clock.now.Add(bbrProbeRttDuration + time.Millisecond)
+	b.OnPacketAcked(1000, 10*time.Millisecond)
+	if got := b.State(); got != BBRStateProbeBW {
+		t.Errorf("state after ProbeRTT dwell = %v, want %v", got, BBRStateProbeBW)
+	}
+}
@@ -0,0 +1,348 @@
+package congestion
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// CubicState identifies the phase the CUBIC controller is in.
+type CubicState int
+
+const (
+	StateSlowStart CubicState = iota
+	StateCongestionAvoidance
+	StateFastRecovery
+)
+
+func (s CubicState) String() string {
+	switch s {
+	case StateSlowStart:
+		return "SlowStart"
+	case StateCongestionAvoidance:
+		return "CongestionAvoidance"
+	case StateFastRecovery:
+		return "FastRecovery"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// cubicC is the CUBIC scaling constant (RFC 8312).
+	cubicC = 0.4
+	// cubicBeta is the multiplicative decrease factor.
+	cubicBeta = 0.7
+	// initialWindowPackets is the RFC 9002 recommended initial window.
+	initialWindowPackets = 10
+	// minWindowPackets is the RFC 9002 minimum congestion window.
+	minWindowPackets = 2
+	// maxWindowPackets caps how far the window can ever grow.
+	maxWindowPackets = 10000
+	// rttSpikeFactor is how far above the observed RTT floor a sample must
+	// land to count as a route change rather than queueing.
+	rttSpikeFactor = 4
+	// bandwidthSampleInterval is how much acked time a delivery-rate sample
+	// must span before it updates the bandwidth estimate.
+	bandwidthSampleInterval = 50 * time.Millisecond
+	// bandwidthGain weights new delivery-rate samples against the running
+	// estimate.
+	bandwidthGain = 0.5
+)
+
+// CongestionStats is a snapshot of the controller's state.
+type CongestionStats struct {
+	CongestionWindow uint64
+	BytesInFlight    uint64
+	SlowStartThresh  uint64
+	State            CubicState
+	// EstimatedBandwidth is the smoothed delivery rate in bytes per second,
+	// sampled from acknowledged data. Zero until enough data has been acked
+	// to form a sample.
+	EstimatedBandwidth uint64
+}
+
+// Clock is the controller's time source. Any type with a Now method — the
+// quic package's Clock included — satisfies it; tests substitute a manually
+// advanced one.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock, backed by package time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// CubicCongestionControl implements the CUBIC congestion control algorithm.
+type CubicCongestionControl struct {
+	mutex sync.Mutex
+	clock Clock
+	// stateChanged, when set, observes transitions between phases. It is
+	// called outside the mutex, so it may query the controller.
+	stateChanged func(from, to CubicState)
+
+	state            CubicState
+	maxDatagramSize  uint64
+	congestionWindow uint64
+	ssthresh         uint64
+	minCwnd          uint64
+	maxCwnd          uint64
+	bytesInFlight    uint64
+	minRtt           time.Duration
+
+	// CUBIC function state.
+	wMax          float64
+	k             float64
+	epochStart    time.Time
+	recoveryStart time.Time
+	lastSendTime  time.Time
+
+	// Delivery-rate estimation state.
+	bwSampleStart time.Time
+	bwSampleBytes uint64
+	bandwidth     float64
+}
+
+// NewCubicCongestionControl creates a controller for the given datagram size.
+// initialWindow is the starting congestion window in packets; zero means the
+// RFC 9002 recommended value.
+func NewCubicCongestionControl(maxDatagramSize, initialWindow uint64) *CubicCongestionControl {
+	if initialWindow == 0 {
+		initialWindow = initialWindowPackets
+	}
+	c := &CubicCongestionControl{
+		clock:           systemClock{},
+		state:           StateSlowStart,
+		maxDatagramSize: maxDatagramSize,
+		minCwnd:         minWindowPackets * maxDatagramSize,
+		maxCwnd:         maxWindowPackets * maxDatagramSize,
+		ssthresh:        math.MaxUint64,
+	}
+	c.congestionWindow = initialWindow * maxDatagramSize
+	return c
+}
+
+// CanSend reports whether the controller allows sending the given number of
+// additional bytes.
+func (c *CubicCongestionControl) CanSend(bytes uint64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bytesInFlight+bytes <= c.congestionWindow
+}
+
+// SetCongestionWindow forces the window to the given size, bypassing the
+// CUBIC dynamics. Intended for tests that need a known send budget.
+func (c *CubicCongestionControl) SetCongestionWindow(bytes uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.congestionWindow = bytes
+}
+
+// SetMaxDatagramSize adjusts the controller to a new datagram size, e.g.
+// after path MTU discovery grows the packets. The window bounds are
+// recomputed for the new size and the current window is re-clamped, so the
+// RFC 9002 two-packet minimum keeps holding rather than clamping to the
+// size the connection started with.
+func (c *CubicCongestionControl) SetMaxDatagramSize(size uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.maxDatagramSize = size
+	c.minCwnd = minWindowPackets * size
+	c.maxCwnd = maxWindowPackets * size
+	if c.congestionWindow < c.minCwnd {
+		c.congestionWindow = c.minCwnd
+	}
+	if c.congestionWindow > c.maxCwnd {
+		c.congestionWindow = c.maxCwnd
+	}
+}
+
+// SetClock replaces the controller's time source. Call it before the
+// controller carries traffic.
+func (c *CubicCongestionControl) SetClock(clock Clock) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.clock = clock
+}
+
+// SetStateChangedFunc registers fn to be called whenever the controller
+// moves between SlowStart, CongestionAvoidance and FastRecovery. Call it
+// before the controller carries traffic.
+func (c *CubicCongestionControl) SetStateChangedFunc(fn func(from, to CubicState)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateChanged = fn
+}
+
+// OnPacketSent records bytes entering the network.
+func (c *CubicCongestionControl) OnPacketSent(bytes uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.bytesInFlight += bytes
+	c.lastSendTime = c.clock.Now()
+}
+
+// OnPacketAcked grows the congestion window according to the current phase.
+func (c *CubicCongestionControl) OnPacketAcked(bytes uint64, rtt time.Duration) {
+	c.mutex.Lock()
+	from := c.state
+	if bytes > c.bytesInFlight {
+		c.bytesInFlight = 0
+	} else {
+		c.bytesInFlight -= bytes
+	}
+
+	c.sampleBandwidthLocked(bytes)
+
+	if rtt > 0 {
+		if c.minRtt == 0 || rtt < c.minRtt {
+			c.minRtt = rtt
+		} else if rtt > rttSpikeFactor*c.minRtt {
+			// The old path's RTT floor no longer applies; re-baseline on
+			// the new sample and stop the aggressive growth.
+			c.minRtt = rtt
+			c.onRTTSpikeLocked()
+		}
+	}
+
+	switch c.state {
+	case StateSlowStart:
+		c.congestionWindow += bytes
+		if c.congestionWindow >= c.ssthresh {
+			c.state = StateCongestionAvoidance
+			c.epochStart = time.Time{}
+		}
+	case StateFastRecovery:
+		// Any ACK after the recovery period started ends recovery.
+		c.state = StateCongestionAvoidance
+		c.epochStart = time.Time{}
+	case StateCongestionAvoidance:
+		c.congestionWindow = c.cubicWindow(rtt)
+	}
+	if c.congestionWindow > c.maxCwnd {
+		c.congestionWindow = c.maxCwnd
+	}
+	c.notifyStateChanged(from)
+}
+
+// OnPacketLost reduces the congestion window in response to loss.
+func (c *CubicCongestionControl) OnPacketLost(bytes uint64) {
+	c.mutex.Lock()
+	from := c.state
+	if bytes > c.bytesInFlight {
+		c.bytesInFlight = 0
+	} else {
+		c.bytesInFlight -= bytes
+	}
+	c.reduceCongestionWindow()
+	c.notifyStateChanged(from)
+}
+
+// sampleBandwidthLocked feeds acked bytes into the delivery-rate estimator.
+// Once a sample spans bandwidthSampleInterval it is folded into the smoothed
+// estimate, so short ACK bursts do not swing the reported bandwidth.
+func (c *CubicCongestionControl) sampleBandwidthLocked(bytes uint64) {
+	now := c.clock.Now()
+	if c.bwSampleStart.IsZero() {
+		// The first ACK only anchors the sample; there is no interval to
+		// divide its bytes by yet.
+		c.bwSampleStart = now
+		return
+	}
+	c.bwSampleBytes += bytes
+	elapsed := now.Sub(c.bwSampleStart)
+	if elapsed < bandwidthSampleInterval {
+		return
+	}
+	sample := float64(c.bwSampleBytes) / elapsed.Seconds()
+	if c.bandwidth == 0 {
+		c.bandwidth = sample
+	} else {
+		c.bandwidth = (1-bandwidthGain)*c.bandwidth + bandwidthGain*sample
+	}
+	c.bwSampleStart = now
+	c.bwSampleBytes = 0
+}
+
+// OnRTTSpike tells the controller the round-trip time jumped well above its
+// floor, which usually means the route changed. The window stops growing
+// aggressively and re-probes from its current size; the RTT floor is
+// relearned from subsequent samples.
+func (c *CubicCongestionControl) OnRTTSpike() {
+	c.mutex.Lock()
+	from := c.state
+	c.minRtt = 0
+	c.onRTTSpikeLocked()
+	c.notifyStateChanged(from)
+}
+
+// onRTTSpikeLocked switches to a cautious re-probe: congestion avoidance
+// anchored at the current window, so growth restarts from the flat part of
+// the CUBIC curve instead of slow start's doubling.
+func (c *CubicCongestionControl) onRTTSpikeLocked() {
+	c.wMax = float64(c.congestionWindow)
+	c.ssthresh = c.congestionWindow
+	c.k = 0
+	c.epochStart = c.clock.Now()
+	c.state = StateCongestionAvoidance
+}
+
+// notifyStateChanged releases the mutex and, if the phase moved away from
+// from, invokes the registered callback. The caller must hold the mutex.
+func (c *CubicCongestionControl) notifyStateChanged(from CubicState) {
+	to := c.state
+	fn := c.stateChanged
+	c.mutex.Unlock()
+	if fn != nil && from != to {
+		fn(from, to)
+	}
+}
+
+// reduceCongestionWindow applies the CUBIC multiplicative decrease and enters
+// fast recovery.
+func (c *CubicCongestionControl) reduceCongestionWindow() {
+	c.wMax = float64(c.congestionWindow)
+	c.congestionWindow = uint64(float64(c.congestionWindow) * cubicBeta)
+	if c.congestionWindow < c.minCwnd {
+		c.congestionWindow = c.minCwnd
+	}
+	c.ssthresh = c.congestionWindow
+	c.k = math.Cbrt(c.wMax * (1 - cubicBeta) / cubicC / float64(c.maxDatagramSize))
+	c.epochStart = c.clock.Now()
+	c.recoveryStart = c.clock.Now()
+	c.state = StateFastRecovery
+}
+
+// cubicWindow evaluates the CUBIC window function W(t) = C*(t-K)^3 + Wmax.
+func (c *CubicCongestionControl) cubicWindow(rtt time.Duration) uint64 {
+	if c.epochStart.IsZero() {
+		c.epochStart = c.clock.Now()
+		if c.wMax == 0 {
+			c.wMax = float64(c.congestionWindow)
+		}
+		c.k = math.Cbrt(c.wMax * (1 - cubicBeta) / cubicC / float64(c.maxDatagramSize))
+	}
+	t := c.clock.Now().Sub(c.epochStart) + rtt
+	w := cubicC*math.Pow(t.Seconds()-c.k, 3)*float64(c.maxDatagramSize) + c.wMax
+	if w < float64(c.minCwnd) {
+		return c.minCwnd
+	}
+	if w < float64(c.congestionWindow) {
+		return c.congestionWindow
+	}
+	return uint64(w)
+}
+
+// GetStats returns a snapshot of the controller state.
+func (c *CubicCongestionControl) GetStats() CongestionStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CongestionStats{
+		CongestionWindow:   c.congestionWindow,
+		BytesInFlight:      c.bytesInFlight,
+		SlowStartThresh:    c.ssthresh,
+		State:              c.state,
+		EstimatedBandwidth: uint64(c.bandwidth),
+	}
+}
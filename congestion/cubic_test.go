@@ -0,0 +1,106 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTSpikeSlowsWindowGrowth(t *testing.T) {
+	const mss = 1200
+	c := NewCubicCongestionControl(mss, 0)
+	base := 10 * time.Millisecond
+
+	// Establish the RTT floor; slow start grows the window by a full
+	// datagram per acked datagram.
+	c.OnPacketAcked(mss, base)
+	before := c.GetStats().CongestionWindow
+	c.OnPacketAcked(mss, base)
+	if got := c.GetStats().CongestionWindow; got != before+mss {
+		t.Fatalf("slow start grew window by %d, want %d", got-before, mss)
+	}
+
+	// A 5x RTT jump looks like a route change, not queueing.
+	c.OnPacketAcked(mss, 5*base)
+	if got := c.GetStats().State; got != StateCongestionAvoidance {
+		t.Fatalf("state after RTT spike = %v, want %v", got, StateCongestionAvoidance)
+	}
+
+	// Growth on the new path must be far below slow start's rate.
+	before = c.GetStats().CongestionWindow
+	const acks = 10
+	for i := 0; i < acks; i++ {
+		c.OnPacketAcked(mss, 5*base)
+	}
+	if growth := c.GetStats().CongestionWindow - before; growth >= acks*mss {
+		t.Errorf("window grew %d bytes over %d acks after the spike, want well below slow start's %d", growth, acks, acks*mss)
+	}
+}
+
+func TestSetMaxDatagramSizeRecomputesWindowBounds(t *testing.T) {
+	c := NewCubicCongestionControl(1200, 0)
+
+	// Repeated losses pin the window at the minimum for the initial size.
+	for i := 0; i < 10; i++ {
+		c.OnPacketLost(1200)
+	}
+	if got := c.GetStats().CongestionWindow; got != 2*1200 {
+		t.Fatalf("window after losses = %d, want the 2-packet minimum %d", got, 2*1200)
+	}
+
+	// The path MTU grows mid-connection; the minimum must track it.
+	c.SetMaxDatagramSize(1500)
+	if got := c.GetStats().CongestionWindow; got != 2*1500 {
+		t.Errorf("window after MTU increase = %d, want %d", got, 2*1500)
+	}
+	c.OnPacketLost(1500)
+	if got := c.GetStats().CongestionWindow; got != 2*1500 {
+		t.Errorf("window after loss at the new MTU = %d, want %d", got, 2*1500)
+	}
+}
+
+// stepClock is a manually advanced Clock for driving the estimator.
+type stepClock struct{ now time.Time }
+
+func (s *stepClock) Now() time.Time { return s.now }
+
+func TestBandwidthEstimateConverges(t *testing.T) {
+	clock := &stepClock{now: time.Unix(1700000000, 0)}
+	c := NewCubicCongestionControl(1200, 0)
+	c.SetClock(clock)
+
+	// 1000 bytes every 10ms is a steady 100 kB/s.
+	const want = 100_000
+	for i := 0; i < 100; i++ {
+		clock.now = clock.now.Add(10 * time.Millisecond)
+		c.OnPacketAcked(1000, 10*time.Millisecond)
+	}
+
+	got := c.GetStats().EstimatedBandwidth
+	if got < want*9/10 || got > want*11/10 {
+		t.Errorf("EstimatedBandwidth = %d, want within 10%% of %d", got, want)
+	}
+}
+
+func TestOnRTTSpikeRelearnsFloor(t *testing.T) {
+	const mss = 1200
+	c := NewCubicCongestionControl(mss, 0)
+	c.OnPacketAcked(mss, 10*time.Millisecond)
+
+	c.OnRTTSpike()
+	stats := c.GetStats()
+	if stats.State != StateCongestionAvoidance {
+		t.Errorf("state = %v, want %v", stats.State, StateCongestionAvoidance)
+	}
+	if stats.SlowStartThresh != stats.CongestionWindow {
+		t.Errorf("ssthresh = %d, want the current window %d", stats.SlowStartThresh, stats.CongestionWindow)
+	}
+
+	// The floor was discarded: a large sample on the new path is the new
+	// baseline, not another spike.
+	c.mutex.Lock()
+	minRtt := c.minRtt
+	c.mutex.Unlock()
+	if minRtt != 0 {
+		t.Errorf("minRtt after OnRTTSpike = %v, want 0", minRtt)
+	}
+}
@@ -0,0 +1,1912 @@
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phuhao00/QUIC/congestion"
+	"github.com/phuhao00/QUIC/crypto"
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// connIDLen is the length of connection IDs generated by this endpoint.
+// Short headers do not carry an explicit length, so it is fixed.
+const connIDLen = 8
+
+// activeConnIDLimit is the active_connection_id_limit this endpoint
+// advertises: how many unused peer-issued connection IDs it is willing to
+// store (RFC 9000 section 18.2).
+const activeConnIDLimit = 2
+
+// ErrConnectionClosed is returned by operations on a closed connection.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrIdleTimeout is the close reason when the idle timer expires.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// ErrHandshakeTimeout is the close reason when the handshake does not
+// complete within Config.HandshakeIdleTimeout.
+var ErrHandshakeTimeout = errors.New("handshake did not complete in time")
+
+// ErrShuttingDown is returned by AcceptStream after InitiateGracefulShutdown
+// has been called on the connection.
+var ErrShuttingDown = errors.New("connection is shutting down")
+
+// ConnectionState describes the state of a connection's handshake.
+type ConnectionState struct {
+	HandshakeComplete  bool
+	NegotiatedProtocol string
+	// ServerName is the SNI value for this connection: on the server the
+	// name the client sent, on the client the name it dialed with.
+	ServerName string
+	Version    uint32
+	// PeerTransportParameters are the transport parameters the peer
+	// advertised during the handshake; nil until they have arrived.
+	PeerTransportParameters *crypto.TransportParameters
+}
+
+// zeroRTTWrite is one early-data stream write, keyed by stream and offset so
+// a replay reproduces the exact byte ranges.
+type zeroRTTWrite struct {
+	streamID uint64
+	offset   uint64
+	data     []byte
+	fin      bool
+}
+
+// sentPacketInfo records what the connection keeps about an in-flight
+// ack-eliciting packet until it is acknowledged.
+type sentPacketInfo struct {
+	sentTime time.Time
+	size     int
+	// streamFrames are the stream ranges the packet carried, so loss
+	// detection can requeue exactly those bytes.
+	streamFrames []streamFrameRef
+	// cryptoFrames are the handshake ranges the packet carried, so an ACK
+	// releases them and loss detection requeues them.
+	cryptoFrames []cryptoFrameRef
+}
+
+// streamFrameRef identifies one stream range carried by a sent packet.
+type streamFrameRef struct {
+	streamID uint64
+	offset   uint64
+	length   int
+}
+
+// cryptoFrameRef identifies one handshake data range carried by a sent
+// packet.
+type cryptoFrameRef struct {
+	level  crypto.CryptoLevel
+	offset uint64
+	length int
+}
+
+// streamWrapper couples a stream with the per-connection bookkeeping the
+// send loop needs.
+type streamWrapper struct {
+	*Stream
+	conn *Connection
+}
+
+// Connection is a QUIC connection between two endpoints.
+type Connection struct {
+	udpConn rawConn
+	// remoteAddrMutex guards remoteAddr and remoteAddrPort, which migration
+	// rewrites while the send and receive loops read them.
+	remoteAddrMutex sync.RWMutex
+	remoteAddr      *net.UDPAddr
+	remoteAddrPort  netip.AddrPort
+	isClient        bool
+	ownsSocket      bool
+	config          *Config
+
+	localConnID        []byte
+	remoteConnID       []byte
+	originalDestConnID []byte
+
+	tlsManager   *crypto.TLSManager
+	cryptoMutex  sync.RWMutex
+	cryptoStates map[crypto.CryptoLevel]*crypto.CryptoState
+
+	// retryToken is the address validation token from a server Retry; the
+	// client echoes it in every subsequent Initial packet.
+	retryMutex sync.Mutex
+	retryToken []byte
+
+	// Connection ID rotation state (Config.ConnectionIDRotation): the IDs we
+	// issued to the peer and the alternatives it issued to us, both keyed by
+	// sequence number. Sequence 0 is the handshake connection ID.
+	connIDMutex     sync.Mutex
+	issuedConnIDs   map[uint64][]byte
+	peerConnIDs     map[uint64][]byte
+	nextConnIDSeq   uint64
+	lastConnIDIssue time.Time
+
+	// Packet numbers are tracked independently per packet number space
+	// (RFC 9000 section 12.3): Initial, Handshake, and Application.
+	sendPacketNumbers [numPNSpaces]atomic.Uint64
+	recvPacketNumbers [numPNSpaces]atomic.Uint64
+
+	streamsMutex sync.RWMutex
+	streams      map[uint64]*streamWrapper
+	nextStreamID uint64
+	acceptQueue  chan *Stream
+
+	// The unidirectional stream budget: how many streams this endpoint has
+	// opened against the peer's MAX_STREAMS (uni) limit. uniNotify wakes
+	// OpenUniStream calls blocked on the limit.
+	uniMutex        sync.Mutex
+	nextUniStreamID uint64
+	uniOpened       uint64
+	peerUniLimit    uint64
+	uniLimitKnown   bool
+	uniBlockedSent  bool
+	uniNotify       chan struct{}
+
+	// Bidirectional stream credit, mirroring the unidirectional fields
+	// above. Only OpenStreamSync enforces it; OpenStream never blocks.
+	bidiMutex       sync.Mutex
+	bidiOpened      uint64
+	peerBidiLimit   uint64
+	bidiLimitKnown  bool
+	bidiBlockedSent bool
+	bidiNotify      chan struct{}
+
+	// acceptDeadline bounds AcceptStream in addition to its context.
+	acceptMutex    sync.Mutex
+	acceptDeadline time.Time
+
+	rttStats     *RTTStats
+	recvTrackers [numPNSpaces]*receivedPacketTracker
+	pacer        *sendRateLimiter // nil when MaxSendRate is unset
+	clock        Clock
+	startTime    time.Time
+
+	// ackTimers delay the ACK for a lone ack-eliciting packet by up to
+	// max_ack_delay; a second one triggers an immediate ACK instead.
+	ackMutex  sync.Mutex
+	ackTimers [numPNSpaces]ClockTimer
+
+	sentMutex   sync.Mutex
+	sentPackets [numPNSpaces]map[uint64]sentPacketInfo
+
+	// PTO state: ptoCount counts consecutive expiries since the last ACK
+	// and backs the timer off exponentially.
+	ptoMutex sync.Mutex
+	ptoCount int
+	ptoTimer ClockTimer
+
+	// pingWaiters maps 1-RTT packet numbers carrying an application PING to
+	// the channel its Ping call is blocked on.
+	pingMutex   sync.Mutex
+	pingWaiters map[uint64]chan time.Duration
+
+	// congestion paces stream data against the in-flight byte budget. Loss
+	// detection frees the budget of lost packets, so a full window always
+	// drains eventually.
+	congestion congestion.CongestionControl
+
+	sendSignal chan struct{}
+
+	// ECN send state: ecnOOB is the cached ECT(0) control message (nil once
+	// marking is disabled), ecnMarked counts marked packets for validation.
+	ecnMutex sync.Mutex
+	ecnOOB   []byte
+	ecnInit  bool
+	ecnSent  uint64
+
+	// zeroRTTWrites buffers stream data sent before the handshake finished
+	// so it can be replayed at 1-RTT if the server rejects early data.
+	zeroRTTMutex  sync.Mutex
+	zeroRTTWrites []zeroRTTWrite
+
+	// earlyDataReplay rejects 0-RTT packet numbers seen before, unless the
+	// config supplies a shared EarlyDataReplayFilter instead.
+	earlyDataReplay replayWindow
+
+	statsMutex sync.Mutex
+	stats      ConnectionStats
+
+	handshakeTimeout time.Duration
+	lastActivity     atomic.Int64 // unix nanos
+
+	handshakeDoneSent bool
+
+	// draining is closed by InitiateGracefulShutdown; AcceptStream stops
+	// surfacing new streams while existing ones continue to operate.
+	drainOnce sync.Once
+	draining  chan struct{}
+
+	closeOnce  sync.Once
+	closed     chan struct{}
+	closeErr   error
+	closeFrame *packet.ConnectionCloseFrame
+	closeMtx   sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// onClosed is invoked once when the connection is torn down; the
+	// listener uses it to remove the connection from its routing table.
+	onClosed func(*Connection)
+}
+
+func generateConnectionID() ([]byte, error) {
+	id := make([]byte, connIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("generating connection ID: %w", err)
+	}
+	return id, nil
+}
+
+// generateConnectionIDFor returns a local connection ID from the config's
+// generator, falling back to the default random generator.
+func generateConnectionIDFor(config *Config) ([]byte, error) {
+	gen := config.ConnectionIDGenerator
+	if gen == nil {
+		return generateConnectionID()
+	}
+	id, err := gen.GenerateConnectionID()
+	if err != nil {
+		return nil, fmt.Errorf("generating connection ID: %w", err)
+	}
+	if len(id) != gen.ConnectionIDLen() {
+		return nil, fmt.Errorf("connection ID generator produced %d bytes, declared %d",
+			len(id), gen.ConnectionIDLen())
+	}
+	return id, nil
+}
+
+// transportParametersFor derives the transport parameters advertised to the
+// peer from a populated config.
+func transportParametersFor(config *Config) crypto.TransportParameters {
+	params := crypto.TransportParameters{
+		MaxIdleTimeout:          uint64(config.MaxIdleTimeout / time.Millisecond),
+		MaxUDPPayloadSize:       config.MaxUDPPayloadSize,
+		InitialMaxData:          config.InitialConnectionReceiveWindow,
+		InitialMaxStreamData:    config.InitialStreamReceiveWindow,
+		InitialMaxStreamsBidi:   uint64(config.MaxIncomingStreams),
+		InitialMaxStreamsUni:    uint64(config.MaxIncomingUniStreams),
+		AckDelayExponent:        defaultAckDelayExponent,
+		MaxAckDelay:             uint64(defaultMaxAckDelay / time.Millisecond),
+		ActiveConnectionIDLimit: activeConnIDLimit,
+		DisableActiveMigration:  config.DisableActiveMigration,
+		GreaseQUICBit:           true,
+	}
+	if config.PreferredAddress != nil {
+		params.PreferredAddress = config.PreferredAddress.String()
+	}
+	return params
+}
+
+func newConnection(udpConn rawConn, remoteAddr *net.UDPAddr, isClient, ownsSocket bool,
+	config *Config, tlsConf *tls.Config, localConnID, remoteConnID, originalDestConnID []byte) (*Connection, error) {
+
+	params := transportParametersFor(config)
+
+	c := &Connection{
+		udpConn:            udpConn,
+		remoteAddr:         remoteAddr,
+		remoteAddrPort:     udpAddrPort(remoteAddr),
+		isClient:           isClient,
+		ownsSocket:         ownsSocket,
+		config:             config,
+		localConnID:        localConnID,
+		remoteConnID:       remoteConnID,
+		originalDestConnID: originalDestConnID,
+		tlsManager:         crypto.NewTLSManager(isClient, tlsConf, params),
+		cryptoStates:       make(map[crypto.CryptoLevel]*crypto.CryptoState),
+		streams:            make(map[uint64]*streamWrapper),
+		acceptQueue:        make(chan *Stream, 32),
+		rttStats:           &RTTStats{},
+		clock:              config.Clock,
+		pingWaiters:        make(map[uint64]chan time.Duration),
+		sendSignal:         make(chan struct{}, 1),
+		handshakeTimeout:   config.HandshakeIdleTimeout,
+		draining:           make(chan struct{}),
+		closed:             make(chan struct{}),
+	}
+	for space := 0; space < numPNSpaces; space++ {
+		c.recvTrackers[space] = newReceivedPacketTracker(defaultAckDelayExponent, config.AckFrequency)
+		c.sentPackets[space] = make(map[uint64]sentPacketInfo)
+	}
+	c.issuedConnIDs = map[uint64][]byte{0: localConnID}
+	c.peerConnIDs = map[uint64][]byte{0: remoteConnID}
+	c.nextConnIDSeq = 1
+	c.lastConnIDIssue = c.clock.Now()
+	if isClient {
+		c.nextStreamID = 0
+		c.nextUniStreamID = 2
+	} else {
+		c.nextStreamID = 1
+		c.nextUniStreamID = 3
+	}
+	c.uniNotify = make(chan struct{}, 1)
+	c.bidiNotify = make(chan struct{}, 1)
+	if !isClient && config.GetConfigForClient != nil {
+		c.tlsManager.SetOnClientHello(func(serverName string, offeredProtocols []string) (*crypto.TransportParameters, error) {
+			newConf, err := config.GetConfigForClient(ClientHelloInfo{
+				ServerName:       serverName,
+				OfferedProtocols: offeredProtocols,
+				RemoteAddr:       remoteAddr,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if newConf == nil {
+				return nil, nil
+			}
+			if err := newConf.Validate(); err != nil {
+				return nil, err
+			}
+			c.config = populateConfig(newConf)
+			params := transportParametersFor(c.config)
+			return &params, nil
+		})
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.startTime = c.clock.Now()
+	c.lastActivity.Store(c.clock.Now().UnixNano())
+	if config.MaxSendRate > 0 {
+		c.pacer = newSendRateLimiter(config.MaxSendRate, c.clock)
+	}
+	switch config.CongestionControlAlgorithm {
+	case CongestionBBR:
+		c.congestion = congestion.NewBBRCongestionControl(c.maxDatagramSize(), config.InitialCongestionWindow)
+	default:
+		c.congestion = congestion.NewCubicCongestionControl(c.maxDatagramSize(), config.InitialCongestionWindow)
+	}
+	c.congestion.SetClock(c.clock)
+	if t := config.ConnectionTracer; t != nil && t.CongestionStateChanged != nil {
+		// Phase-change tracing is a CUBIC notion; BBR has its own states.
+		if cubic, ok := c.congestion.(*congestion.CubicCongestionControl); ok {
+			cubic.SetStateChangedFunc(t.CongestionStateChanged)
+		}
+	}
+
+	for _, level := range []crypto.CryptoLevel{
+		crypto.LevelInitial, crypto.LevelEarlyData, crypto.LevelHandshake, crypto.LevelApplication,
+	} {
+		state, err := crypto.NewCryptoState(originalDestConnID, level)
+		if err != nil {
+			return nil, err
+		}
+		c.cryptoStates[level] = state
+	}
+	return c, nil
+}
+
+// Start launches the connection's background loops.
+func (c *Connection) Start() {
+	if c.ownsSocket {
+		go c.receiveLoop()
+	}
+	go c.sendLoop()
+	if c.handshakeTimeout > 0 {
+		go c.watchHandshakeDeadline()
+	}
+}
+
+// watchHandshakeDeadline closes the connection if the handshake has not
+// finished within the configured handshake timeout.
+func (c *Connection) watchHandshakeDeadline() {
+	timer := time.NewTimer(c.handshakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.tlsManager.HandshakeDone():
+	case <-c.ctx.Done():
+	case <-timer.C:
+		c.closeWithLocalError(ErrHandshakeTimeout)
+	}
+}
+
+// Context returns a context that is cancelled when the connection closes.
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// LocalAddr returns the local network address.
+func (c *Connection) LocalAddr() net.Addr {
+	return c.udpConn.LocalAddr()
+}
+
+// RemoteAddr returns the peer's network address.
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.currentRemoteAddr()
+}
+
+// currentRemoteAddr returns the remote address datagrams are sent to.
+func (c *Connection) currentRemoteAddr() *net.UDPAddr {
+	c.remoteAddrMutex.RLock()
+	defer c.remoteAddrMutex.RUnlock()
+	return c.remoteAddr
+}
+
+// currentRemoteAddrPort returns the remote address in its comparable form.
+func (c *Connection) currentRemoteAddrPort() netip.AddrPort {
+	c.remoteAddrMutex.RLock()
+	defer c.remoteAddrMutex.RUnlock()
+	return c.remoteAddrPort
+}
+
+// HandshakeComplete returns a channel that is closed when the handshake
+// finishes, successfully or not.
+func (c *Connection) HandshakeComplete() <-chan struct{} {
+	return c.tlsManager.HandshakeDone()
+}
+
+// Wait blocks until the connection closes or the context expires. It returns
+// the close cause: nil after a clean local close, the peer's error after a
+// remote close.
+func (c *Connection) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+	}
+	c.closeMtx.Lock()
+	defer c.closeMtx.Unlock()
+	return c.closeErr
+}
+
+// WaitUntilEstablished blocks until the handshake completes, returning nil on
+// success and the connection's error if it fails first. It is mainly useful
+// after DialEarly, which returns before the handshake finishes. If the
+// context expires first, its error is returned.
+func (c *Connection) WaitUntilEstablished(ctx context.Context) error {
+	return c.tlsManager.WaitForHandshake(ctx)
+}
+
+// ConnectionState returns handshake-related state.
+func (c *Connection) ConnectionState() ConnectionState {
+	state := ConnectionState{
+		HandshakeComplete:  c.tlsManager.HandshakeComplete(),
+		NegotiatedProtocol: c.tlsManager.NegotiatedProtocol(),
+		ServerName:         c.tlsManager.ServerName(),
+		Version:            packet.Version1,
+	}
+	if params, ok := c.tlsManager.PeerTransportParameters(); ok {
+		state.PeerTransportParameters = &params
+	}
+	return state
+}
+
+// GetStats returns a snapshot of the connection counters.
+func (c *Connection) GetStats() ConnectionStats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	stats := c.stats
+	stats.SmoothedRTT = c.rttStats.SmoothedRTT()
+	stats.MinRTT = c.rttStats.MinRTT()
+	stats.RTTVar = c.rttStats.RTTVariation()
+	if elapsed := time.Since(c.startTime).Seconds(); elapsed > 0 {
+		stats.SendRate = uint64(float64(stats.BytesSent) / elapsed)
+	}
+	if c.congestion != nil {
+		cc := c.congestion.GetStats()
+		stats.CongestionWindow = cc.CongestionWindow
+		stats.BytesInFlight = cc.BytesInFlight
+		stats.SlowStart = cc.State == congestion.StateSlowStart
+		stats.Recovery = cc.State == congestion.StateFastRecovery
+	}
+	return stats
+}
+
+// SmoothedRTT returns the exponentially weighted RTT estimate.
+func (c *Connection) SmoothedRTT() time.Duration {
+	return c.rttStats.SmoothedRTT()
+}
+
+// MinRTT returns the minimum RTT observed on this connection.
+func (c *Connection) MinRTT() time.Duration {
+	return c.rttStats.MinRTT()
+}
+
+// RTTVariation returns the mean deviation of the RTT samples.
+func (c *Connection) RTTVariation() time.Duration {
+	return c.rttStats.RTTVariation()
+}
+
+// CongestionWindow returns the congestion controller's current window in
+// bytes.
+func (c *Connection) CongestionWindow() uint64 {
+	return c.congestion.GetStats().CongestionWindow
+}
+
+// BytesInFlight returns how many sent bytes are awaiting acknowledgment.
+func (c *Connection) BytesInFlight() uint64 {
+	return c.congestion.GetStats().BytesInFlight
+}
+
+// setCongestionWindow forces the controller's window, letting tests pin the
+// send budget to a known size.
+func (c *Connection) setCongestionWindow(bytes uint64) {
+	c.congestion.SetCongestionWindow(bytes)
+}
+
+// Ping sends a PING frame and blocks until the peer acknowledges it,
+// returning the measured round-trip time. It is a liveness probe: unlike
+// SmoothedRTT it reflects the path as it is right now.
+func (c *Connection) Ping(ctx context.Context) (time.Duration, error) {
+	select {
+	case <-c.closed:
+		return 0, ErrConnectionClosed
+	default:
+	}
+
+	// Allocate the packet number up front so the waiter is registered
+	// before the ACK can possibly arrive.
+	pn := c.sendPacketNumbers[pnSpaceForLevel(crypto.LevelApplication)].Add(1) - 1
+	ch := make(chan time.Duration, 1)
+	c.pingMutex.Lock()
+	c.pingWaiters[pn] = ch
+	c.pingMutex.Unlock()
+	defer func() {
+		c.pingMutex.Lock()
+		delete(c.pingWaiters, pn)
+		c.pingMutex.Unlock()
+	}()
+
+	if err := c.sendWithPacketNumber(crypto.LevelApplication, pn, []packet.Frame{&packet.PingFrame{}}); err != nil {
+		return 0, err
+	}
+	select {
+	case rtt := <-ch:
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.closed:
+		return 0, ErrConnectionClosed
+	}
+}
+
+// receiveLoop reads datagrams from a connection-owned socket. Connections
+// sharing a listener socket are fed through handleDatagram directly.
+func (c *Connection) receiveLoop() {
+	buf := make([]byte, 65535)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, addr, err := c.udpConn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			c.closeWithLocalError(fmt.Errorf("read failed: %w", err))
+			return
+		}
+		if !c.isFromPeer(addr) {
+			continue
+		}
+		c.handleDatagramECN(buf[:n], parseECN(oob[:oobn]))
+	}
+}
+
+// isFromPeer reports whether addr refers to the connection's current remote
+// address. Addresses are compared as netip.AddrPort values, so different
+// textual forms of the same peer (e.g. a v4-mapped IPv6 address) match.
+func (c *Connection) isFromPeer(addr *net.UDPAddr) bool {
+	ap := udpAddrPort(addr)
+	return ap.IsValid() && ap == c.currentRemoteAddrPort()
+}
+
+// handleDatagram decrypts a single datagram and processes its frames.
+func (c *Connection) handleDatagram(data []byte) {
+	c.handleDatagramECN(data, ecnNotECT)
+}
+
+// handleDatagramECN is handleDatagram with the ECN codepoint the datagram
+// arrived with, as extracted from the socket's control messages.
+func (c *Connection) handleDatagramECN(data []byte, ecn uint8) {
+	header, _, hdrErr := packet.ParseHeader(data, len(c.localConnID))
+	switch {
+	case hdrErr == nil:
+	case errors.Is(hdrErr, packet.ErrFixedBitZero):
+		// We advertise grease_quic_bit, so a toggled fixed bit is fine.
+	case errors.Is(hdrErr, packet.ErrReservedBitsSet):
+		// Judged below, once the packet has authenticated.
+	default:
+		return
+	}
+	if header.Type == packet.PacketTypeRetry {
+		c.handleRetry(header)
+		return
+	}
+	level := levelForPacketType(header.Type)
+	space := pnSpaceForLevel(level)
+	c.cryptoMutex.RLock()
+	state := c.cryptoStates[level]
+	c.cryptoMutex.RUnlock()
+	if state == nil {
+		return
+	}
+	_, frames, err := packet.Unprotect(state, data, len(c.localConnID), c.config.MaxFramesPerPacket)
+	if err != nil {
+		// Reserved bits are only judged on packets that authenticate, so a
+		// forged datagram cannot kill the connection (RFC 9000 section 17.2).
+		if errors.Is(err, packet.ErrReservedBitsSet) {
+			c.closeWithProtocolViolation(0, "reserved bits set")
+		}
+		// The frame-count bound is likewise judged only after the payload
+		// authenticated, so it too warrants a close rather than a drop.
+		if errors.Is(err, packet.ErrTooManyFrames) {
+			c.closeWithProtocolViolation(0, "too many frames in packet")
+		}
+		if !errors.Is(err, packet.ErrFixedBitZero) {
+			return
+		}
+	}
+
+	// 0-RTT packets can be replayed by an attacker; drop any early-data
+	// packet number that has been seen before.
+	if level == crypto.LevelEarlyData && c.seenEarlyData(header.PacketNumber) {
+		return
+	}
+
+	reordered, duplicate := c.recvTrackers[space].OnPacketReceived(header.PacketNumber)
+	if duplicate {
+		// A retransmitted or duplicated packet number: its frames were
+		// already processed once, and processing them again would
+		// re-deliver stream data and double-count bytes.
+		return
+	}
+
+	// Track the highest packet number seen in this space.
+	for {
+		prev := c.recvPacketNumbers[space].Load()
+		if header.PacketNumber <= prev {
+			break
+		}
+		if c.recvPacketNumbers[space].CompareAndSwap(prev, header.PacketNumber) {
+			break
+		}
+	}
+	c.lastActivity.Store(c.clock.Now().UnixNano())
+
+	c.statsMutex.Lock()
+	c.stats.PacketsReceived++
+	c.stats.BytesReceived += uint64(len(data))
+	c.statsMutex.Unlock()
+
+	if !c.config.DisableECN {
+		c.recvTrackers[space].OnECN(ecn)
+	}
+
+	for _, frame := range frames {
+		c.handleFrame(frame, level)
+	}
+	if packet.HasAckElicitingFrames(frames) {
+		// Reordering bypasses the delayed-ACK timer so the peer learns
+		// about the gap right away.
+		if c.recvTrackers[space].OnAckEliciting() || reordered {
+			c.sendAck(level)
+		} else {
+			c.armAckTimer(level)
+		}
+	}
+}
+
+// seenEarlyData records a 0-RTT packet number and reports whether it is a
+// replay, consulting the application's shared filter when configured.
+func (c *Connection) seenEarlyData(pn uint64) bool {
+	if f := c.config.EarlyDataReplayFilter; f != nil {
+		return f.Seen(c.originalDestConnID, pn)
+	}
+	return c.earlyDataReplay.Seen(pn)
+}
+
+// armAckTimer schedules an ACK for the level's packet number space after
+// max_ack_delay, unless one is already pending.
+func (c *Connection) armAckTimer(level crypto.CryptoLevel) {
+	space := pnSpaceForLevel(level)
+	c.ackMutex.Lock()
+	defer c.ackMutex.Unlock()
+	if c.ackTimers[space] != nil {
+		return
+	}
+	c.ackTimers[space] = c.clock.AfterFunc(defaultMaxAckDelay, func() {
+		c.sendAck(level)
+	})
+}
+
+// stopAckTimer cancels a pending delayed ACK for the space; called once an
+// ACK for that space has gone out.
+func (c *Connection) stopAckTimer(space int) {
+	c.ackMutex.Lock()
+	defer c.ackMutex.Unlock()
+	if c.ackTimers[space] != nil {
+		c.ackTimers[space].Stop()
+		c.ackTimers[space] = nil
+	}
+}
+
+// handleRetry stores the token from a server Retry and resends the first
+// flight with the token attached. Retry packets are unprotected, so only the
+// first one before the handshake progresses is honoured (RFC 9000 section
+// 17.2.5).
+func (c *Connection) handleRetry(header *packet.Header) {
+	if !c.isClient || c.tlsManager.HandshakeComplete() {
+		return
+	}
+	c.retryMutex.Lock()
+	if c.retryToken != nil {
+		c.retryMutex.Unlock()
+		return
+	}
+	c.retryToken = append([]byte(nil), header.Token...)
+	c.retryMutex.Unlock()
+
+	// The server discarded the first flight; queue it for retransmission.
+	c.tlsManager.RetransmitStale(0)
+	c.signalSend()
+}
+
+// currentRetryToken returns the token to carry in Initial packets, nil before
+// any Retry was received.
+func (c *Connection) currentRetryToken() []byte {
+	c.retryMutex.Lock()
+	defer c.retryMutex.Unlock()
+	return c.retryToken
+}
+
+// sendAck sends an ACK frame covering the packets received in the given
+// level's packet number space. The frame travels in a packet of that same
+// space, as an ACK must not cross spaces.
+func (c *Connection) sendAck(level crypto.CryptoLevel) {
+	tracker := c.recvTrackers[pnSpaceForLevel(level)]
+	// Leave room for the header and AEAD tag; old ranges are dropped before
+	// the frame would overflow the packet.
+	frame := tracker.BuildAckFrame(int(c.maxDatagramSize()) - shortHeaderLen - sealOverhead)
+	if frame == nil {
+		return
+	}
+	if err := c.SendPacketAtLevel(level, []packet.Frame{frame}); err == nil {
+		tracker.OnAckSent()
+		c.stopAckTimer(pnSpaceForLevel(level))
+	}
+}
+
+// Packet number spaces (RFC 9000 section 12.3). 0-RTT and 1-RTT packets
+// share the application data space.
+const (
+	pnSpaceInitial = iota
+	pnSpaceHandshake
+	pnSpaceApplication
+	numPNSpaces
+)
+
+func pnSpaceForLevel(level crypto.CryptoLevel) int {
+	switch level {
+	case crypto.LevelInitial:
+		return pnSpaceInitial
+	case crypto.LevelHandshake:
+		return pnSpaceHandshake
+	default:
+		return pnSpaceApplication
+	}
+}
+
+func levelForPacketType(packetType uint8) crypto.CryptoLevel {
+	switch packetType {
+	case packet.PacketTypeInitial:
+		return crypto.LevelInitial
+	case packet.PacketTypeHandshake:
+		return crypto.LevelHandshake
+	case packet.PacketTypeZeroRTT:
+		return crypto.LevelEarlyData
+	default:
+		return crypto.LevelApplication
+	}
+}
+
+func (c *Connection) handleFrame(frame packet.Frame, level crypto.CryptoLevel) {
+	switch f := frame.(type) {
+	case *packet.PingFrame:
+		c.handlePingFrame(f, level)
+	case *packet.AckFrame:
+		c.handleAckFrame(f, level)
+	case *packet.CryptoFrame:
+		c.handleCryptoFrame(f, level)
+	case *packet.StreamFrame:
+		c.handleStreamFrame(f)
+	case *packet.MaxStreamDataFrame:
+		c.handleMaxStreamDataFrame(f)
+	case *packet.MaxStreamsFrame:
+		c.handleMaxStreamsFrame(f)
+	case *packet.StreamsBlockedFrame:
+		// The peer is stalled on our stream limit; nothing to do until
+		// this endpoint raises it.
+	case *packet.ResetStreamFrame:
+		c.handleResetStreamFrame(f)
+	case *packet.NewConnectionIDFrame:
+		c.handleNewConnectionIDFrame(f)
+	case *packet.RetireConnectionIDFrame:
+		c.handleRetireConnectionIDFrame(f)
+	case *packet.ConnectionCloseFrame:
+		c.handleConnectionCloseFrame(f)
+	case *packet.PathChallengeFrame:
+		c.SendPacket([]packet.Frame{&packet.PathResponseFrame{Data: f.Data}})
+	case *packet.PathResponseFrame:
+		// Path validation succeeded; nothing to record yet.
+	case *packet.PaddingFrame:
+	case *packet.HandshakeDoneFrame:
+		if !c.isClient {
+			// Only servers send HANDSHAKE_DONE (RFC 9000, section 19.20).
+			c.closeWithProtocolViolation(f.Type(), "client sent HANDSHAKE_DONE")
+			return
+		}
+		// The fake handshake always accepts early data, so confirmation
+		// makes the replay buffer unnecessary.
+		c.tlsManager.Confirm()
+		c.discardZeroRTTBuffer()
+		c.migrateToPreferredAddress()
+	case *packet.StreamChecksumFrame:
+		c.handleStreamChecksumFrame(f)
+	default:
+		c.closeWithProtocolViolation(frame.Type(), "unexpected frame")
+	}
+}
+
+// closeWithProtocolViolation terminates the connection after an invalid
+// frame, recording the offending frame type in the CONNECTION_CLOSE frame.
+func (c *Connection) closeWithProtocolViolation(frameType uint64, reason string) {
+	frame := &packet.ConnectionCloseFrame{
+		ErrorCode:    packet.ProtocolViolation,
+		FrameType:    frameType,
+		ReasonPhrase: reason,
+	}
+	c.teardown(fmt.Errorf("protocol violation: %s", reason), frame)
+}
+
+func (c *Connection) handlePingFrame(f *packet.PingFrame, level crypto.CryptoLevel) {
+	// A PING asks for an immediate acknowledgment of everything received.
+	c.sendAck(level)
+}
+
+func (c *Connection) handleAckFrame(f *packet.AckFrame, level crypto.CryptoLevel) {
+	c.validateECN(f)
+	space := pnSpaceForLevel(level)
+
+	var (
+		largest   sentPacketInfo
+		largestOK bool
+		ackedPNs  []uint64
+		acked     []sentPacketInfo
+	)
+	c.sentMutex.Lock()
+	for pn, info := range c.sentPackets[space] {
+		if !ackFrameCovers(f, pn) {
+			continue
+		}
+		if pn == f.LargestAcked {
+			largest, largestOK = info, true
+		}
+		ackedPNs = append(ackedPNs, pn)
+		acked = append(acked, info)
+		delete(c.sentPackets[space], pn)
+	}
+	c.sentMutex.Unlock()
+
+	// Only the largest acknowledged packet yields an RTT sample; smaller
+	// ones may have been held back by the peer's delayed ACK timer.
+	var latestRTT time.Duration
+	if largestOK {
+		latestRTT = c.clock.Now().Sub(largest.sentTime)
+		c.rttStats.UpdateRTT(latestRTT, c.decodePeerAckDelay(f.AckDelay))
+	}
+	rtt := c.rttStats.SmoothedRTT()
+	for i, p := range acked {
+		c.congestion.OnPacketAcked(uint64(p.size), rtt)
+		c.forStreamRanges(p.streamFrames, (*Stream).OnAck)
+		for _, ref := range p.cryptoFrames {
+			c.tlsManager.AckCrypto(ref.level, ref.offset, ref.length)
+		}
+		if level == crypto.LevelApplication {
+			c.notifyPingAcked(ackedPNs[i], p.sentTime)
+		}
+	}
+	// Losses are declared after the acknowledgments are processed, so the
+	// recovery period they start is not immediately ended by an ACK from
+	// the same frame.
+	c.detectLostPackets(space, f.LargestAcked, latestRTT)
+	if len(acked) > 0 {
+		c.onAckReceived()
+	}
+}
+
+// forStreamRanges applies fn to each recorded stream range whose stream still
+// exists, so acknowledgment and loss of sent packets reach the send buffers.
+func (c *Connection) forStreamRanges(refs []streamFrameRef, fn func(*Stream, uint64, int)) {
+	for _, ref := range refs {
+		c.streamsMutex.RLock()
+		stream, ok := c.streams[ref.streamID]
+		c.streamsMutex.RUnlock()
+		if ok {
+			fn(stream.Stream, ref.offset, ref.length)
+		}
+	}
+}
+
+// notifyPingAcked wakes the Ping call, if any, waiting on the acknowledged
+// packet number.
+func (c *Connection) notifyPingAcked(pn uint64, sentTime time.Time) {
+	c.pingMutex.Lock()
+	ch, ok := c.pingWaiters[pn]
+	if ok {
+		delete(c.pingWaiters, pn)
+	}
+	c.pingMutex.Unlock()
+	if ok {
+		ch <- c.clock.Now().Sub(sentTime)
+	}
+}
+
+// decodePeerAckDelay converts the wire-encoded AckDelay to a duration using
+// the peer's ack_delay_exponent.
+func (c *Connection) decodePeerAckDelay(encoded uint64) time.Duration {
+	exponent := uint64(defaultAckDelayExponent)
+	if params, ok := c.tlsManager.PeerTransportParameters(); ok && params.AckDelayExponent > 0 {
+		exponent = params.AckDelayExponent
+	}
+	return time.Duration(encoded<<exponent) * time.Microsecond
+}
+
+func (c *Connection) handleCryptoFrame(f *packet.CryptoFrame, level crypto.CryptoLevel) {
+	if err := c.tlsManager.ProcessCryptoFrame(level, f.Offset, f.Data); err != nil {
+		c.closeWithLocalError(fmt.Errorf("handshake failed: %w", err))
+		return
+	}
+	c.signalSend()
+}
+
+func (c *Connection) handleStreamFrame(f *packet.StreamFrame) {
+	if c.isPeerInitiated(f.StreamID) && c.exceedsLocalStreamLimit(f.StreamID) {
+		frame := &packet.ConnectionCloseFrame{
+			ErrorCode:    packet.StreamLimitError,
+			FrameType:    f.Type(),
+			ReasonPhrase: "stream ID exceeds advertised stream limit",
+		}
+		c.teardown(fmt.Errorf("stream limit error: peer opened stream %d beyond the advertised limit", f.StreamID), frame)
+		return
+	}
+	stream, isNew := c.getOrCreateStream(f.StreamID)
+	if err := stream.ReceiveData(f.Offset, f.Data, f.Fin); err != nil {
+		if errors.Is(err, errFinalSizeViolated) {
+			frame := &packet.ConnectionCloseFrame{
+				ErrorCode:    packet.FinalSizeError,
+				FrameType:    f.Type(),
+				ReasonPhrase: err.Error(),
+			}
+			c.teardown(fmt.Errorf("final size error: %w", err), frame)
+		}
+		return
+	}
+	if isNew && c.isPeerInitiated(f.StreamID) {
+		select {
+		case c.acceptQueue <- stream.Stream:
+		default:
+		}
+	}
+}
+
+// handleStreamChecksumFrame hands a debug checksum to its stream for
+// verification. The frame is ignored unless this endpoint also has
+// Config.DebugStreamChecksums enabled, or when the stream is unknown.
+func (c *Connection) handleStreamChecksumFrame(f *packet.StreamChecksumFrame) {
+	if !c.config.DebugStreamChecksums {
+		return
+	}
+	c.streamsMutex.RLock()
+	stream, ok := c.streams[f.StreamID]
+	c.streamsMutex.RUnlock()
+	if !ok {
+		return
+	}
+	stream.handleChecksum(f.FinalSize, uint32(f.Checksum))
+}
+
+func (c *Connection) handleMaxStreamDataFrame(f *packet.MaxStreamDataFrame) {
+	c.streamsMutex.RLock()
+	stream, ok := c.streams[f.StreamID]
+	c.streamsMutex.RUnlock()
+	if !ok {
+		return
+	}
+	stream.sendMutex.Lock()
+	if f.MaximumData > stream.maxStreamData {
+		stream.maxStreamData = f.MaximumData
+	}
+	stream.writeCond.Broadcast()
+	stream.sendMutex.Unlock()
+}
+
+func (c *Connection) handleResetStreamFrame(f *packet.ResetStreamFrame) {
+	c.streamsMutex.RLock()
+	stream, ok := c.streams[f.StreamID]
+	c.streamsMutex.RUnlock()
+	if !ok {
+		return
+	}
+	stream.handleReset(f.ErrorCode, f.FinalSize)
+}
+
+func (c *Connection) handleConnectionCloseFrame(f *packet.ConnectionCloseFrame) {
+	var err error
+	if f.IsApplication {
+		err = &ApplicationError{ErrorCode: f.ErrorCode, ReasonPhrase: f.ReasonPhrase}
+	} else {
+		err = &TransportError{
+			ErrorCode:    packet.QUICErrorCode(f.ErrorCode),
+			FrameType:    f.FrameType,
+			ReasonPhrase: f.ReasonPhrase,
+		}
+	}
+	c.teardown(fmt.Errorf("connection closed by peer: %w", err), nil)
+}
+
+// exceedsLocalStreamLimit reports whether a peer-initiated stream ID implies
+// more streams of its type than this endpoint advertised via its
+// initial_max_streams transport parameters.
+func (c *Connection) exceedsLocalStreamLimit(streamID uint64) bool {
+	count := streamID/4 + 1
+	if streamID%4 >= 2 {
+		return count > uint64(c.config.MaxIncomingUniStreams)
+	}
+	return count > uint64(c.config.MaxIncomingStreams)
+}
+
+// isPeerInitiated reports whether the stream ID was allocated by the peer.
+func (c *Connection) isPeerInitiated(streamID uint64) bool {
+	if c.isClient {
+		return streamID%2 == 1
+	}
+	return streamID%2 == 0
+}
+
+func (c *Connection) getOrCreateStream(streamID uint64) (*streamWrapper, bool) {
+	c.streamsMutex.Lock()
+	defer c.streamsMutex.Unlock()
+	if stream, ok := c.streams[streamID]; ok {
+		return stream, false
+	}
+	stream := c.newStreamLocked(streamID)
+	return stream, true
+}
+
+func (c *Connection) newStreamLocked(streamID uint64) *streamWrapper {
+	sendWindow := c.config.InitialStreamReceiveWindow
+	if params, ok := c.tlsManager.PeerTransportParameters(); ok {
+		sendWindow = params.InitialMaxStreamData
+	}
+	stream := &streamWrapper{
+		Stream: newStream(streamID, c, c.config.InitialStreamReceiveWindow, sendWindow),
+		conn:   c,
+	}
+	c.streams[streamID] = stream
+	c.statsMutex.Lock()
+	c.stats.StreamsOpened++
+	c.statsMutex.Unlock()
+	select {
+	case <-c.closed:
+		// Teardown already swept the stream map; a stream created after it
+		// would otherwise block its callers forever.
+		stream.closeWithError(c.closeReason())
+	default:
+	}
+	return stream
+}
+
+// OpenStream opens a new bidirectional stream.
+func (c *Connection) OpenStream() (*Stream, error) {
+	select {
+	case <-c.closed:
+		return nil, c.closeReason()
+	default:
+	}
+	c.streamsMutex.Lock()
+	defer c.streamsMutex.Unlock()
+	streamID := c.nextStreamID
+	c.nextStreamID += 4
+	return c.newStreamLocked(streamID).Stream, nil
+}
+
+// OpenStreamSync opens a new bidirectional stream. It blocks while the
+// peer's MAX_STREAMS (bidi) limit is exhausted — announcing the stall with a
+// STREAMS_BLOCKED frame — until the limit is raised, the context is
+// cancelled, Config.MaxStreamWaitTimeout passes, or the connection closes.
+func (c *Connection) OpenStreamSync(ctx context.Context) (*Stream, error) {
+	// The timeout bounds the wait even when the caller forgot to set a
+	// context deadline.
+	var timeout <-chan time.Time
+	if d := c.config.MaxStreamWaitTimeout; d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case <-c.closed:
+			return nil, c.closeReason()
+		default:
+		}
+
+		c.bidiMutex.Lock()
+		limit := c.peerBidiLimitLocked()
+		if c.bidiOpened < limit {
+			c.bidiOpened++
+			c.bidiMutex.Unlock()
+			return c.OpenStream()
+		}
+		announce := !c.bidiBlockedSent
+		c.bidiBlockedSent = true
+		c.bidiMutex.Unlock()
+
+		if announce {
+			c.SendPacket([]packet.Frame{&packet.StreamsBlockedFrame{
+				MaximumStreams: limit,
+			}})
+		}
+		select {
+		case <-c.bidiNotify:
+		case <-timeout:
+			return nil, fmt.Errorf("timed out waiting for stream credit after %v: %w",
+				c.config.MaxStreamWaitTimeout, os.ErrDeadlineExceeded)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.closed:
+			return nil, c.closeReason()
+		}
+	}
+}
+
+// peerBidiLimitLocked returns the peer's bidirectional stream limit, pulling
+// the initial value from its transport parameters once they have arrived.
+// The caller holds bidiMutex.
+func (c *Connection) peerBidiLimitLocked() uint64 {
+	if !c.bidiLimitKnown {
+		if params, ok := c.tlsManager.PeerTransportParameters(); ok {
+			c.peerBidiLimit = params.InitialMaxStreamsBidi
+			c.bidiLimitKnown = true
+		}
+	}
+	return c.peerBidiLimit
+}
+
+// OpenUniStream opens a send-only unidirectional stream. It blocks while the
+// peer's MAX_STREAMS (uni) limit is exhausted — announcing the stall with a
+// STREAMS_BLOCKED frame — until the limit is raised, the context is
+// cancelled, or the connection closes. The bidirectional limit is separate
+// and never blocks this call.
+func (c *Connection) OpenUniStream(ctx context.Context) (*Stream, error) {
+	for {
+		select {
+		case <-c.closed:
+			return nil, c.closeReason()
+		default:
+		}
+
+		c.uniMutex.Lock()
+		limit := c.peerUniLimitLocked()
+		if c.uniOpened < limit {
+			c.uniOpened++
+			streamID := c.nextUniStreamID
+			c.nextUniStreamID += 4
+			c.uniMutex.Unlock()
+			c.streamsMutex.Lock()
+			s := c.newStreamLocked(streamID)
+			c.streamsMutex.Unlock()
+			return s.Stream, nil
+		}
+		announce := !c.uniBlockedSent
+		c.uniBlockedSent = true
+		c.uniMutex.Unlock()
+
+		if announce {
+			c.SendPacket([]packet.Frame{&packet.StreamsBlockedFrame{
+				Uni:            true,
+				MaximumStreams: limit,
+			}})
+		}
+		select {
+		case <-c.uniNotify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.closed:
+			return nil, c.closeReason()
+		}
+	}
+}
+
+// peerUniLimitLocked returns the peer's unidirectional stream limit, pulling
+// the initial value from its transport parameters once they have arrived.
+// The caller holds uniMutex.
+func (c *Connection) peerUniLimitLocked() uint64 {
+	if !c.uniLimitKnown {
+		if params, ok := c.tlsManager.PeerTransportParameters(); ok {
+			c.peerUniLimit = params.InitialMaxStreamsUni
+			c.uniLimitKnown = true
+		}
+	}
+	return c.peerUniLimit
+}
+
+// handleMaxStreamsFrame raises the peer's advertised stream limit and wakes
+// any OpenUniStream or OpenStreamSync call blocked on it.
+func (c *Connection) handleMaxStreamsFrame(f *packet.MaxStreamsFrame) {
+	if !f.Uni {
+		c.bidiMutex.Lock()
+		if f.MaximumStreams > c.peerBidiLimit {
+			c.peerBidiLimit = f.MaximumStreams
+			c.bidiLimitKnown = true
+			c.bidiBlockedSent = false
+		}
+		c.bidiMutex.Unlock()
+		select {
+		case c.bidiNotify <- struct{}{}:
+		default:
+		}
+		return
+	}
+	c.uniMutex.Lock()
+	if f.MaximumStreams > c.peerUniLimit {
+		c.peerUniLimit = f.MaximumStreams
+		c.uniLimitKnown = true
+		c.uniBlockedSent = false
+	}
+	c.uniMutex.Unlock()
+	select {
+	case c.uniNotify <- struct{}{}:
+	default:
+	}
+}
+
+// SetAcceptDeadline bounds future AcceptStream calls, matching net.Listener
+// ergonomics: once the deadline passes, AcceptStream returns an error whose
+// Timeout method reports true. A zero value removes the deadline.
+func (c *Connection) SetAcceptDeadline(t time.Time) error {
+	c.acceptMutex.Lock()
+	c.acceptDeadline = t
+	c.acceptMutex.Unlock()
+	return nil
+}
+
+// InitiateGracefulShutdown stops the connection from surfacing new incoming
+// streams: subsequent AcceptStream calls return ErrShuttingDown. Existing
+// streams and the connection itself keep operating, so an application
+// protocol can drain in-flight work before closing for real.
+func (c *Connection) InitiateGracefulShutdown() {
+	c.drainOnce.Do(func() { close(c.draining) })
+}
+
+// AcceptStream waits for the peer to open a stream. It returns when a stream
+// arrives, the context is cancelled, the accept deadline passes, or the
+// connection closes.
+func (c *Connection) AcceptStream(ctx context.Context) (*Stream, error) {
+	// A shutdown in progress wins over queued streams, so a draining server
+	// never picks up work it no longer wants.
+	select {
+	case <-c.draining:
+		return nil, ErrShuttingDown
+	default:
+	}
+	var timeout <-chan time.Time
+	c.acceptMutex.Lock()
+	deadline := c.acceptDeadline
+	c.acceptMutex.Unlock()
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case stream := <-c.acceptQueue:
+		return stream, nil
+	case <-c.draining:
+		return nil, ErrShuttingDown
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, c.closeReason()
+	}
+}
+
+// signalSend wakes the send loop.
+func (c *Connection) signalSend() {
+	select {
+	case c.sendSignal <- struct{}{}:
+	default:
+	}
+}
+
+// sendLoop drains handshake data and stream buffers into packets.
+func (c *Connection) sendLoop() {
+	ticker := time.NewTicker(2 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		case <-c.sendSignal:
+		}
+		c.sendPendingCrypto()
+		c.maybeSendHandshakeDone()
+		c.maybeRotateConnID()
+		c.sendStreamData()
+		c.checkIdleTimeout()
+	}
+}
+
+// cryptoPTO is how long handed-out handshake data may stay unacknowledged
+// before it is retransmitted.
+const cryptoPTO = 300 * time.Millisecond
+
+func (c *Connection) sendPendingCrypto() {
+	c.tlsManager.RetransmitStale(cryptoPTO)
+	for _, level := range []crypto.CryptoLevel{
+		crypto.LevelInitial, crypto.LevelHandshake, crypto.LevelApplication,
+	} {
+		for {
+			data, offset := c.tlsManager.GetCryptoFrameData(level)
+			if data == nil {
+				break
+			}
+			frame := &packet.CryptoFrame{Offset: offset, Data: data}
+			c.SendPacketAtLevel(level, []packet.Frame{frame})
+		}
+	}
+}
+
+// maybeRotateConnID issues a fresh connection ID and asks the peer to retire
+// the earlier ones once the configured rotation interval has passed.
+func (c *Connection) maybeRotateConnID() {
+	interval := c.config.ConnectionIDRotation
+	if interval <= 0 || !c.tlsManager.HandshakeComplete() {
+		return
+	}
+	c.connIDMutex.Lock()
+	if c.clock.Now().Sub(c.lastConnIDIssue) < interval {
+		c.connIDMutex.Unlock()
+		return
+	}
+	id, err := generateConnectionIDFor(c.config)
+	if err != nil {
+		c.connIDMutex.Unlock()
+		return
+	}
+	seq := c.nextConnIDSeq
+	c.nextConnIDSeq++
+	c.issuedConnIDs[seq] = id
+	c.lastConnIDIssue = c.clock.Now()
+	c.connIDMutex.Unlock()
+
+	c.SendPacket([]packet.Frame{&packet.NewConnectionIDFrame{
+		SequenceNumber: seq,
+		RetirePriorTo:  seq,
+		ConnectionID:   id,
+	}})
+}
+
+// handleNewConnectionIDFrame stores an alternative connection ID issued by
+// the peer and retires the ones the frame obsoletes. If the peer exceeds our
+// advertised active_connection_id_limit, the oldest IDs are retired rather
+// than kept.
+func (c *Connection) handleNewConnectionIDFrame(f *packet.NewConnectionIDFrame) {
+	var retire []uint64
+	c.connIDMutex.Lock()
+	c.peerConnIDs[f.SequenceNumber] = append([]byte(nil), f.ConnectionID...)
+	for seq := range c.peerConnIDs {
+		if seq < f.RetirePriorTo {
+			delete(c.peerConnIDs, seq)
+			retire = append(retire, seq)
+		}
+	}
+	for len(c.peerConnIDs) > activeConnIDLimit {
+		oldest := f.SequenceNumber
+		for seq := range c.peerConnIDs {
+			if seq < oldest {
+				oldest = seq
+			}
+		}
+		delete(c.peerConnIDs, oldest)
+		retire = append(retire, oldest)
+	}
+	c.connIDMutex.Unlock()
+
+	for _, seq := range retire {
+		c.SendPacket([]packet.Frame{&packet.RetireConnectionIDFrame{SequenceNumber: seq}})
+	}
+}
+
+// handleRetireConnectionIDFrame drops a local connection ID the peer has
+// stopped using.
+func (c *Connection) handleRetireConnectionIDFrame(f *packet.RetireConnectionIDFrame) {
+	c.connIDMutex.Lock()
+	delete(c.issuedConnIDs, f.SequenceNumber)
+	c.connIDMutex.Unlock()
+}
+
+func (c *Connection) maybeSendHandshakeDone() {
+	if c.isClient || c.handshakeDoneSent || !c.tlsManager.HandshakeComplete() {
+		return
+	}
+	if err := c.SendPacket([]packet.Frame{&packet.HandshakeDoneFrame{}}); err == nil {
+		c.handshakeDoneSent = true
+	}
+}
+
+func (c *Connection) sendStreamData() {
+	c.streamsMutex.RLock()
+	streams := make([]*streamWrapper, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.streamsMutex.RUnlock()
+
+	budget := c.maxStreamDataPerPacket()
+	for _, stream := range streams {
+		for {
+			if c.pacer != nil && !c.pacer.hasCredit() {
+				// Over the configured send rate; the remaining data goes
+				// out on a later tick.
+				return
+			}
+			if !c.congestion.CanSend(uint64(budget)) {
+				// The congestion window is full; in-flight packets must be
+				// acknowledged or declared lost before more stream data
+				// goes out.
+				return
+			}
+			data, offset, fin, err := stream.GetSendData(budget)
+			if err != nil || (len(data) == 0 && !fin) {
+				break
+			}
+			frame := &packet.StreamFrame{
+				StreamID: stream.StreamID(),
+				Offset:   offset,
+				Data:     data,
+				Fin:      fin,
+			}
+			if c.isClient && !c.tlsManager.HandshakeComplete() {
+				c.bufferZeroRTTWrite(frame)
+			}
+			if err := c.SendPacket([]packet.Frame{frame}); err != nil {
+				return
+			}
+			if c.pacer != nil {
+				c.pacer.spend(len(data))
+			}
+			if fin {
+				if c.config.DebugStreamChecksums {
+					// The checksum travels after the final frame so the
+					// receiver has the full size to verify against.
+					size, sum := stream.SendChecksum()
+					c.SendPacket([]packet.Frame{&packet.StreamChecksumFrame{
+						StreamID:  stream.StreamID(),
+						FinalSize: size,
+						Checksum:  uint64(sum),
+					}})
+				}
+				break
+			}
+		}
+	}
+}
+
+// ecnValidationThreshold is how many marked packets we give the peer before
+// concluding that ECN marks are being stripped on this path.
+const ecnValidationThreshold = 10
+
+// ecnControlMessage returns the control message that marks the next datagram
+// ECT(0), or nil when ECN marking is disabled or unsupported.
+func (c *Connection) ecnControlMessage() []byte {
+	c.ecnMutex.Lock()
+	defer c.ecnMutex.Unlock()
+	if !c.ecnInit {
+		c.ecnInit = true
+		if udp, ok := c.udpConn.(*net.UDPConn); ok && !c.config.DisableECN {
+			c.ecnOOB = ecnSendControlMessage(udp)
+		}
+	}
+	return c.ecnOOB
+}
+
+// disableECNMarking stops setting ECT(0) on outgoing datagrams.
+func (c *Connection) disableECNMarking() {
+	c.ecnMutex.Lock()
+	c.ecnOOB = nil
+	c.ecnMutex.Unlock()
+}
+
+// validateECN checks the peer's reported ECN counts against the marks we
+// sent. A peer that acknowledges plenty of marked packets without reporting
+// any ECN counts indicates the path strips the marks, so marking stops
+// (RFC 9000 section 13.4.2).
+func (c *Connection) validateECN(f *packet.AckFrame) {
+	c.ecnMutex.Lock()
+	defer c.ecnMutex.Unlock()
+	if c.ecnOOB == nil {
+		return
+	}
+	if c.ecnSent >= ecnValidationThreshold &&
+		f.ECT0Count+f.ECT1Count+f.ECNCECount == 0 {
+		c.ecnOOB = nil
+	}
+}
+
+// bufferZeroRTTWrite remembers an early-data write for a possible replay.
+func (c *Connection) bufferZeroRTTWrite(frame *packet.StreamFrame) {
+	c.zeroRTTMutex.Lock()
+	c.zeroRTTWrites = append(c.zeroRTTWrites, zeroRTTWrite{
+		streamID: frame.StreamID,
+		offset:   frame.Offset,
+		data:     append([]byte(nil), frame.Data...),
+		fin:      frame.Fin,
+	})
+	c.zeroRTTMutex.Unlock()
+}
+
+// replayZeroRTT resends all buffered early-data writes through the 1-RTT
+// send path. The receiver discards duplicate offsets, so data that did get
+// through is still delivered exactly once.
+func (c *Connection) replayZeroRTT() {
+	c.zeroRTTMutex.Lock()
+	writes := c.zeroRTTWrites
+	c.zeroRTTWrites = nil
+	c.zeroRTTMutex.Unlock()
+
+	for _, w := range writes {
+		c.SendPacket([]packet.Frame{&packet.StreamFrame{
+			StreamID: w.streamID,
+			Offset:   w.offset,
+			Data:     w.data,
+			Fin:      w.fin,
+		}})
+	}
+}
+
+// discardZeroRTTBuffer drops the early-data replay buffer once the server
+// has accepted it.
+func (c *Connection) discardZeroRTTBuffer() {
+	c.zeroRTTMutex.Lock()
+	c.zeroRTTWrites = nil
+	c.zeroRTTMutex.Unlock()
+}
+
+// maxStreamDataPerPacket returns how many stream payload bytes fit into a
+// single packet once the short header, the AEAD tag and the STREAM frame
+// header are accounted for, so a large write is split across packets instead
+// of producing an oversized datagram.
+// maxDatagramSize is the UDP payload cap the send path must respect. There
+// is no path MTU prober yet, so the connection stays at this size for its
+// whole lifetime whether or not discovery is disabled.
+func (c *Connection) maxDatagramSize() uint64 {
+	if s := c.config.InitialPacketSize; s != 0 && s < c.config.MaxUDPPayloadSize {
+		return s
+	}
+	return c.config.MaxUDPPayloadSize
+}
+
+// Per-packet overheads used when budgeting payload space.
+const (
+	shortHeaderLen = 1 + connIDLen + 4 // flags, connection ID, max packet number
+	sealOverhead   = 16                // AES-GCM tag
+	maxFrameHeader = 1 + 8 + 8 + 4     // type, stream ID, offset and length varints
+)
+
+func (c *Connection) maxStreamDataPerPacket() int {
+	budget := int(c.maxDatagramSize()) - shortHeaderLen - sealOverhead - maxFrameHeader
+	if budget < 1 {
+		budget = 1
+	}
+	return budget
+}
+
+func (c *Connection) checkIdleTimeout() {
+	if c.config.MaxIdleTimeout <= 0 {
+		return
+	}
+	last := time.Unix(0, c.lastActivity.Load())
+	if c.clock.Now().Sub(last) > c.config.MaxIdleTimeout {
+		c.closeWithLocalError(ErrIdleTimeout)
+	}
+}
+
+// SendPacket serializes the frames into a single 1-RTT packet protected with
+// the application keys and writes it to the network.
+func (c *Connection) SendPacket(frames []packet.Frame) error {
+	return c.SendPacketAtLevel(crypto.LevelApplication, frames)
+}
+
+// SendPacketAtLevel serializes the frames into a packet of the type the given
+// encryption level requires, protected with that level's keys: long-header
+// Initial, 0-RTT and Handshake packets for the handshake levels, short-header
+// 1-RTT packets for application data.
+func (c *Connection) SendPacketAtLevel(level crypto.CryptoLevel, frames []packet.Frame) error {
+	select {
+	case <-c.closed:
+		return ErrConnectionClosed
+	default:
+	}
+
+	// Each space numbers its packets independently, starting at 0.
+	pn := c.sendPacketNumbers[pnSpaceForLevel(level)].Add(1) - 1
+	return c.sendWithPacketNumber(level, pn, frames)
+}
+
+// sendWithPacketNumber builds the header the level requires around an
+// already-allocated packet number and sends the frames under it.
+func (c *Connection) sendWithPacketNumber(level crypto.CryptoLevel, pn uint64, frames []packet.Frame) error {
+	header := &packet.Header{
+		DestConnID:   c.remoteConnID,
+		PacketNumber: pn,
+	}
+	switch level {
+	case crypto.LevelInitial:
+		header.Type = packet.PacketTypeInitial
+		header.Version = packet.Version1
+		header.SrcConnID = c.localConnID
+		if c.isClient {
+			header.DestConnID = c.originalDestConnID
+			header.Token = c.currentRetryToken()
+		}
+	case crypto.LevelEarlyData:
+		header.Type = packet.PacketTypeZeroRTT
+		header.Version = packet.Version1
+		header.SrcConnID = c.localConnID
+	case crypto.LevelHandshake:
+		header.Type = packet.PacketTypeHandshake
+		header.Version = packet.Version1
+		header.SrcConnID = c.localConnID
+	default:
+		header.Type = packet.PacketType1RTT
+	}
+	return c.sealAndSend(header, frames, level, pn)
+}
+
+func (c *Connection) sealAndSend(header *packet.Header, frames []packet.Frame,
+	level crypto.CryptoLevel, pn uint64) error {
+
+	c.cryptoMutex.RLock()
+	state := c.cryptoStates[level]
+	c.cryptoMutex.RUnlock()
+	if state == nil {
+		return fmt.Errorf("no keys for level %v", level)
+	}
+	// The builder accounts for the available space, so an oversized frame is
+	// caught here instead of overflowing the datagram at the socket.
+	builder := &packet.Builder{Sealer: state, MaxSize: int(c.maxDatagramSize())}
+	datagram, err := builder.Build(header, frames)
+	if err != nil {
+		return err
+	}
+
+	oob := c.ecnControlMessage()
+	remoteAddr := c.currentRemoteAddr()
+	if _, _, err := c.udpConn.WriteMsgUDP(datagram, oob, remoteAddr); err != nil {
+		if oob == nil {
+			return fmt.Errorf("writing datagram: %w", err)
+		}
+		// The socket rejected the control message; keep sending unmarked.
+		c.disableECNMarking()
+		if _, _, err := c.udpConn.WriteMsgUDP(datagram, nil, remoteAddr); err != nil {
+			return fmt.Errorf("writing datagram: %w", err)
+		}
+		oob = nil
+	}
+	if oob != nil {
+		c.ecnMutex.Lock()
+		c.ecnSent++
+		c.ecnMutex.Unlock()
+	}
+
+	// Only ack-eliciting packets are tracked: the peer never acknowledges
+	// the others, so they must not feed RTT measurement or loss detection.
+	if packet.HasAckElicitingFrames(frames) {
+		var refs []streamFrameRef
+		var cryptoRefs []cryptoFrameRef
+		for _, frame := range frames {
+			switch f := frame.(type) {
+			case *packet.StreamFrame:
+				refs = append(refs, streamFrameRef{
+					streamID: f.StreamID,
+					offset:   f.Offset,
+					length:   len(f.Data),
+				})
+			case *packet.CryptoFrame:
+				cryptoRefs = append(cryptoRefs, cryptoFrameRef{
+					level:  level,
+					offset: f.Offset,
+					length: len(f.Data),
+				})
+			}
+		}
+		c.sentMutex.Lock()
+		c.sentPackets[pnSpaceForLevel(level)][pn] = sentPacketInfo{
+			sentTime:     c.clock.Now(),
+			size:         len(datagram),
+			streamFrames: refs,
+			cryptoFrames: cryptoRefs,
+		}
+		c.sentMutex.Unlock()
+		c.congestion.OnPacketSent(uint64(len(datagram)))
+		c.armPTOTimer()
+	}
+
+	c.statsMutex.Lock()
+	c.stats.PacketsSent++
+	c.stats.BytesSent += uint64(len(datagram))
+	c.stats.LastPacketSentAt = c.clock.Now()
+	c.statsMutex.Unlock()
+	return nil
+}
+
+func (c *Connection) sendResetStream(streamID, errorCode, finalSize uint64) error {
+	return c.SendPacket([]packet.Frame{&packet.ResetStreamFrame{
+		StreamID:  streamID,
+		ErrorCode: errorCode,
+		FinalSize: finalSize,
+	}})
+}
+
+// onStreamDataConsumed extends the peer's flow control credit once the
+// application has read half of the current window.
+func (c *Connection) onStreamDataConsumed(s *Stream) {
+	window := s.recvWindow
+	newLimit := s.readOffset + window
+	if newLimit < s.maxData+window/2 {
+		return
+	}
+	s.maxData = newLimit
+	c.sendMaxStreamData(s.id, newLimit)
+}
+
+// sendMaxStreamData advertises a new receive limit for the stream.
+func (c *Connection) sendMaxStreamData(streamID, maximumData uint64) {
+	c.SendPacket([]packet.Frame{&packet.MaxStreamDataFrame{
+		StreamID:    streamID,
+		MaximumData: maximumData,
+	}})
+}
+
+// Close closes the connection with a no-error application close.
+func (c *Connection) Close() error {
+	return c.CloseWithError(0, "")
+}
+
+// CloseWithError closes the connection with an application error code.
+func (c *Connection) CloseWithError(errorCode uint64, reason string) error {
+	frame := &packet.ConnectionCloseFrame{
+		IsApplication: true,
+		ErrorCode:     errorCode,
+		ReasonPhrase:  reason,
+	}
+	c.teardown(nil, frame)
+	return nil
+}
+
+func (c *Connection) closeWithLocalError(err error) {
+	frame := &packet.ConnectionCloseFrame{
+		ErrorCode:    packet.InternalError,
+		ReasonPhrase: err.Error(),
+	}
+	c.teardown(err, frame)
+}
+
+// teardown performs the one-time connection shutdown: it sends the close
+// frame (if any), closes all streams and releases the socket.
+func (c *Connection) teardown(err error, frame *packet.ConnectionCloseFrame) {
+	c.closeOnce.Do(func() {
+		if frame != nil {
+			if frame.IsApplication && !c.tlsManager.HandshakeComplete() {
+				// An application CONNECTION_CLOSE (0x1d) must not be sent
+				// before the handshake completes (RFC 9000 section 10.2.3);
+				// wrap it as a transport close carrying APPLICATION_ERROR.
+				frame = &packet.ConnectionCloseFrame{
+					ErrorCode:    packet.ApplicationError,
+					ReasonPhrase: frame.ReasonPhrase,
+				}
+			}
+			c.SendPacket([]packet.Frame{frame})
+		}
+		c.closeMtx.Lock()
+		c.closeErr = err
+		c.closeFrame = frame
+		c.closeMtx.Unlock()
+		close(c.closed)
+		c.cancel()
+		c.stopPTOTimer()
+
+		// Unblock anyone waiting on the handshake if it never finished.
+		failErr := err
+		if failErr == nil {
+			failErr = ErrConnectionClosed
+		}
+		c.tlsManager.Fail(failErr)
+
+		c.streamsMutex.RLock()
+		for _, s := range c.streams {
+			s.closeWithError(failErr)
+		}
+		c.streamsMutex.RUnlock()
+
+		if c.onClosed != nil {
+			c.onClosed(c)
+		}
+		if c.ownsSocket {
+			c.udpConn.Close()
+		}
+	})
+}
+
+// wait blocks until the connection is closed and returns the close reason.
+func (c *Connection) wait() error {
+	<-c.closed
+	c.closeMtx.Lock()
+	defer c.closeMtx.Unlock()
+	return c.closeErr
+}
+
+// closeReason returns the recorded close error, falling back to
+// ErrConnectionClosed when the connection closed without one. Blocked stream
+// operations report it so callers learn why the connection went away.
+func (c *Connection) closeReason() error {
+	c.closeMtx.Lock()
+	defer c.closeMtx.Unlock()
+	if c.closeErr != nil {
+		return c.closeErr
+	}
+	return ErrConnectionClosed
+}
+
+// migrateToPreferredAddress moves the connection to the server's advertised
+// preferred address once the handshake is confirmed, probing the new path
+// with a PATH_CHALLENGE. Nothing happens when the server advertised none or
+// the connection already uses it.
+func (c *Connection) migrateToPreferredAddress() {
+	params, ok := c.tlsManager.PeerTransportParameters()
+	if !ok || params.PreferredAddress == "" {
+		return
+	}
+	addr, err := net.ResolveUDPAddr("udp", params.PreferredAddress)
+	if err != nil {
+		return
+	}
+	if udpAddrPort(addr) == c.currentRemoteAddrPort() {
+		return
+	}
+	c.MigrateTo(addr)
+}
+
+// MigrateTo switches the connection to a new remote address and probes the
+// path with a PATH_CHALLENGE.
+func (c *Connection) MigrateTo(addr *net.UDPAddr) error {
+	if !c.isClient {
+		return fmt.Errorf("only clients can migrate")
+	}
+	if params, ok := c.tlsManager.PeerTransportParameters(); ok && params.DisableActiveMigration {
+		return fmt.Errorf("peer advertised disable_active_migration")
+	}
+	var challenge packet.PathChallengeFrame
+	if _, err := rand.Read(challenge.Data[:]); err != nil {
+		return err
+	}
+	c.remoteAddrMutex.Lock()
+	c.remoteAddr = addr
+	c.remoteAddrPort = udpAddrPort(addr)
+	c.remoteAddrMutex.Unlock()
+	return c.SendPacket([]packet.Frame{&challenge})
+}
@@ -0,0 +1,1332 @@
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/QUIC/congestion"
+	"github.com/phuhao00/QUIC/crypto"
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// newTestConnection builds a connection over a throwaway socket without
+// starting its background loops.
+func newTestConnection(t *testing.T, isClient bool) *Connection {
+	t.Helper()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udpConn.Close() })
+
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	conn, err := newConnection(udpConn, remote, isClient, false,
+		populateConfig(nil), &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestInvalidFrameClosesWithFrameType(t *testing.T) {
+	tests := []struct {
+		name      string
+		isClient  bool
+		frame     packet.Frame
+		frameType uint64
+	}{
+		{"handshake done at server", false, &packet.HandshakeDoneFrame{}, packet.FrameTypeHandshakeDone},
+		{"unexpected max data", true, &packet.MaxDataFrame{MaximumData: 1}, packet.FrameTypeMaxData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestConnection(t, tt.isClient)
+			conn.handleFrame(tt.frame, crypto.LevelApplication)
+
+			conn.closeMtx.Lock()
+			frame := conn.closeFrame
+			conn.closeMtx.Unlock()
+			if frame == nil {
+				t.Fatal("connection did not close")
+			}
+			if frame.IsApplication {
+				t.Error("close frame is application-level, want transport-level")
+			}
+			if frame.ErrorCode != packet.ProtocolViolation {
+				t.Errorf("ErrorCode = %#x, want PROTOCOL_VIOLATION", frame.ErrorCode)
+			}
+			if frame.FrameType != tt.frameType {
+				t.Errorf("FrameType = %#x, want %#x", frame.FrameType, tt.frameType)
+			}
+		})
+	}
+}
+
+func TestAppCloseBeforeHandshakeUsesTransportClose(t *testing.T) {
+	conn := newTestConnection(t, true)
+
+	// The handshake never completes on this connection, so the application
+	// close must go out wrapped as a transport close.
+	conn.CloseWithError(7, "going away")
+
+	conn.closeMtx.Lock()
+	frame := conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame == nil {
+		t.Fatal("connection did not record a close frame")
+	}
+	if frame.IsApplication {
+		t.Error("close frame is the 0x1d application variant, want 0x1c")
+	}
+	if frame.ErrorCode != packet.ApplicationError {
+		t.Errorf("ErrorCode = %#x, want APPLICATION_ERROR", frame.ErrorCode)
+	}
+	if frame.ReasonPhrase != "going away" {
+		t.Errorf("ReasonPhrase = %q, want %q", frame.ReasonPhrase, "going away")
+	}
+}
+
+func TestCloseUnblocksStreamReaders(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const readers = 4
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		s, err := conn.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			buf := make([]byte, 16)
+			_, err := s.Read(buf)
+			errs <- err
+		}()
+	}
+
+	// Give the readers a moment to block, then tear the connection down.
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	for i := 0; i < readers; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("Read returned %v, want ErrConnectionClosed", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("a Read did not unblock after Close")
+		}
+	}
+}
+
+func TestPacketsProtectedPerLevel(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := newConnection(udpConn, sink.LocalAddr().(*net.UDPAddr), true, false,
+		populateConfig(nil), &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		level      crypto.CryptoLevel
+		packetType uint8
+	}{
+		{crypto.LevelInitial, packet.PacketTypeInitial},
+		{crypto.LevelHandshake, packet.PacketTypeHandshake},
+		{crypto.LevelApplication, packet.PacketType1RTT},
+	}
+	for _, tt := range tests {
+		if err := conn.SendPacketAtLevel(tt.level, []packet.Frame{&packet.PingFrame{}}); err != nil {
+			t.Fatalf("SendPacketAtLevel(%v): %v", tt.level, err)
+		}
+
+		buf := make([]byte, 2048)
+		sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, _, err := sink.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("reading %v packet: %v", tt.level, err)
+		}
+		header, headerLen, err := packet.ParseHeader(buf[:n], connIDLen)
+		if err != nil {
+			t.Fatalf("parsing %v packet: %v", tt.level, err)
+		}
+		if header.Type != tt.packetType {
+			t.Errorf("level %v sent packet type %#x, want %#x", tt.level, header.Type, tt.packetType)
+		}
+
+		// The payload must open with this level's keys and no other's.
+		state, err := crypto.NewCryptoState(connID, tt.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.Open(header.PacketNumber, buf[headerLen:n], buf[:headerLen]); err != nil {
+			t.Errorf("level %v packet does not open with its own keys: %v", tt.level, err)
+		}
+		otherLevel := crypto.LevelHandshake
+		if tt.level == crypto.LevelHandshake {
+			otherLevel = crypto.LevelApplication
+		}
+		other, err := crypto.NewCryptoState(connID, otherLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Open(header.PacketNumber, buf[headerLen:n], buf[:headerLen]); err == nil {
+			t.Errorf("level %v packet opens with %v keys", tt.level, otherLevel)
+		}
+	}
+}
+
+func TestPacketNumberSpacesIndependent(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := newConnection(udpConn, sink.LocalAddr().(*net.UDPAddr), true, false,
+		populateConfig(nil), &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interleave sends across spaces; each space must number from 0 on its
+	// own.
+	sends := []struct {
+		level  crypto.CryptoLevel
+		wantPN uint64
+	}{
+		{crypto.LevelInitial, 0},
+		{crypto.LevelApplication, 0},
+		{crypto.LevelHandshake, 0},
+		{crypto.LevelApplication, 1},
+		{crypto.LevelInitial, 1},
+		{crypto.LevelHandshake, 1},
+	}
+	for i, s := range sends {
+		if err := conn.SendPacketAtLevel(s.level, []packet.Frame{&packet.PingFrame{}}); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 2048)
+		sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, _, err := sink.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, err := packet.ParseHeader(buf[:n], connIDLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.PacketNumber != s.wantPN {
+			t.Errorf("send %d (level %v): packet number %d, want %d",
+				i, s.level, header.PacketNumber, s.wantPN)
+		}
+	}
+}
+
+// prefixConnIDGenerator produces connection IDs with a fixed routing prefix.
+type prefixConnIDGenerator struct {
+	prefix []byte
+	length int
+}
+
+func (g *prefixConnIDGenerator) GenerateConnectionID() ([]byte, error) {
+	id := make([]byte, g.length)
+	copy(id, g.prefix)
+	if _, err := rand.Read(id[len(g.prefix):]); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (g *prefixConnIDGenerator) ConnectionIDLen() int { return g.length }
+
+func TestConnectionIDGeneratorPrefix(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	gen := &prefixConnIDGenerator{prefix: []byte("lb"), length: 10}
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true},
+		&Config{ConnectionIDGenerator: gen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if len(conn.localConnID) != gen.length {
+		t.Errorf("local connection ID length = %d, want %d", len(conn.localConnID), gen.length)
+	}
+	if string(conn.localConnID[:2]) != "lb" {
+		t.Errorf("local connection ID %x lacks the generator's prefix", conn.localConnID)
+	}
+
+	// The custom-length ID must not break the return path: run an echo.
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitReturnsPeerApplicationError(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var server *Connection
+	select {
+	case server = <-accepted:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not accept the connection")
+	}
+
+	conn.CloseWithError(7, "all done")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = server.Wait(ctx)
+	var appErr *ApplicationError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("Wait = %v, want *ApplicationError", err)
+	}
+	if appErr.ErrorCode != 7 || appErr.ReasonPhrase != "all done" {
+		t.Errorf("Wait error = %+v, want code 7 with reason \"all done\"", appErr)
+	}
+
+	// A clean local close reports no error.
+	if err := conn.Wait(context.Background()); err != nil {
+		t.Errorf("Wait after local close = %v, want nil", err)
+	}
+}
+
+// sealTestPacket builds an authenticated 1-RTT packet for conn, applying
+// mangle to the header bytes before sealing so the AAD matches the wire.
+func sealTestPacket(t *testing.T, conn *Connection, frame packet.Frame, mangle func([]byte)) []byte {
+	t.Helper()
+	header := &packet.Header{
+		Type:         packet.PacketType1RTT,
+		DestConnID:   conn.localConnID,
+		PacketNumber: 1,
+	}
+	headerBytes, err := header.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mangle != nil {
+		mangle(headerBytes)
+	}
+	payload, err := frame.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := conn.cryptoStates[crypto.LevelApplication].Seal(1, payload, headerBytes)
+	return append(headerBytes, sealed...)
+}
+
+func TestReservedBitsCloseConnection(t *testing.T) {
+	conn := newTestConnection(t, false)
+	conn.handleDatagram(sealTestPacket(t, conn, &packet.PingFrame{}, func(hb []byte) {
+		hb[0] |= 0x10 // a short-header reserved bit
+	}))
+
+	conn.closeMtx.Lock()
+	frame := conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame == nil {
+		t.Fatal("connection did not close")
+	}
+	if frame.ErrorCode != packet.ProtocolViolation {
+		t.Errorf("ErrorCode = %#x, want PROTOCOL_VIOLATION", frame.ErrorCode)
+	}
+}
+
+func TestGreasedFixedBitTolerated(t *testing.T) {
+	conn := newTestConnection(t, false)
+	conn.handleDatagram(sealTestPacket(t, conn, &packet.PingFrame{}, func(hb []byte) {
+		hb[0] &^= 0x40 // grease the fixed bit
+	}))
+
+	select {
+	case <-conn.closed:
+		t.Fatal("greased packet closed the connection")
+	default:
+	}
+	if got := conn.GetStats().PacketsReceived; got != 1 {
+		t.Errorf("PacketsReceived = %d, want 1", got)
+	}
+}
+
+func TestConnectionIDRotation(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true},
+		&Config{ConnectionIDRotation: 25 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Within a few intervals the client must have issued several IDs and the
+	// server's RETIRE_CONNECTION_ID responses must have pruned the old ones,
+	// keeping only the handshake ID's successor generation around.
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		conn.connIDMutex.Lock()
+		issued := conn.nextConnIDSeq - 1
+		active := len(conn.issuedConnIDs)
+		conn.connIDMutex.Unlock()
+		if issued >= 2 && active <= activeConnIDLimit {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.connIDMutex.Lock()
+	defer conn.connIDMutex.Unlock()
+	t.Fatalf("rotation stalled: issued %d IDs, %d still active",
+		conn.nextConnIDSeq-1, len(conn.issuedConnIDs))
+}
+
+func TestReplayedZeroRTTDropped(t *testing.T) {
+	conn := newTestConnection(t, false)
+	header := &packet.Header{
+		Type:         packet.PacketTypeZeroRTT,
+		Version:      packet.Version1,
+		DestConnID:   conn.localConnID,
+		SrcConnID:    conn.remoteConnID,
+		PacketNumber: 5,
+	}
+	headerBytes, err := header.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := (&packet.StreamFrame{StreamID: 0, Data: []byte("early")}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := conn.cryptoStates[crypto.LevelEarlyData].Seal(5, payload, headerBytes)
+	datagram := append(headerBytes, sealed...)
+
+	conn.handleDatagram(datagram)
+	conn.handleDatagram(datagram)
+
+	if got := conn.GetStats().PacketsReceived; got != 1 {
+		t.Errorf("PacketsReceived = %d, want 1 (replay dropped)", got)
+	}
+}
+
+// recordingReplayFilter is an EarlyDataReplayFilter that rejects everything
+// and records what it was asked about.
+type recordingReplayFilter struct {
+	connIDs [][]byte
+}
+
+func (f *recordingReplayFilter) Seen(connID []byte, pn uint64) bool {
+	f.connIDs = append(f.connIDs, append([]byte(nil), connID...))
+	return true
+}
+
+func TestEarlyDataReplayFilterConsulted(t *testing.T) {
+	conn := newTestConnection(t, false)
+	filter := &recordingReplayFilter{}
+	conn.config.EarlyDataReplayFilter = filter
+
+	header := &packet.Header{
+		Type:         packet.PacketTypeZeroRTT,
+		Version:      packet.Version1,
+		DestConnID:   conn.localConnID,
+		SrcConnID:    conn.remoteConnID,
+		PacketNumber: 1,
+	}
+	headerBytes, err := header.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := conn.cryptoStates[crypto.LevelEarlyData].Seal(1, []byte{packet.FrameTypePing}, headerBytes)
+	conn.handleDatagram(append(headerBytes, sealed...))
+
+	if got := conn.GetStats().PacketsReceived; got != 0 {
+		t.Errorf("PacketsReceived = %d, want 0 (filter rejects everything)", got)
+	}
+	if len(filter.connIDs) != 1 || string(filter.connIDs[0]) != string(conn.originalDestConnID) {
+		t.Errorf("filter consulted with %x, want once with %x", filter.connIDs, conn.originalDestConnID)
+	}
+}
+
+func TestStatsReflectCongestionState(t *testing.T) {
+	conn := newTestConnection(t, true)
+
+	stats := conn.GetStats()
+	if !stats.SlowStart || stats.Recovery {
+		t.Fatalf("fresh connection: SlowStart = %v, Recovery = %v, want true, false",
+			stats.SlowStart, stats.Recovery)
+	}
+	if stats.CongestionWindow == 0 {
+		t.Fatal("fresh connection reports a zero congestion window")
+	}
+
+	conn.congestion.OnPacketSent(3000)
+	if got := conn.GetStats().BytesInFlight; got != 3000 {
+		t.Fatalf("BytesInFlight = %d, want 3000", got)
+	}
+
+	// A loss event must show up as recovery with a reduced window.
+	before := stats.CongestionWindow
+	conn.congestion.OnPacketLost(1500)
+	stats = conn.GetStats()
+	if !stats.Recovery {
+		t.Error("Recovery is false after a loss event")
+	}
+	if stats.CongestionWindow >= before {
+		t.Errorf("CongestionWindow = %d after loss, want below %d", stats.CongestionWindow, before)
+	}
+	if got := stats.BytesInFlight; got != 1500 {
+		t.Errorf("BytesInFlight = %d after loss, want 1500", got)
+	}
+}
+
+func TestPingMeasuresRTT(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.Accept(context.Background())
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rtt, err := conn.Ping(ctx)
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if rtt <= 0 || rtt > time.Second {
+		t.Errorf("Ping RTT = %v, want a plausible loopback value", rtt)
+	}
+
+	// A cancelled context surfaces as its error.
+	cancelled, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+	if _, err := conn.Ping(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Ping with cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestPeerTransportParametersExposed(t *testing.T) {
+	const window = 2 * 1024 * 1024
+	ln, err := ListenAddr("127.0.0.1:0", nil,
+		&Config{InitialConnectionReceiveWindow: window, MaxConnectionReceiveWindow: 2 * window})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.Accept(context.Background())
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if params := conn.ConnectionState().PeerTransportParameters; params != nil {
+			if params.InitialMaxData != window {
+				t.Fatalf("peer initial_max_data = %d, want %d", params.InitialMaxData, window)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("peer transport parameters never became available")
+}
+
+func TestOpenUniStreamBlocksOnUniLimit(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, &Config{MaxIncomingUniStreams: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.Accept(context.Background())
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The limit comes from the peer's transport parameters; wait for them.
+	deadline := time.Now().Add(3 * time.Second)
+	for conn.ConnectionState().PeerTransportParameters == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("peer transport parameters never arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := conn.OpenUniStream(ctx); err != nil {
+			t.Fatalf("uni stream %d: %v", i, err)
+		}
+	}
+
+	// The third uni open must block on the exhausted limit while bidi
+	// opens are unaffected.
+	short, cancelShort := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelShort()
+	if _, err := conn.OpenUniStream(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third uni open = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := conn.OpenStream(); err != nil {
+		t.Fatalf("bidi open while uni limit exhausted: %v", err)
+	}
+
+	// A MAX_STREAMS (uni) frame raising the limit unblocks opens.
+	conn.handleMaxStreamsFrame(&packet.MaxStreamsFrame{Uni: true, MaximumStreams: 3})
+	if _, err := conn.OpenUniStream(ctx); err != nil {
+		t.Fatalf("uni open after limit raise: %v", err)
+	}
+}
+
+func TestSendBlocksOnFullCongestionWindow(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A mock clock keeps the PTO from firing mid-test.
+	cfg := populateConfig(&Config{Clock: newMockClock()})
+	conn, err := newConnection(udpConn, sink.LocalAddr().(*net.UDPAddr), true, false,
+		cfg, &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Room for a single full datagram and nothing more.
+	conn.setCongestionWindow(2000)
+	if got := conn.CongestionWindow(); got != 2000 {
+		t.Fatalf("CongestionWindow = %d, want 2000", got)
+	}
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(make([]byte, 8*1024)); err != nil {
+		t.Fatal(err)
+	}
+	conn.sendStreamData()
+
+	buf := make([]byte, 4096)
+	sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, _, err := sink.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.BytesInFlight(); got != uint64(n) {
+		t.Errorf("BytesInFlight = %d, want the %d bytes of the lone datagram", got, n)
+	}
+
+	// The window is now full: further sends stay queued.
+	conn.sendStreamData()
+	sink.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if n, _, err := sink.ReadFromUDP(buf); err == nil {
+		t.Fatalf("got a %d-byte datagram past the congestion window", n)
+	}
+	if !s.HasSendData() {
+		t.Error("stream reports no pending data while blocked on the window")
+	}
+}
+
+func TestDisablePathMTUDiscoveryCapsPackets(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const cap = 1200
+	cfg := populateConfig(&Config{
+		InitialPacketSize:       cap,
+		DisablePathMTUDiscovery: true,
+	})
+	conn, err := newConnection(udpConn, sink.LocalAddr().(*net.UDPAddr), true, false,
+		cfg, &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(make([]byte, 8*1024)); err != nil {
+		t.Fatal(err)
+	}
+	conn.sendStreamData()
+
+	// Every datagram, including the first full-sized ones, stays at or
+	// below the configured size; no larger probes appear.
+	buf := make([]byte, 4096)
+	got := 0
+	for {
+		sink.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, _, err := sink.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		got++
+		if n > cap {
+			t.Fatalf("datagram %d is %d bytes, above the %d cap", got, n, cap)
+		}
+	}
+	if got == 0 {
+		t.Fatal("no datagrams observed")
+	}
+}
+
+func TestConfiguredInitialCongestionWindow(t *testing.T) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := populateConfig(&Config{InitialCongestionWindow: 32})
+	conn, err := newConnection(udpConn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9},
+		true, false, cfg, &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got, want := conn.CongestionWindow(), 32*conn.maxDatagramSize(); got != want {
+		t.Errorf("CongestionWindow = %d, want %d", got, want)
+	}
+}
+
+func TestTracerObservesCongestionStateChange(t *testing.T) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type transition struct {
+		from, to congestion.CubicState
+	}
+	var transitions []transition
+	cfg := populateConfig(&Config{
+		Clock: newMockClock(),
+		ConnectionTracer: &ConnectionTracer{
+			CongestionStateChanged: func(from, to congestion.CubicState) {
+				transitions = append(transitions, transition{from, to})
+			},
+		},
+	})
+	conn, err := newConnection(udpConn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9},
+		true, false, cfg, &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A loss moves the controller from slow start into fast recovery.
+	conn.congestion.OnPacketSent(1200)
+	conn.congestion.OnPacketLost(1200)
+	if len(transitions) != 1 {
+		t.Fatalf("got %d transitions, want 1", len(transitions))
+	}
+	if transitions[0].from != congestion.StateSlowStart || transitions[0].to != congestion.StateFastRecovery {
+		t.Errorf("transition = %v -> %v, want %v -> %v",
+			transitions[0].from, transitions[0].to,
+			congestion.StateSlowStart, congestion.StateFastRecovery)
+	}
+}
+
+func TestOpenStreamSyncTimesOutWithoutStreamCredit(t *testing.T) {
+	conn := newTestConnection(t, true)
+	defer conn.Close()
+	conn.config.MaxStreamWaitTimeout = 50 * time.Millisecond
+	conn.bidiMutex.Lock()
+	conn.peerBidiLimit = 1
+	conn.bidiLimitKnown = true
+	conn.bidiMutex.Unlock()
+
+	if _, err := conn.OpenStreamSync(context.Background()); err != nil {
+		t.Fatalf("first open: %v", err)
+	}
+
+	// The limit is exhausted and nothing raises it, so the configured
+	// timeout must end the wait even without a context deadline.
+	start := time.Now()
+	_, err := conn.OpenStreamSync(context.Background())
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("err = %v, want os.ErrDeadlineExceeded", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("OpenStreamSync returned before the timeout")
+	}
+
+	// A MAX_STREAMS frame raising the limit unblocks further opens.
+	conn.handleMaxStreamsFrame(&packet.MaxStreamsFrame{MaximumStreams: 2})
+	if _, err := conn.OpenStreamSync(context.Background()); err != nil {
+		t.Fatalf("open after MAX_STREAMS: %v", err)
+	}
+}
+
+func TestMigrateToRejectedWhenPeerDisablesMigration(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, &Config{DisableActiveMigration: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.MigrateTo(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	if err == nil {
+		t.Fatal("MigrateTo succeeded against a peer that disabled migration")
+	}
+}
+
+func TestClientMigratesToServerPreferredAddress(t *testing.T) {
+	pref := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}
+	ln, err := ListenAddr("127.0.0.1:0", nil, &Config{PreferredAddress: pref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The migration happens when the server's HANDSHAKE_DONE arrives, which
+	// may trail DialAddr returning.
+	want := udpAddrPort(pref)
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn.currentRemoteAddrPort() == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("client still at %v, want preferred address %v", conn.currentRemoteAddrPort(), want)
+}
+
+func TestDuplicatePacketProcessedOnce(t *testing.T) {
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+
+	state, err := crypto.NewCryptoState(conn.localConnID, crypto.LevelApplication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := &packet.Builder{Sealer: state, MaxSize: 1200}
+	dgram, err := builder.Build(&packet.Header{
+		Type:         packet.PacketType1RTT,
+		DestConnID:   conn.localConnID,
+		PacketNumber: 7,
+	}, []packet.Frame{&packet.StreamFrame{StreamID: 0, Data: []byte("once")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.handleDatagram(dgram)
+	conn.handleDatagram(dgram)
+
+	conn.streamsMutex.RLock()
+	stream := conn.streams[0]
+	conn.streamsMutex.RUnlock()
+	if stream == nil {
+		t.Fatal("stream 0 was not created")
+	}
+	if got := stream.GetStats().BytesReceived; got != 4 {
+		t.Errorf("BytesReceived = %d, want 4 after a duplicate delivery", got)
+	}
+	if got := conn.GetStats().PacketsReceived; got != 1 {
+		t.Errorf("PacketsReceived = %d, want 1 for a duplicated packet number", got)
+	}
+}
+
+func TestFrameCountLimitClosesConnection(t *testing.T) {
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+	conn.config.MaxFramesPerPacket = 100
+
+	state, err := crypto.NewCryptoState(conn.localConnID, crypto.LevelApplication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := make([]packet.Frame, 101)
+	for i := range frames {
+		frames[i] = &packet.PingFrame{}
+	}
+	builder := &packet.Builder{Sealer: state}
+	dgram, err := builder.Build(&packet.Header{
+		Type:       packet.PacketType1RTT,
+		DestConnID: conn.localConnID,
+	}, frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.handleDatagram(dgram)
+
+	conn.closeMtx.Lock()
+	frame := conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame == nil {
+		t.Fatal("pathological many-frame packet did not close the connection")
+	}
+	if frame.ErrorCode != packet.ProtocolViolation {
+		t.Errorf("ErrorCode = %#x, want PROTOCOL_VIOLATION", frame.ErrorCode)
+	}
+}
+
+func TestRemoteCloseUnblocksPendingStreamOperations(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, &Config{MaxIncomingStreams: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serverConns := make(chan *Connection, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err == nil {
+			serverConns <- conn
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var server *Connection
+	select {
+	case server = <-serverConns:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not accept the connection")
+	}
+
+	s, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Block a reader, a writer (by outrunning the peer's stream window), an
+	// accept and a credit-starved open, then close from the remote side.
+	errs := make(chan error, 4)
+	go func() {
+		buf := make([]byte, 16)
+		_, err := s.Read(buf)
+		errs <- err
+	}()
+	go func() {
+		payload := make([]byte, 64*1024)
+		for {
+			if _, err := s.Write(payload); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		_, err := conn.AcceptStream(context.Background())
+		errs <- err
+	}()
+	go func() {
+		conn.OpenStreamSync(context.Background()) // consumes the last credit
+		_, err := conn.OpenStreamSync(context.Background())
+		errs <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond) // let the goroutines block
+	server.CloseWithError(42, "going away")
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("a pending operation returned nil after the remote close")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a pending stream operation did not unblock on remote close")
+		}
+	}
+}
+
+func TestStreamBeyondAdvertisedLimitClosesConnection(t *testing.T) {
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+	conn.config.MaxIncomingStreams = 2
+
+	// Client-initiated bidirectional stream IDs 0 and 4 are the two we
+	// advertised; both must be accepted.
+	conn.handleStreamFrame(&packet.StreamFrame{StreamID: 4, Data: []byte("ok")})
+	conn.closeMtx.Lock()
+	frame := conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame != nil {
+		t.Fatalf("connection closed for in-limit stream: %+v", frame)
+	}
+
+	// Stream ID 8 implies a third stream, one past the advertised limit.
+	conn.handleStreamFrame(&packet.StreamFrame{StreamID: 8, Data: []byte("no")})
+	conn.closeMtx.Lock()
+	frame = conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame == nil {
+		t.Fatal("connection did not close")
+	}
+	if frame.ErrorCode != packet.StreamLimitError {
+		t.Errorf("ErrorCode = %#x, want STREAM_LIMIT_ERROR", frame.ErrorCode)
+	}
+}
+
+func TestGracefulShutdownRejectsNewStreamsKeepsExisting(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// Establish a stream before the shutdown and prove it echoes.
+	cs, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ss, err := server.AcceptStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(ss, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	server.InitiateGracefulShutdown()
+
+	if _, err := server.AcceptStream(context.Background()); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("AcceptStream after shutdown = %v, want ErrShuttingDown", err)
+	}
+
+	// The pre-existing stream keeps working in both directions.
+	if _, err := ss.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 6)
+	if _, err := io.ReadFull(cs, echo); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo) != "before" {
+		t.Errorf("echoed %q, want %q", echo, "before")
+	}
+}
+
+func TestSendTimestampsIncreaseMonotonically(t *testing.T) {
+	conn := newTestConnection(t, true)
+	defer conn.Close()
+
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		if err := conn.SendPacket([]packet.Frame{&packet.PingFrame{}}); err != nil {
+			t.Fatal(err)
+		}
+		got := conn.GetStats().LastPacketSentAt
+		if got.IsZero() {
+			t.Fatal("LastPacketSentAt is zero after a send")
+		}
+		if got.Before(last) {
+			t.Errorf("LastPacketSentAt went backwards: %v then %v", last, got)
+		}
+		last = got
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPostResetDataWithinFinalSizeIgnored(t *testing.T) {
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+
+	conn.handleStreamFrame(&packet.StreamFrame{StreamID: 0, Data: []byte("head")})
+	conn.streamsMutex.RLock()
+	stream := conn.streams[0]
+	conn.streamsMutex.RUnlock()
+	stream.handleReset(7, 10)
+
+	// A retransmission racing the reset stays inside the final size and
+	// must be dropped without touching the connection.
+	conn.handleStreamFrame(&packet.StreamFrame{StreamID: 0, Offset: 4, Data: []byte("more")})
+	conn.closeMtx.Lock()
+	frame := conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame != nil {
+		t.Fatalf("connection closed for benign post-reset data: %+v", frame)
+	}
+}
+
+func TestPostResetDataBeyondFinalSizeClosesConnection(t *testing.T) {
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+
+	conn.handleStreamFrame(&packet.StreamFrame{StreamID: 0, Data: []byte("head")})
+	conn.streamsMutex.RLock()
+	stream := conn.streams[0]
+	conn.streamsMutex.RUnlock()
+	stream.handleReset(7, 10)
+
+	// Offset 8 plus four bytes extends past the final size of 10.
+	conn.handleStreamFrame(&packet.StreamFrame{StreamID: 0, Offset: 8, Data: []byte("over")})
+	conn.closeMtx.Lock()
+	frame := conn.closeFrame
+	conn.closeMtx.Unlock()
+	if frame == nil {
+		t.Fatal("connection did not close")
+	}
+	if frame.ErrorCode != packet.FinalSizeError {
+		t.Errorf("ErrorCode = %#x, want FINAL_SIZE_ERROR", frame.ErrorCode)
+	}
+}
+
+func TestAckReleasesCryptoData(t *testing.T) {
+	conn := newTestConnection(t, true)
+	defer conn.Close()
+
+	if err := conn.tlsManager.StartHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	data, offset := conn.tlsManager.GetCryptoFrameData(crypto.LevelInitial)
+	if data == nil {
+		t.Fatal("no ClientHello queued")
+	}
+	err := conn.SendPacketAtLevel(crypto.LevelInitial,
+		[]packet.Frame{&packet.CryptoFrame{Offset: offset, Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.handleAckFrame(&packet.AckFrame{LargestAcked: 0}, crypto.LevelInitial)
+
+	// Even an immediate staleness sweep must find nothing to resend.
+	conn.tlsManager.RetransmitStale(0)
+	if d, _ := conn.tlsManager.GetCryptoFrameData(crypto.LevelInitial); d != nil {
+		t.Fatalf("acknowledged crypto data requeued: %d bytes", len(d))
+	}
+}
+
+func TestLostCryptoPacketRequeuesData(t *testing.T) {
+	conn := newTestConnection(t, true)
+	defer conn.Close()
+
+	if err := conn.tlsManager.StartHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	data, offset := conn.tlsManager.GetCryptoFrameData(crypto.LevelInitial)
+	if data == nil {
+		t.Fatal("no ClientHello queued")
+	}
+	err := conn.SendPacketAtLevel(crypto.LevelInitial,
+		[]packet.Frame{&packet.CryptoFrame{Offset: offset, Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three more packets put the crypto packet past the reordering
+	// threshold once the last one is acknowledged.
+	for i := 0; i < 3; i++ {
+		err := conn.SendPacketAtLevel(crypto.LevelInitial,
+			[]packet.Frame{&packet.PingFrame{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn.handleAckFrame(&packet.AckFrame{LargestAcked: 3}, crypto.LevelInitial)
+
+	requeued, reoffset := conn.tlsManager.GetCryptoFrameData(crypto.LevelInitial)
+	if string(requeued) != string(data) || reoffset != offset {
+		t.Fatalf("requeued %d bytes at %d, want the lost %d bytes at %d",
+			len(requeued), reoffset, len(data), offset)
+	}
+}
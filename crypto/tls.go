@@ -0,0 +1,820 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Handshake message types exchanged in CRYPTO frames. The handshake is a
+// simplified stand-in for TLS 1.3: the client offers its name, protocols and
+// transport parameters; the server answers with its selections, parameters
+// and certificate chain; the client confirms with a finished message.
+const (
+	messageTypeClientHello = 0x01
+	messageTypeServerHello = 0x02
+	messageTypeFinished    = 0x03
+)
+
+// Transport parameter identifiers (RFC 9000 section 18.2).
+const (
+	paramMaxIdleTimeout        = 0x01
+	paramMaxUDPPayloadSize     = 0x03
+	paramInitialMaxData        = 0x04
+	paramInitialMaxStreamData  = 0x05
+	paramInitialMaxStreamsBidi = 0x08
+	paramInitialMaxStreamsUni  = 0x09
+	paramAckDelayExponent      = 0x0a
+	paramMaxAckDelay           = 0x0b
+	paramDisableMigration      = 0x0c
+	paramPreferredAddress      = 0x0d
+	paramActiveConnIDLimit     = 0x0e
+	paramGreaseQUICBit         = 0x2ab2 // RFC 9287
+)
+
+// TransportParameters are the QUIC transport parameters exchanged during the
+// handshake. Durations are carried in milliseconds on the wire.
+type TransportParameters struct {
+	MaxIdleTimeout          uint64
+	MaxUDPPayloadSize       uint64
+	InitialMaxData          uint64
+	InitialMaxStreamData    uint64
+	InitialMaxStreamsBidi   uint64
+	InitialMaxStreamsUni    uint64
+	AckDelayExponent        uint64
+	MaxAckDelay             uint64
+	ActiveConnectionIDLimit uint64
+	// DisableActiveMigration announces that the endpoint does not support
+	// the peer migrating to a new address (RFC 9000 section 18.2).
+	DisableActiveMigration bool
+	// PreferredAddress is the address a server would like the client to
+	// migrate to after the handshake; empty advertises none. It is carried
+	// as "host:port" text rather than the RFC 9000 binary layout, which is
+	// enough for the endpoints of this implementation.
+	PreferredAddress string
+	// GreaseQUICBit announces that the endpoint tolerates packets with a
+	// cleared fixed bit (RFC 9287).
+	GreaseQUICBit bool
+}
+
+func (p *TransportParameters) serialize() []byte {
+	buf := &bytes.Buffer{}
+	writeParam := func(id uint16, value uint64) {
+		binary.Write(buf, binary.BigEndian, id)
+		binary.Write(buf, binary.BigEndian, uint16(8))
+		binary.Write(buf, binary.BigEndian, value)
+	}
+	writeParam(paramMaxIdleTimeout, p.MaxIdleTimeout)
+	writeParam(paramMaxUDPPayloadSize, p.MaxUDPPayloadSize)
+	writeParam(paramInitialMaxData, p.InitialMaxData)
+	writeParam(paramInitialMaxStreamData, p.InitialMaxStreamData)
+	writeParam(paramInitialMaxStreamsBidi, p.InitialMaxStreamsBidi)
+	writeParam(paramInitialMaxStreamsUni, p.InitialMaxStreamsUni)
+	writeParam(paramAckDelayExponent, p.AckDelayExponent)
+	writeParam(paramMaxAckDelay, p.MaxAckDelay)
+	writeParam(paramActiveConnIDLimit, p.ActiveConnectionIDLimit)
+	if p.DisableActiveMigration {
+		writeParam(paramDisableMigration, 1)
+	}
+	if p.PreferredAddress != "" {
+		binary.Write(buf, binary.BigEndian, uint16(paramPreferredAddress))
+		binary.Write(buf, binary.BigEndian, uint16(len(p.PreferredAddress)))
+		buf.WriteString(p.PreferredAddress)
+	}
+	if p.GreaseQUICBit {
+		writeParam(paramGreaseQUICBit, 1)
+	}
+	return buf.Bytes()
+}
+
+func parseTransportParameters(data []byte) (TransportParameters, error) {
+	var p TransportParameters
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return p, fmt.Errorf("truncated transport parameter")
+		}
+		id := binary.BigEndian.Uint16(data)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < length {
+			return p, fmt.Errorf("truncated transport parameter 0x%x", id)
+		}
+		value := data[:length]
+		data = data[length:]
+		if id == paramPreferredAddress {
+			p.PreferredAddress = string(value)
+			continue
+		}
+		if length != 8 {
+			continue // unknown encoding, skip
+		}
+		v := binary.BigEndian.Uint64(value)
+		switch id {
+		case paramMaxIdleTimeout:
+			p.MaxIdleTimeout = v
+		case paramMaxUDPPayloadSize:
+			p.MaxUDPPayloadSize = v
+		case paramInitialMaxData:
+			p.InitialMaxData = v
+		case paramInitialMaxStreamData:
+			p.InitialMaxStreamData = v
+		case paramInitialMaxStreamsBidi:
+			p.InitialMaxStreamsBidi = v
+		case paramInitialMaxStreamsUni:
+			p.InitialMaxStreamsUni = v
+		case paramAckDelayExponent:
+			p.AckDelayExponent = v
+		case paramMaxAckDelay:
+			p.MaxAckDelay = v
+		case paramActiveConnIDLimit:
+			p.ActiveConnectionIDLimit = v
+		case paramDisableMigration:
+			p.DisableActiveMigration = v != 0
+		case paramGreaseQUICBit:
+			p.GreaseQUICBit = v != 0
+		}
+	}
+	return p, nil
+}
+
+// TLSManager drives the handshake for one connection. Outbound handshake
+// messages are queued per encryption level and drained by the connection's
+// send loop via GetCryptoFrameData.
+type TLSManager struct {
+	mutex    sync.Mutex
+	isClient bool
+	tlsConf  *tls.Config
+
+	localParams   TransportParameters
+	peerParams    TransportParameters
+	peerParamsSet bool
+
+	sendQueue   map[CryptoLevel][]byte
+	sendOffsets map[CryptoLevel]uint64
+	// unacked holds handed-out crypto data until it is acknowledged;
+	// resendQueue holds data due for retransmission, served before new data.
+	unacked     map[CryptoLevel][]cryptoRange
+	resendQueue map[CryptoLevel][]cryptoRange
+
+	// Receive-side reassembly: recvBuffers holds out-of-order fragments by
+	// offset, recvOffsets is the next in-order offset per level, and
+	// assembled is the contiguous byte stream awaiting a complete message.
+	recvBuffers map[CryptoLevel]map[uint64][]byte
+	recvOffsets map[CryptoLevel]uint64
+	assembled   map[CryptoLevel][]byte
+
+	// onClientHello, when set on a server, runs after the ClientHello is
+	// parsed and before the ServerHello is built. Non-nil returned
+	// parameters replace the ones advertised to the client.
+	onClientHello func(serverName string, offeredProtocols []string) (*TransportParameters, error)
+
+	handshakeDone chan struct{}
+	complete      bool
+	// confirmed means the peer demonstrably holds the whole handshake, so
+	// nothing remains to retransmit: servers confirm when the client's
+	// Finished arrives, clients when HANDSHAKE_DONE does.
+	confirmed bool
+	err       error
+
+	negotiatedProtocol string
+	serverName         string
+	offeredProtocols   []string
+	peerCertificates   []*x509.Certificate
+}
+
+// NewTLSManager creates a handshake manager for one end of a connection.
+func NewTLSManager(isClient bool, tlsConf *tls.Config, params TransportParameters) *TLSManager {
+	if tlsConf == nil {
+		tlsConf = &tls.Config{}
+	}
+	return &TLSManager{
+		isClient:      isClient,
+		tlsConf:       tlsConf,
+		localParams:   params,
+		sendQueue:     make(map[CryptoLevel][]byte),
+		sendOffsets:   make(map[CryptoLevel]uint64),
+		unacked:       make(map[CryptoLevel][]cryptoRange),
+		resendQueue:   make(map[CryptoLevel][]cryptoRange),
+		recvBuffers:   make(map[CryptoLevel]map[uint64][]byte),
+		recvOffsets:   make(map[CryptoLevel]uint64),
+		assembled:     make(map[CryptoLevel][]byte),
+		handshakeDone: make(chan struct{}),
+	}
+}
+
+// cryptoRange is a contiguous chunk of handshake data handed to the send
+// loop, kept until acknowledged so it can be retransmitted.
+type cryptoRange struct {
+	offset uint64
+	data   []byte
+	sentAt time.Time
+}
+
+// SetOnClientHello registers a server-side callback invoked once the
+// ClientHello has been parsed, before the ServerHello is built. Returning
+// updated transport parameters replaces the advertised ones; returning an
+// error aborts the handshake.
+func (m *TLSManager) SetOnClientHello(cb func(serverName string, offeredProtocols []string) (*TransportParameters, error)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.onClientHello = cb
+}
+
+// StartHandshake queues the ClientHello. It is a no-op on the server, which
+// only responds to the client's first flight.
+func (m *TLSManager) StartHandshake() error {
+	if !m.isClient {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	hello, err := m.buildClientHello()
+	if err != nil {
+		return err
+	}
+	m.sendQueue[LevelInitial] = append(m.sendQueue[LevelInitial], hello...)
+	return nil
+}
+
+// GetCryptoFrameData returns pending outbound handshake bytes for the given
+// level together with their stream offset, or nil if there are none. Data due
+// for retransmission is served before new data; everything handed out is kept
+// until acknowledged.
+func (m *TLSManager) GetCryptoFrameData(level CryptoLevel) ([]byte, uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if queue := m.resendQueue[level]; len(queue) > 0 {
+		r := queue[0]
+		m.resendQueue[level] = queue[1:]
+		r.sentAt = time.Now()
+		m.unacked[level] = append(m.unacked[level], r)
+		return r.data, r.offset
+	}
+	data := m.sendQueue[level]
+	if len(data) == 0 {
+		return nil, 0
+	}
+	delete(m.sendQueue, level)
+	offset := m.sendOffsets[level]
+	m.sendOffsets[level] = offset + uint64(len(data))
+	m.unacked[level] = append(m.unacked[level], cryptoRange{
+		offset: offset,
+		data:   data,
+		sentAt: time.Now(),
+	})
+	return data, offset
+}
+
+// AckCrypto releases handshake data fully covered by the acknowledged range
+// [offset, offset+length) so it is no longer retransmitted.
+func (m *TLSManager) AckCrypto(level CryptoLevel, offset uint64, length int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	end := offset + uint64(length)
+	kept := m.unacked[level][:0]
+	for _, r := range m.unacked[level] {
+		if r.offset >= offset && r.offset+uint64(len(r.data)) <= end {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	m.unacked[level] = kept
+}
+
+// RetransmitRange moves the outstanding handshake ranges fully covered by
+// [offset, offset+length) onto the resend queue, for ack-based loss
+// detection declaring the packet that carried them lost.
+func (m *TLSManager) RetransmitRange(level CryptoLevel, offset uint64, length int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	end := offset + uint64(length)
+	kept := m.unacked[level][:0]
+	for _, r := range m.unacked[level] {
+		if r.offset >= offset && r.offset+uint64(len(r.data)) <= end {
+			m.resendQueue[level] = append(m.resendQueue[level], r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	m.unacked[level] = kept
+}
+
+// RetransmitStale moves handshake data that has been outstanding for longer
+// than pto back onto the send queue. It backstops ack-based loss detection:
+// the connection's send loop calls it periodically so handshake data still
+// goes out when no ACK ever arrives to declare a loss.
+func (m *TLSManager) RetransmitStale(pto time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.confirmed {
+		return
+	}
+	cutoff := time.Now().Add(-pto)
+	for level, ranges := range m.unacked {
+		kept := ranges[:0]
+		for _, r := range ranges {
+			if r.sentAt.Before(cutoff) {
+				m.resendQueue[level] = append(m.resendQueue[level], r)
+				continue
+			}
+			kept = append(kept, r)
+		}
+		m.unacked[level] = kept
+	}
+}
+
+// ProcessCryptoFrame handles handshake data received from the peer. Fragments
+// may arrive out of order or duplicated; they are reassembled per level and
+// fed to the message dispatcher only once contiguous.
+func (m *TLSManager) ProcessCryptoFrame(level CryptoLevel, offset uint64, data []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(data) == 0 {
+		return nil
+	}
+
+	// Drop or trim data that was already delivered.
+	next := m.recvOffsets[level]
+	if offset < next {
+		if offset+uint64(len(data)) <= next {
+			return nil
+		}
+		data = data[next-offset:]
+		offset = next
+	}
+	buffer := m.recvBuffers[level]
+	if buffer == nil {
+		buffer = make(map[uint64][]byte)
+		m.recvBuffers[level] = buffer
+	}
+	if _, exists := buffer[offset]; !exists {
+		buffer[offset] = append([]byte(nil), data...)
+	}
+
+	// Move whatever is now contiguous into the assembled stream.
+	for {
+		chunk, ok := buffer[m.recvOffsets[level]]
+		if !ok {
+			break
+		}
+		delete(buffer, m.recvOffsets[level])
+		m.assembled[level] = append(m.assembled[level], chunk...)
+		m.recvOffsets[level] += uint64(len(chunk))
+	}
+
+	// Dispatch every complete message at the front of the stream.
+	for {
+		msg := m.assembled[level]
+		n, ok := messageLength(msg)
+		if !ok || len(msg) < n {
+			return nil
+		}
+		m.assembled[level] = msg[n:]
+		if err := m.dispatchMessage(msg[:n]); err != nil {
+			m.failLocked(err)
+			return err
+		}
+	}
+}
+
+// dispatchMessage routes one complete handshake message. The caller holds the
+// mutex.
+func (m *TLSManager) dispatchMessage(data []byte) error {
+	switch data[0] {
+	case messageTypeClientHello:
+		return m.processClientHello(data[1:])
+	case messageTypeServerHello:
+		return m.processServerHello(data[1:])
+	case messageTypeFinished:
+		return m.processFinished(data[1:])
+	default:
+		return fmt.Errorf("unknown handshake message type 0x%x", data[0])
+	}
+}
+
+// messageLength determines the total length of the handshake message at the
+// start of data by walking its length-prefixed fields. It returns false if
+// more bytes are needed to make that determination.
+func messageLength(data []byte) (int, bool) {
+	if len(data) == 0 {
+		return 0, false
+	}
+	// lenAt reads the 2-byte length prefix at n and advances past the field.
+	lenAt := func(n int) (int, bool) {
+		if len(data) < n+2 {
+			return 0, false
+		}
+		return n + 2 + int(binary.BigEndian.Uint16(data[n:])), true
+	}
+	switch data[0] {
+	case messageTypeFinished:
+		return 1, true
+	case messageTypeClientHello:
+		n, ok := lenAt(1 + 32) // server name
+		if !ok {
+			return 0, false
+		}
+		if len(data) < n+1 {
+			return 0, false
+		}
+		protoCount := int(data[n])
+		n++
+		for i := 0; i < protoCount; i++ {
+			if n, ok = lenAt(n); !ok {
+				return 0, false
+			}
+		}
+		if n, ok = lenAt(n); !ok { // transport parameters
+			return 0, false
+		}
+		return n, true
+	case messageTypeServerHello:
+		n, ok := lenAt(1 + 32) // negotiated protocol
+		if !ok {
+			return 0, false
+		}
+		if n, ok = lenAt(n); !ok { // transport parameters
+			return 0, false
+		}
+		if len(data) < n+1 {
+			return 0, false
+		}
+		certCount := int(data[n])
+		n++
+		for i := 0; i < certCount; i++ {
+			if len(data) < n+4 {
+				return 0, false
+			}
+			n += 4 + int(binary.BigEndian.Uint32(data[n:]))
+		}
+		return n, true
+	default:
+		// Unknown type: hand everything to the dispatcher, which reports
+		// the error.
+		return len(data), true
+	}
+}
+
+// HandshakeDone returns a channel that is closed once the handshake
+// completes or fails.
+func (m *TLSManager) HandshakeDone() <-chan struct{} {
+	return m.handshakeDone
+}
+
+// WaitForHandshake blocks until the handshake completes, fails, or the
+// context is cancelled, in which case the context's error is returned.
+func (m *TLSManager) WaitForHandshake(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.handshakeDone:
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+// HandshakeComplete reports whether the handshake finished successfully.
+func (m *TLSManager) HandshakeComplete() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.complete && m.err == nil
+}
+
+// NegotiatedProtocol returns the ALPN protocol agreed during the handshake.
+func (m *TLSManager) NegotiatedProtocol() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.negotiatedProtocol
+}
+
+// ServerName returns the SNI value in effect for the connection: on a server
+// the name received in the ClientHello, on a client the configured name.
+func (m *TLSManager) ServerName() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.isClient {
+		return m.tlsConf.ServerName
+	}
+	return m.serverName
+}
+
+// OfferedProtocols returns the ALPN protocols the client listed in its
+// ClientHello, in the order offered. It is empty on clients and before the
+// ClientHello has been processed.
+func (m *TLSManager) OfferedProtocols() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.offeredProtocols...)
+}
+
+// PeerTransportParameters returns the transport parameters the peer sent,
+// and whether they have been received yet.
+func (m *TLSManager) PeerTransportParameters() (TransportParameters, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.peerParams, m.peerParamsSet
+}
+
+// PeerCertificates returns the certificate chain presented by the peer.
+func (m *TLSManager) PeerCertificates() []*x509.Certificate {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.peerCertificates
+}
+
+func (m *TLSManager) buildClientHello() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(messageTypeClientHello)
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	buf.Write(random[:])
+	writeLenBytes(buf, []byte(m.tlsConf.ServerName))
+	buf.WriteByte(byte(len(m.tlsConf.NextProtos)))
+	for _, proto := range m.tlsConf.NextProtos {
+		writeLenBytes(buf, []byte(proto))
+	}
+	writeLenBytes(buf, m.localParams.serialize())
+	return buf.Bytes(), nil
+}
+
+func (m *TLSManager) processClientHello(data []byte) error {
+	if m.isClient {
+		return fmt.Errorf("client received ClientHello")
+	}
+	if len(data) < 32 {
+		return fmt.Errorf("short ClientHello")
+	}
+	data = data[32:] // skip random
+	serverName, data, err := readLenBytes(data)
+	if err != nil {
+		return fmt.Errorf("parsing ClientHello: %w", err)
+	}
+	m.serverName = string(serverName)
+	if len(data) < 1 {
+		return fmt.Errorf("short ClientHello")
+	}
+	protoCount := int(data[0])
+	data = data[1:]
+	var offered []string
+	for i := 0; i < protoCount; i++ {
+		var proto []byte
+		proto, data, err = readLenBytes(data)
+		if err != nil {
+			return fmt.Errorf("parsing ClientHello ALPN: %w", err)
+		}
+		offered = append(offered, string(proto))
+	}
+	m.offeredProtocols = offered
+	paramBytes, _, err := readLenBytes(data)
+	if err != nil {
+		return fmt.Errorf("parsing ClientHello parameters: %w", err)
+	}
+	m.peerParams, err = parseTransportParameters(paramBytes)
+	if err != nil {
+		return err
+	}
+	m.peerParamsSet = true
+
+	if m.onClientHello != nil {
+		updated, err := m.onClientHello(m.serverName, offered)
+		if err != nil {
+			return err
+		}
+		if updated != nil {
+			m.localParams = *updated
+		}
+	}
+
+	if m.negotiatedProtocol, err = selectALPN(m.tlsConf.NextProtos, offered); err != nil {
+		return err
+	}
+	hello, err := m.buildServerHello()
+	if err != nil {
+		return err
+	}
+	m.sendQueue[LevelHandshake] = append(m.sendQueue[LevelHandshake], hello...)
+	return nil
+}
+
+func (m *TLSManager) buildServerHello() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(messageTypeServerHello)
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	buf.Write(random[:])
+	writeLenBytes(buf, []byte(m.negotiatedProtocol))
+	writeLenBytes(buf, m.localParams.serialize())
+
+	cert, err := m.selectCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		buf.WriteByte(0)
+	} else {
+		buf.WriteByte(byte(len(cert.Certificate)))
+		for _, der := range cert.Certificate {
+			var lenBuf [4]byte
+			binary.BigEndian.PutUint32(lenBuf[:], uint32(len(der)))
+			buf.Write(lenBuf[:])
+			buf.Write(der)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *TLSManager) selectCertificate() (*tls.Certificate, error) {
+	if m.tlsConf.GetCertificate != nil {
+		return m.tlsConf.GetCertificate(&tls.ClientHelloInfo{ServerName: m.serverName})
+	}
+	if len(m.tlsConf.Certificates) > 0 {
+		return &m.tlsConf.Certificates[0], nil
+	}
+	return nil, nil
+}
+
+func (m *TLSManager) processServerHello(data []byte) error {
+	if !m.isClient {
+		return fmt.Errorf("server received ServerHello")
+	}
+	if len(data) < 32 {
+		return fmt.Errorf("short ServerHello")
+	}
+	data = data[32:]
+	proto, data, err := readLenBytes(data)
+	if err != nil {
+		return fmt.Errorf("parsing ServerHello: %w", err)
+	}
+	paramBytes, data, err := readLenBytes(data)
+	if err != nil {
+		return fmt.Errorf("parsing ServerHello parameters: %w", err)
+	}
+	m.peerParams, err = parseTransportParameters(paramBytes)
+	if err != nil {
+		return err
+	}
+	m.peerParamsSet = true
+
+	if len(m.tlsConf.NextProtos) > 0 {
+		found := false
+		for _, p := range m.tlsConf.NextProtos {
+			if p == string(proto) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no application protocol")
+		}
+	}
+	m.negotiatedProtocol = string(proto)
+
+	if len(data) < 1 {
+		return fmt.Errorf("short ServerHello")
+	}
+	certCount := int(data[0])
+	data = data[1:]
+	var certs []*x509.Certificate
+	for i := 0; i < certCount; i++ {
+		if len(data) < 4 {
+			return fmt.Errorf("truncated certificate")
+		}
+		certLen := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if len(data) < certLen {
+			return fmt.Errorf("truncated certificate")
+		}
+		cert, err := x509.ParseCertificate(data[:certLen])
+		if err != nil {
+			return fmt.Errorf("parsing certificate: %w", err)
+		}
+		certs = append(certs, cert)
+		data = data[certLen:]
+	}
+	m.peerCertificates = certs
+
+	if !m.tlsConf.InsecureSkipVerify {
+		if err := m.verifyPeerCertificates(certs); err != nil {
+			return err
+		}
+	}
+
+	m.sendQueue[LevelHandshake] = append(m.sendQueue[LevelHandshake], messageTypeFinished)
+	m.completeLocked()
+	return nil
+}
+
+func (m *TLSManager) verifyPeerCertificates(certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         m.tlsConf.RootCAs,
+		DNSName:       m.tlsConf.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("certificate verification failed: %w", err)
+	}
+	return nil
+}
+
+func (m *TLSManager) processFinished(data []byte) error {
+	if m.isClient {
+		return fmt.Errorf("client received Finished")
+	}
+	m.completeLocked()
+	return nil
+}
+
+func (m *TLSManager) completeLocked() {
+	if !m.complete {
+		m.complete = true
+		m.unacked = make(map[CryptoLevel][]cryptoRange)
+		m.resendQueue = make(map[CryptoLevel][]cryptoRange)
+		close(m.handshakeDone)
+		// A server only completes after processing the client's Finished,
+		// which proves the whole handshake arrived. A client must keep
+		// retransmitting its Finished until HANDSHAKE_DONE confirms it.
+		if !m.isClient {
+			m.confirmed = true
+		}
+	}
+}
+
+// Confirm records that the peer has the entire handshake, stopping crypto
+// retransmission. Clients call it when HANDSHAKE_DONE arrives.
+func (m *TLSManager) Confirm() {
+	m.mutex.Lock()
+	m.confirmed = true
+	m.mutex.Unlock()
+}
+
+// Fail aborts a pending handshake with the given error, unblocking any
+// waiters. It has no effect once the handshake already finished.
+func (m *TLSManager) Fail(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failLocked(err)
+}
+
+func (m *TLSManager) failLocked(err error) {
+	if !m.complete {
+		m.complete = true
+		m.err = err
+		close(m.handshakeDone)
+	}
+}
+
+// selectALPN picks the first protocol offered by the client that the server
+// supports. A server without configured protocols accepts the client's first
+// choice.
+func selectALPN(supported, offered []string) (string, error) {
+	if len(supported) == 0 {
+		if len(offered) > 0 {
+			return offered[0], nil
+		}
+		return "", nil
+	}
+	for _, s := range supported {
+		for _, o := range offered {
+			if s == o {
+				return s, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no application protocol")
+}
+
+func writeLenBytes(buf *bytes.Buffer, data []byte) {
+	var lenBuf [2]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(len(data)))
+	buf.Write(lenBuf[:])
+	buf.Write(data)
+}
+
+func readLenBytes(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, fmt.Errorf("truncated length prefix")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < length {
+		return nil, nil, fmt.Errorf("truncated value")
+	}
+	return data[:length], data[length:], nil
+}
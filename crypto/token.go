@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Token wire layout: 8-byte mint time (unix nanoseconds), 16 bytes of client
+// IP, and a SHA-256 HMAC over both.
+const (
+	tokenTimeLen  = 8
+	tokenIPLen    = 16
+	tokenBodyLen  = tokenTimeLen + tokenIPLen
+	tokenTotalLen = tokenBodyLen + sha256.Size
+)
+
+// TokenManager mints and verifies the address validation tokens carried in
+// Retry packets. A token binds the client's IP to its mint time with an HMAC
+// keyed by a per-server secret, so the server can validate it statelessly.
+type TokenManager struct {
+	secret   [32]byte
+	lifetime time.Duration
+}
+
+// NewTokenManager creates a manager with a fresh random secret. Tokens older
+// than lifetime are rejected.
+func NewTokenManager(lifetime time.Duration) (*TokenManager, error) {
+	m := &TokenManager{lifetime: lifetime}
+	if _, err := rand.Read(m.secret[:]); err != nil {
+		return nil, fmt.Errorf("generating token secret: %w", err)
+	}
+	return m, nil
+}
+
+// MintToken issues a token for the given client IP.
+func (m *TokenManager) MintToken(ip net.IP) []byte {
+	token := make([]byte, tokenBodyLen, tokenTotalLen)
+	binary.BigEndian.PutUint64(token, uint64(time.Now().UnixNano()))
+	copy(token[tokenTimeLen:], ip.To16())
+	mac := hmac.New(sha256.New, m.secret[:])
+	mac.Write(token)
+	return mac.Sum(token)
+}
+
+// ValidateToken checks that the token is authentic, unexpired, and was minted
+// for the given client IP.
+func (m *TokenManager) ValidateToken(token []byte, ip net.IP) error {
+	if len(token) != tokenTotalLen {
+		return fmt.Errorf("malformed token: %d bytes", len(token))
+	}
+	mac := hmac.New(sha256.New, m.secret[:])
+	mac.Write(token[:tokenBodyLen])
+	if !hmac.Equal(mac.Sum(nil), token[tokenBodyLen:]) {
+		return fmt.Errorf("token authentication failed")
+	}
+	minted := time.Unix(0, int64(binary.BigEndian.Uint64(token)))
+	if time.Since(minted) > m.lifetime {
+		return fmt.Errorf("token expired")
+	}
+	if !net.IP(token[tokenTimeLen:tokenBodyLen]).Equal(ip.To16()) {
+		return fmt.Errorf("token minted for a different address")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenValid(t *testing.T) {
+	m, err := NewTokenManager(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("192.0.2.7")
+	token := m.MintToken(ip)
+	if err := m.ValidateToken(token, ip); err != nil {
+		t.Errorf("ValidateToken = %v, want nil", err)
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	m, err := NewTokenManager(10 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("192.0.2.7")
+	token := m.MintToken(ip)
+	time.Sleep(20 * time.Millisecond)
+	if err := m.ValidateToken(token, ip); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("ValidateToken = %v, want expiry error", err)
+	}
+}
+
+func TestTokenWrongIP(t *testing.T) {
+	m, err := NewTokenManager(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := m.MintToken(net.ParseIP("192.0.2.7"))
+	if err := m.ValidateToken(token, net.ParseIP("203.0.113.9")); err == nil ||
+		!strings.Contains(err.Error(), "different address") {
+		t.Errorf("ValidateToken = %v, want address mismatch error", err)
+	}
+}
+
+func TestTokenTampered(t *testing.T) {
+	m, err := NewTokenManager(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("192.0.2.7")
+	token := m.MintToken(ip)
+	token[0] ^= 0xff
+	if err := m.ValidateToken(token, ip); err == nil {
+		t.Error("tampered token validated")
+	}
+	if err := m.ValidateToken(token[:10], ip); err == nil {
+		t.Error("truncated token validated")
+	}
+}
@@ -0,0 +1,114 @@
+package quic
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/phuhao00/QUIC/crypto"
+)
+
+func newHandshakePair() (client, server *crypto.TLSManager) {
+	client = crypto.NewTLSManager(true,
+		&tls.Config{InsecureSkipVerify: true, ServerName: "example.com"},
+		crypto.TransportParameters{})
+	server = crypto.NewTLSManager(false, &tls.Config{}, crypto.TransportParameters{})
+	return client, server
+}
+
+func TestLostCryptoFrameRetransmitted(t *testing.T) {
+	client, server := newHandshakePair()
+	if err := client.StartHandshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	hello, offset := client.GetCryptoFrameData(crypto.LevelInitial)
+	if hello == nil || offset != 0 {
+		t.Fatalf("GetCryptoFrameData = %d bytes at offset %d", len(hello), offset)
+	}
+
+	// The first flight is "lost": nothing reaches the server. Until the PTO
+	// fires, nothing is pending; after it, the same bytes are re-offered at
+	// the same offset.
+	if data, _ := client.GetCryptoFrameData(crypto.LevelInitial); data != nil {
+		t.Fatal("data pending before the PTO expired")
+	}
+	client.RetransmitStale(0)
+	retrans, offset := client.GetCryptoFrameData(crypto.LevelInitial)
+	if string(retrans) != string(hello) || offset != 0 {
+		t.Fatalf("retransmission = %d bytes at offset %d, want the original flight", len(retrans), offset)
+	}
+
+	// Deliver the retransmission and drive the handshake to completion.
+	if err := server.ProcessCryptoFrame(crypto.LevelInitial, offset, retrans); err != nil {
+		t.Fatal(err)
+	}
+	serverHello, shOffset := server.GetCryptoFrameData(crypto.LevelHandshake)
+	if err := client.ProcessCryptoFrame(crypto.LevelHandshake, shOffset, serverHello); err != nil {
+		t.Fatal(err)
+	}
+	finished, finOffset := client.GetCryptoFrameData(crypto.LevelHandshake)
+	if err := server.ProcessCryptoFrame(crypto.LevelHandshake, finOffset, finished); err != nil {
+		t.Fatal(err)
+	}
+	if !client.HandshakeComplete() || !server.HandshakeComplete() {
+		t.Fatal("handshake did not complete after the retransmission")
+	}
+}
+
+func TestAckedCryptoNotRetransmitted(t *testing.T) {
+	client, _ := newHandshakePair()
+	if err := client.StartHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	hello, offset := client.GetCryptoFrameData(crypto.LevelInitial)
+	if hello == nil {
+		t.Fatal("no ClientHello queued")
+	}
+
+	client.AckCrypto(crypto.LevelInitial, offset, len(hello))
+	client.RetransmitStale(0)
+	if data, _ := client.GetCryptoFrameData(crypto.LevelInitial); data != nil {
+		t.Fatalf("acked crypto data re-offered: %d bytes", len(data))
+	}
+}
+
+func TestOutOfOrderCryptoFragments(t *testing.T) {
+	client, server := newHandshakePair()
+	if err := client.StartHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	hello, offset := client.GetCryptoFrameData(crypto.LevelInitial)
+	if err := server.ProcessCryptoFrame(crypto.LevelInitial, offset, hello); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the ServerHello into three fragments and deliver them to the
+	// client out of order, with one duplicate.
+	serverHello, _ := server.GetCryptoFrameData(crypto.LevelHandshake)
+	third := len(serverHello) / 3
+	fragments := []struct {
+		offset int
+		data   []byte
+	}{
+		{2 * third, serverHello[2*third:]},
+		{third, serverHello[third : 2*third]},
+		{third, serverHello[third : 2*third]},
+		{0, serverHello[:third]},
+	}
+	for i, f := range fragments {
+		if err := client.ProcessCryptoFrame(crypto.LevelHandshake, uint64(f.offset), f.data); err != nil {
+			t.Fatalf("fragment %d: %v", i, err)
+		}
+	}
+	if !client.HandshakeComplete() {
+		t.Fatal("client handshake incomplete after reassembly")
+	}
+
+	finished, finOffset := client.GetCryptoFrameData(crypto.LevelHandshake)
+	if err := server.ProcessCryptoFrame(crypto.LevelHandshake, finOffset, finished); err != nil {
+		t.Fatal(err)
+	}
+	if !server.HandshakeComplete() {
+		t.Fatal("server handshake incomplete")
+	}
+}
@@ -0,0 +1,10 @@
+package quic
+
+// ECN codepoints carried in the two low bits of the IP TOS / traffic class
+// byte (RFC 3168).
+const (
+	ecnNotECT uint8 = 0x0
+	ecnECT1   uint8 = 0x1
+	ecnECT0   uint8 = 0x2
+	ecnCE     uint8 = 0x3
+)
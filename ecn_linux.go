@@ -0,0 +1,79 @@
+//go:build linux
+
+package quic
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enableECNReceiving asks the kernel to deliver the TOS / traffic class byte
+// as a control message with each received datagram.
+func enableECNReceiving(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		// Request both families: a dual-stack socket can receive either.
+		err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+		err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, 1)
+		if err4 != nil && err6 != nil {
+			sockErr = err4
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// ecnSendControlMessage returns the control message that marks outgoing
+// datagrams on this socket as ECT(0), or nil if the address family cannot be
+// determined.
+func ecnSendControlMessage(conn *net.UDPConn) []byte {
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	if local.IP.To4() != nil {
+		return marshalCmsg(syscall.IPPROTO_IP, syscall.IP_TOS, []byte{ecnECT0})
+	}
+	// IPv6 sockets take the traffic class as a 4-byte integer; the kernel
+	// maps it onto the TOS byte for v4-mapped destinations.
+	tclass := make([]byte, 4)
+	tclass[0] = ecnECT0
+	return marshalCmsg(syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tclass)
+}
+
+// marshalCmsg builds a single socket control message.
+func marshalCmsg(level, typ int32, data []byte) []byte {
+	b := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = level
+	h.Type = typ
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(b[syscall.CmsgLen(0):], data)
+	return b
+}
+
+// parseECN extracts the ECN codepoint from the control messages returned by
+// ReadMsgUDP. It returns ecnNotECT if no TOS information is present.
+func parseECN(oob []byte) uint8 {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return ecnNotECT
+	}
+	for _, m := range msgs {
+		if len(m.Data) == 0 {
+			continue
+		}
+		if (m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TOS) ||
+			(m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_TCLASS) {
+			return m.Data[0] & 0x3
+		}
+	}
+	return ecnNotECT
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package quic
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOutgoingPacketsMarkedECT0(t *testing.T) {
+	sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := enableECNReceiving(sink); err != nil {
+		t.Skipf("cannot enable ECN receiving: %v", err)
+	}
+
+	// An early dial sends the Initial packet without needing a server.
+	conn, err := DialAddrEarly(sink.LocalAddr().String(), nil,
+		&Config{Network: "udp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	oob := make([]byte, 128)
+	_, oobn, _, _, err := sink.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatalf("no packet from client: %v", err)
+	}
+	if got := parseECN(oob[:oobn]); got != ecnECT0 {
+		t.Errorf("outgoing packet ECN = %d, want ECT(0)", got)
+	}
+}
+
+func TestECNCodepointSurfaced(t *testing.T) {
+	recv, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close()
+	if err := enableECNReceiving(recv); err != nil {
+		t.Skipf("cannot enable ECN receiving: %v", err)
+	}
+
+	send, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer send.Close()
+
+	// Mark outgoing datagrams ECT(0) via the TOS byte.
+	raw, err := send.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, int(ecnECT0))
+	})
+	if sockErr != nil {
+		t.Skipf("cannot set TOS: %v", sockErr)
+	}
+
+	if _, err := send.WriteToUDP([]byte("ping"), recv.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+
+	recv.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	oob := make([]byte, 128)
+	_, oobn, _, _, err := recv.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseECN(oob[:oobn]); got != ecnECT0 {
+		t.Errorf("parseECN = %d, want ECT(0)", got)
+	}
+}
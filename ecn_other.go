@@ -0,0 +1,25 @@
+//go:build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+// enableECNReceiving is unsupported on this platform; the connection falls
+// back to treating all datagrams as Not-ECT.
+func enableECNReceiving(conn *net.UDPConn) error {
+	return errors.New("ECN receiving not supported on this platform")
+}
+
+// parseECN always reports Not-ECT on platforms without OOB support.
+func parseECN(oob []byte) uint8 {
+	return ecnNotECT
+}
+
+// ecnSendControlMessage is unsupported on this platform; outgoing datagrams
+// are sent unmarked.
+func ecnSendControlMessage(conn *net.UDPConn) []byte {
+	return nil
+}
@@ -0,0 +1,31 @@
+package quic
+
+import "testing"
+
+func TestParseECNEmptyOOB(t *testing.T) {
+	// Without control messages the codepoint must fall back to Not-ECT.
+	if got := parseECN(nil); got != ecnNotECT {
+		t.Errorf("parseECN(nil) = %d, want Not-ECT", got)
+	}
+}
+
+func TestTrackerECNCounts(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	tracker.OnPacketReceived(0)
+	tracker.OnECN(ecnECT0)
+	tracker.OnPacketReceived(1)
+	tracker.OnECN(ecnECT0)
+	tracker.OnPacketReceived(2)
+	tracker.OnECN(ecnCE)
+	tracker.OnPacketReceived(3)
+	tracker.OnECN(ecnNotECT)
+
+	frame := tracker.BuildAckFrame(0)
+	if frame == nil {
+		t.Fatal("no ACK frame built")
+	}
+	if frame.ECT0Count != 2 || frame.ECT1Count != 0 || frame.ECNCECount != 1 {
+		t.Errorf("ECN counts = %d/%d/%d, want 2/0/1",
+			frame.ECT0Count, frame.ECT1Count, frame.ECNCECount)
+	}
+}
@@ -0,0 +1,141 @@
+package quic
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// TransportError is the close reason when the peer terminates the connection
+// with a transport-level CONNECTION_CLOSE frame.
+type TransportError struct {
+	ErrorCode    packet.QUICErrorCode
+	FrameType    uint64
+	ReasonPhrase string
+}
+
+// Code returns the transport error code carried in the close frame.
+func (e *TransportError) Code() packet.QUICErrorCode {
+	return e.ErrorCode
+}
+
+func (e *TransportError) Error() string {
+	if e.ReasonPhrase == "" {
+		return e.ErrorCode.String()
+	}
+	return fmt.Sprintf("%s: %s", e.ErrorCode, e.ReasonPhrase)
+}
+
+// HandshakeCategory is the coarse classification of a handshake failure.
+type HandshakeCategory int
+
+const (
+	// HandshakeCertificate covers failures of the peer's certificate chain:
+	// verification errors, hostname mismatches, or a missing certificate.
+	HandshakeCertificate HandshakeCategory = iota
+	// HandshakeProtocol covers negotiation failures such as an ALPN
+	// mismatch or a malformed handshake message.
+	HandshakeProtocol
+	// HandshakeTimeout covers handshakes that did not finish in time.
+	HandshakeTimeout
+)
+
+func (c HandshakeCategory) String() string {
+	switch c {
+	case HandshakeCertificate:
+		return "certificate"
+	case HandshakeProtocol:
+		return "protocol"
+	case HandshakeTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// TLS alert codes a handshake failure maps to (RFC 8446 section 6).
+const (
+	alertHandshakeFailure      = 40
+	alertBadCertificate        = 42
+	alertNoApplicationProtocol = 120
+)
+
+// HandshakeError is returned when dialing fails during the handshake. It
+// carries the TLS alert the failure corresponds to and a coarse category, so
+// callers can distinguish certificate problems from protocol mismatches and
+// timeouts with errors.As.
+type HandshakeError struct {
+	// Alert is the TLS alert code describing the failure (RFC 8446
+	// section 6); zero for failures with no alert equivalent, such as a
+	// timeout.
+	Alert uint8
+	// Category classifies the failure.
+	Category HandshakeCategory
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake failed (%s): %v", e.Category, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// classifyHandshakeError wraps a handshake failure in a HandshakeError,
+// deriving the alert and category from the underlying error.
+func classifyHandshakeError(err error) *HandshakeError {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname),
+		errors.As(err, &invalid), strings.Contains(msg, "certificate"):
+		return &HandshakeError{Alert: alertBadCertificate, Category: HandshakeCertificate, Err: err}
+	case strings.Contains(msg, "no application protocol"):
+		return &HandshakeError{Alert: alertNoApplicationProtocol, Category: HandshakeProtocol, Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		strings.Contains(msg, "timeout"), strings.Contains(msg, "timed out"):
+		return &HandshakeError{Category: HandshakeTimeout, Err: err}
+	default:
+		return &HandshakeError{Alert: alertHandshakeFailure, Category: HandshakeProtocol, Err: err}
+	}
+}
+
+// StreamError is the error carried by a RESET_STREAM frame, either sent by
+// the peer or generated locally by CancelWrite.
+type StreamError struct {
+	StreamID  uint64
+	ErrorCode uint64
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("stream %d reset with error code %d", e.StreamID, e.ErrorCode)
+}
+
+// ApplicationError is the close reason when the peer terminates the
+// connection with an application-level CONNECTION_CLOSE frame. The meaning
+// of the code is defined by the application protocol.
+type ApplicationError struct {
+	ErrorCode    uint64
+	ReasonPhrase string
+}
+
+// Code returns the application-defined error code.
+func (e *ApplicationError) Code() uint64 {
+	return e.ErrorCode
+}
+
+func (e *ApplicationError) Error() string {
+	if e.ReasonPhrase == "" {
+		return fmt.Sprintf("application error 0x%x", e.ErrorCode)
+	}
+	return fmt.Sprintf("application error 0x%x: %s", e.ErrorCode, e.ReasonPhrase)
+}
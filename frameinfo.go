@@ -0,0 +1,122 @@
+package quic
+
+import "github.com/phuhao00/QUIC/packet"
+
+// FrameInfo describes one frame found in a packet's plaintext payload. Only
+// the fields that apply to the frame's type are set; for example StreamID is
+// zero for frames that do not name a stream.
+type FrameInfo struct {
+	// Type is the frame type as it appears on the wire (RFC 9000
+	// section 19).
+	Type uint64
+	// Name is the RFC 9000 name of the frame type, e.g. "STREAM".
+	Name string
+	// AckEliciting reports whether receiving the frame obliges the peer to
+	// acknowledge it (RFC 9002 section 2).
+	AckEliciting bool
+	// StreamID names the stream for STREAM, RESET_STREAM, STOP_SENDING,
+	// MAX_STREAM_DATA and STREAM_DATA_BLOCKED frames.
+	StreamID uint64
+	// Offset and DataLen describe the data carried by a STREAM or CRYPTO
+	// frame.
+	Offset  uint64
+	DataLen int
+	// Fin is set when a STREAM frame carries the FIN bit.
+	Fin bool
+	// LargestAcked is the largest packet number covered by an ACK frame.
+	LargestAcked uint64
+}
+
+// ParsePacketFrames parses the plaintext payload of a QUIC packet — the bytes
+// left after header protection and decryption — and describes each frame it
+// contains. It is a read-only inspection aid for test harnesses and proxies
+// and touches no connection state.
+func ParsePacketFrames(payload []byte) ([]FrameInfo, error) {
+	frames, err := packet.ParseFrames(payload)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]FrameInfo, 0, len(frames))
+	for _, f := range frames {
+		info := FrameInfo{
+			Type:         f.Type(),
+			Name:         frameName(f.Type()),
+			AckEliciting: f.IsAckEliciting(),
+		}
+		switch f := f.(type) {
+		case *packet.StreamFrame:
+			info.StreamID = f.StreamID
+			info.Offset = f.Offset
+			info.DataLen = len(f.Data)
+			info.Fin = f.Fin
+		case *packet.CryptoFrame:
+			info.Offset = f.Offset
+			info.DataLen = len(f.Data)
+		case *packet.AckFrame:
+			info.LargestAcked = f.LargestAcked
+		case *packet.ResetStreamFrame:
+			info.StreamID = f.StreamID
+		case *packet.StopSendingFrame:
+			info.StreamID = f.StreamID
+		case *packet.MaxStreamDataFrame:
+			info.StreamID = f.StreamID
+		case *packet.StreamDataBlockedFrame:
+			info.StreamID = f.StreamID
+		case *packet.StreamChecksumFrame:
+			info.StreamID = f.StreamID
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// frameName returns the RFC 9000 name for a frame type.
+func frameName(t uint64) string {
+	if t >= packet.FrameTypeStream && t <= packet.FrameTypeStreamMax {
+		return "STREAM"
+	}
+	switch t {
+	case packet.FrameTypePadding:
+		return "PADDING"
+	case packet.FrameTypePing:
+		return "PING"
+	case packet.FrameTypeAck, packet.FrameTypeAckECN:
+		return "ACK"
+	case packet.FrameTypeResetStream:
+		return "RESET_STREAM"
+	case packet.FrameTypeStopSending:
+		return "STOP_SENDING"
+	case packet.FrameTypeCrypto:
+		return "CRYPTO"
+	case packet.FrameTypeNewToken:
+		return "NEW_TOKEN"
+	case packet.FrameTypeMaxData:
+		return "MAX_DATA"
+	case packet.FrameTypeMaxStreamData:
+		return "MAX_STREAM_DATA"
+	case packet.FrameTypeMaxStreamsBidi, packet.FrameTypeMaxStreamsUni:
+		return "MAX_STREAMS"
+	case packet.FrameTypeDataBlocked:
+		return "DATA_BLOCKED"
+	case packet.FrameTypeStreamDataBlocked:
+		return "STREAM_DATA_BLOCKED"
+	case packet.FrameTypeStreamsBlockedBidi, packet.FrameTypeStreamsBlockedUni:
+		return "STREAMS_BLOCKED"
+	case packet.FrameTypeNewConnectionID:
+		return "NEW_CONNECTION_ID"
+	case packet.FrameTypeRetireConnectionID:
+		return "RETIRE_CONNECTION_ID"
+	case packet.FrameTypePathChallenge:
+		return "PATH_CHALLENGE"
+	case packet.FrameTypePathResponse:
+		return "PATH_RESPONSE"
+	case packet.FrameTypeConnectionClose, packet.FrameTypeConnectionCloseApp:
+		return "CONNECTION_CLOSE"
+	case packet.FrameTypeHandshakeDone:
+		return "HANDSHAKE_DONE"
+	case packet.FrameTypeStreamChecksum:
+		return "STREAM_CHECKSUM"
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,44 @@
+package quic
+
+import (
+	"testing"
+
+	"github.com/phuhao00/QUIC/packet"
+)
+
+func TestParsePacketFramesDescribesMultiFramePacket(t *testing.T) {
+	w := packet.NewFrameWriter(1200)
+	frames := []packet.Frame{
+		&packet.PingFrame{},
+		&packet.AckFrame{LargestAcked: 17, FirstAckRange: 3},
+		&packet.StreamFrame{StreamID: 4, Offset: 100, Data: []byte("hello"), Fin: true},
+		&packet.MaxStreamDataFrame{StreamID: 4, MaximumData: 1 << 20},
+	}
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := ParsePacketFrames(w.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != len(frames) {
+		t.Fatalf("got %d frames, want %d", len(infos), len(frames))
+	}
+
+	if infos[0].Name != "PING" || !infos[0].AckEliciting {
+		t.Errorf("frame 0 = %+v, want ack-eliciting PING", infos[0])
+	}
+	if infos[1].Name != "ACK" || infos[1].LargestAcked != 17 || infos[1].AckEliciting {
+		t.Errorf("frame 1 = %+v, want non-eliciting ACK of 17", infos[1])
+	}
+	s := infos[2]
+	if s.Name != "STREAM" || s.StreamID != 4 || s.Offset != 100 || s.DataLen != 5 || !s.Fin {
+		t.Errorf("frame 2 = %+v, want STREAM 4 at offset 100 with 5 bytes and FIN", s)
+	}
+	if infos[3].Name != "MAX_STREAM_DATA" || infos[3].StreamID != 4 {
+		t.Errorf("frame 3 = %+v, want MAX_STREAM_DATA for stream 4", infos[3])
+	}
+}
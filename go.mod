@@ -0,0 +1,3 @@
+module github.com/phuhao00/QUIC
+
+go 1.21
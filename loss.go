@@ -0,0 +1,193 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/phuhao00/QUIC/packet"
+)
+
+const (
+	// ptoGranularity is the timer granularity floor of the PTO computation
+	// (kGranularity in RFC 9002).
+	ptoGranularity = time.Millisecond
+	// initialRTT seeds the PTO before the first RTT sample (RFC 9002
+	// section 6.2.2).
+	initialRTT = 333 * time.Millisecond
+	// maxPTOProbes is how many probe packets one expiry may send.
+	maxPTOProbes = 2
+	// maxPTOBackoff caps the exponential backoff so a long outage cannot
+	// push the timer past the idle timeout's usefulness.
+	maxPTOBackoff = 6
+)
+
+// currentPTO computes the probe timeout for the connection's present RTT
+// estimate: smoothed_rtt + max(4*rttvar, granularity) + max_ack_delay,
+// doubled for every consecutive expiry since the last ACK.
+func (c *Connection) currentPTO() time.Duration {
+	srtt := c.rttStats.SmoothedRTT()
+	if srtt == 0 {
+		srtt = initialRTT
+	}
+	v := 4 * c.rttStats.RTTVariation()
+	if v < ptoGranularity {
+		v = ptoGranularity
+	}
+	pto := srtt + v + c.peerMaxAckDelay()
+
+	c.ptoMutex.Lock()
+	backoff := c.ptoCount
+	c.ptoMutex.Unlock()
+	if backoff > maxPTOBackoff {
+		backoff = maxPTOBackoff
+	}
+	return pto << backoff
+}
+
+// peerMaxAckDelay is how long the peer may sit on an ACK, from its transport
+// parameters or this endpoint's own default before they arrive.
+func (c *Connection) peerMaxAckDelay() time.Duration {
+	if params, ok := c.tlsManager.PeerTransportParameters(); ok && params.MaxAckDelay > 0 {
+		return time.Duration(params.MaxAckDelay) * time.Millisecond
+	}
+	return defaultMaxAckDelay
+}
+
+// armPTOTimer (re)starts the probe timer for the most recent ack-eliciting
+// packet.
+func (c *Connection) armPTOTimer() {
+	d := c.currentPTO()
+	c.ptoMutex.Lock()
+	if c.ptoTimer == nil {
+		c.ptoTimer = c.clock.AfterFunc(d, c.onPTOExpiry)
+	} else {
+		c.ptoTimer.Reset(d)
+	}
+	c.ptoMutex.Unlock()
+}
+
+// stopPTOTimer halts the probe timer once nothing ack-eliciting is in flight.
+func (c *Connection) stopPTOTimer() {
+	c.ptoMutex.Lock()
+	if c.ptoTimer != nil {
+		c.ptoTimer.Stop()
+	}
+	c.ptoCount = 0
+	c.ptoMutex.Unlock()
+}
+
+// onAckReceived resets the PTO backoff after a new acknowledgment and keeps
+// the timer running only while packets remain in flight.
+func (c *Connection) onAckReceived() {
+	c.ptoMutex.Lock()
+	c.ptoCount = 0
+	c.ptoMutex.Unlock()
+	if c.hasPacketsInFlight() {
+		c.armPTOTimer()
+	} else {
+		c.stopPTOTimer()
+	}
+}
+
+// hasPacketsInFlight reports whether any ack-eliciting packet awaits
+// acknowledgment in any packet number space.
+func (c *Connection) hasPacketsInFlight() bool {
+	c.sentMutex.Lock()
+	defer c.sentMutex.Unlock()
+	for space := 0; space < numPNSpaces; space++ {
+		if len(c.sentPackets[space]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// packetReorderingThreshold is how many packet numbers past an unacked
+// packet an acknowledgment may reach before the packet is declared lost
+// (kPacketThreshold in RFC 9002).
+const packetReorderingThreshold = 3
+
+// detectLostPackets declares still-unacked packets below the largest
+// acknowledged lost, either because the packet-number gap exceeds the
+// reordering threshold or because they have been in flight longer than
+// 9/8 of the current RTT. Losses feed the congestion controller, and the
+// stream data the lost packets carried is requeued for retransmission.
+func (c *Connection) detectLostPackets(space int, largestAcked uint64, latestRTT time.Duration) {
+	maxRTT := c.rttStats.SmoothedRTT()
+	if latestRTT > maxRTT {
+		maxRTT = latestRTT
+	}
+	timeThreshold := maxRTT * 9 / 8
+	now := c.clock.Now()
+
+	var lost []sentPacketInfo
+	c.sentMutex.Lock()
+	for pn, info := range c.sentPackets[space] {
+		if pn >= largestAcked {
+			continue
+		}
+		if largestAcked-pn >= packetReorderingThreshold ||
+			(timeThreshold > 0 && now.Sub(info.sentTime) > timeThreshold) {
+			lost = append(lost, info)
+			delete(c.sentPackets[space], pn)
+		}
+	}
+	c.sentMutex.Unlock()
+
+	for _, p := range lost {
+		c.congestion.OnPacketLost(uint64(p.size))
+		c.forStreamRanges(p.streamFrames, (*Stream).OnLoss)
+		for _, ref := range p.cryptoFrames {
+			c.tlsManager.RetransmitRange(ref.level, ref.offset, ref.length)
+		}
+	}
+}
+
+// onPTOExpiry fires when an ack-eliciting packet has gone unacknowledged for
+// a full probe timeout. It retransmits the oldest unacked data as probes —
+// or a PING when there is nothing to carry — and doubles the timeout for the
+// next expiry.
+func (c *Connection) onPTOExpiry() {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	if !c.hasPacketsInFlight() {
+		c.stopPTOTimer()
+		return
+	}
+
+	c.ptoMutex.Lock()
+	c.ptoCount++
+	c.ptoMutex.Unlock()
+
+	// Handshake data is retransmitted through the crypto stream's own
+	// staleness check; forcing it makes the next send loop pass re-offer
+	// everything outstanding.
+	c.tlsManager.RetransmitStale(0)
+
+	c.streamsMutex.RLock()
+	streams := make([]*streamWrapper, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.streamsMutex.RUnlock()
+
+	probes := 0
+	for _, s := range streams {
+		if probes >= maxPTOProbes {
+			break
+		}
+		if s.RequeueOldestUnacked() {
+			probes++
+		}
+	}
+	if probes > 0 {
+		c.signalSend()
+	} else {
+		// Nothing to retransmit: a PING still elicits an ACK that lets
+		// loss detection and RTT measurement make progress.
+		c.SendPacket([]packet.Frame{&packet.PingFrame{}})
+	}
+	c.armPTOTimer()
+}
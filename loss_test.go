@@ -0,0 +1,196 @@
+package quic
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/QUIC/crypto"
+	"github.com/phuhao00/QUIC/packet"
+)
+
+func TestCurrentPTOBackoff(t *testing.T) {
+	conn := newTestConnection(t, true)
+
+	// Before any RTT sample the PTO rests on the initial RTT estimate.
+	base := conn.currentPTO()
+	if base < initialRTT {
+		t.Fatalf("currentPTO = %v, want at least %v", base, initialRTT)
+	}
+
+	// Each consecutive expiry doubles the timeout.
+	conn.ptoMutex.Lock()
+	conn.ptoCount = 2
+	conn.ptoMutex.Unlock()
+	if got := conn.currentPTO(); got != base<<2 {
+		t.Errorf("currentPTO after two expiries = %v, want %v", got, base<<2)
+	}
+
+	// An acknowledgment resets the backoff.
+	conn.onAckReceived()
+	if got := conn.currentPTO(); got != base {
+		t.Errorf("currentPTO after ACK = %v, want %v", got, base)
+	}
+}
+
+func TestPTORetransmitsOldestUnacked(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := newConnection(udpConn, sink.LocalAddr().(*net.UDPAddr), true, false,
+		populateConfig(nil), &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("probe me")); err != nil {
+		t.Fatal(err)
+	}
+	conn.sendStreamData()
+
+	readDatagram := func() int {
+		t.Helper()
+		buf := make([]byte, 2048)
+		sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, _, err := sink.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("reading datagram: %v", err)
+		}
+		return n
+	}
+	first := readDatagram()
+
+	// The datagram is never acknowledged; an expiring PTO must re-offer
+	// its payload.
+	conn.onPTOExpiry()
+	conn.ptoMutex.Lock()
+	count := conn.ptoCount
+	conn.ptoMutex.Unlock()
+	if count != 1 {
+		t.Errorf("ptoCount after expiry = %d, want 1", count)
+	}
+	if !s.HasSendData() {
+		t.Fatal("PTO did not requeue the unacked range")
+	}
+	conn.sendStreamData()
+	if second := readDatagram(); second != first {
+		t.Errorf("retransmission is %d bytes, original was %d", second, first)
+	}
+}
+
+func TestPTOSendsPingWithoutRetransmittableData(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	connID, err := generateConnectionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newMockClock()
+	conn, err := newConnection(udpConn, sink.LocalAddr().(*net.UDPAddr), true, false,
+		populateConfig(&Config{Clock: clock}), &tls.Config{}, connID, connID, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// An unacknowledged PING has no data to retransmit, so the probe is
+	// another PING.
+	if err := conn.SendPacket([]packet.Frame{&packet.PingFrame{}}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := sink.ReadFromUDP(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crossing the probe timeout on the injected clock fires the real timer
+	// path, no sleeping required.
+	clock.Advance(conn.currentPTO() + time.Millisecond)
+	conn.ptoMutex.Lock()
+	count := conn.ptoCount
+	conn.ptoMutex.Unlock()
+	if count != 1 {
+		t.Errorf("ptoCount after advancing past the PTO = %d, want 1", count)
+	}
+	sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := sink.ReadFromUDP(buf); err != nil {
+		t.Fatal("no probe packet after PTO expiry")
+	}
+}
+
+func TestGapThresholdDeclaresLoss(t *testing.T) {
+	conn := newTestConnection(t, true)
+	space := pnSpaceForLevel(crypto.LevelApplication)
+
+	// Five ack-eliciting packets in flight, 100 bytes each. Packets 3 and 4
+	// get a send time safely inside the time threshold so only the
+	// packet-number gap can condemn anything here.
+	now := time.Now()
+	conn.sentMutex.Lock()
+	for pn := uint64(1); pn <= 5; pn++ {
+		sentTime := now
+		if pn == 3 || pn == 4 {
+			sentTime = now.Add(time.Second)
+		}
+		conn.sentPackets[space][pn] = sentPacketInfo{sentTime: sentTime, size: 100}
+	}
+	conn.sentMutex.Unlock()
+	for i := 0; i < 5; i++ {
+		conn.congestion.OnPacketSent(100)
+	}
+
+	// Acking packet 5 alone puts packets 1 and 2 past the reordering
+	// threshold; 3 and 4 are still merely reordered.
+	conn.handleAckFrame(&packet.AckFrame{LargestAcked: 5}, crypto.LevelApplication)
+
+	conn.sentMutex.Lock()
+	_, has1 := conn.sentPackets[space][1]
+	_, has2 := conn.sentPackets[space][2]
+	_, has3 := conn.sentPackets[space][3]
+	_, has4 := conn.sentPackets[space][4]
+	_, has5 := conn.sentPackets[space][5]
+	conn.sentMutex.Unlock()
+	if has1 || has2 {
+		t.Errorf("packets 1 and 2 still tracked (%v, %v), want declared lost", has1, has2)
+	}
+	if !has3 || !has4 {
+		t.Errorf("packets 3 and 4 tracked = %v, %v, want still in flight", has3, has4)
+	}
+	if has5 {
+		t.Error("packet 5 still tracked after being acknowledged")
+	}
+
+	stats := conn.GetStats()
+	if !stats.Recovery {
+		t.Error("congestion controller not in recovery after detected loss")
+	}
+	if stats.BytesInFlight != 200 {
+		t.Errorf("BytesInFlight = %d, want 200 for the two outstanding packets", stats.BytesInFlight)
+	}
+}
@@ -0,0 +1,54 @@
+package quic
+
+import (
+	"sync"
+	"time"
+)
+
+// sendRateLimiter is a token bucket capping the connection's average send
+// rate independently of congestion control. The budget may go slightly
+// negative because packets are debited whole, which keeps the long-run
+// average under the configured rate.
+type sendRateLimiter struct {
+	mutex  sync.Mutex
+	clock  Clock
+	rate   float64 // bytes per second
+	budget float64
+	max    float64 // burst allowance
+	last   time.Time
+}
+
+func newSendRateLimiter(rate uint64, clock Clock) *sendRateLimiter {
+	return &sendRateLimiter{
+		clock: clock,
+		rate:  float64(rate),
+		max:   float64(rate) / 10,
+		last:  clock.Now(),
+	}
+}
+
+// refillLocked credits the budget for the time elapsed since the last call.
+func (l *sendRateLimiter) refillLocked() {
+	now := l.clock.Now()
+	l.budget += l.rate * now.Sub(l.last).Seconds()
+	if l.budget > l.max {
+		l.budget = l.max
+	}
+	l.last = now
+}
+
+// hasCredit reports whether another packet may be sent now.
+func (l *sendRateLimiter) hasCredit() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refillLocked()
+	return l.budget > 0
+}
+
+// spend debits n bytes from the budget.
+func (l *sendRateLimiter) spend(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refillLocked()
+	l.budget -= float64(n)
+}
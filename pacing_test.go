@@ -0,0 +1,79 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestMaxSendRateCapsThroughput(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		n := 0
+		buf := make([]byte, 16*1024)
+		for n < 60*1024 {
+			m, err := s.Read(buf)
+			n += m
+			if err != nil {
+				break
+			}
+		}
+		received <- n
+	}()
+
+	const rate = 30 * 1024 // bytes per second
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, &Config{MaxSendRate: rate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const total = 60 * 1024
+	start := time.Now()
+	if _, err := s.Write(make([]byte, total)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	// 60KB at 30KB/s must take on the order of two seconds; anything much
+	// faster means the cap was not applied.
+	if elapsed < 1500*time.Millisecond {
+		t.Errorf("60KB drained in %v despite a 30KB/s cap", elapsed)
+	}
+
+	select {
+	case n := <-received:
+		if n != total {
+			t.Fatalf("server received %d bytes, want %d", n, total)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("transfer did not complete")
+	}
+
+	if sent := conn.GetStats().SendRate; sent > 2*rate {
+		t.Errorf("observed send rate %d B/s, want roughly %d", sent, rate)
+	}
+}
@@ -0,0 +1,68 @@
+package packet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sealer is the packet protection surface Builder and Unprotect need: AEAD
+// sealing keyed by packet number with the serialized header as additional
+// data. It is satisfied by crypto.CryptoState.
+type Sealer interface {
+	Seal(packetNumber uint64, payload, header []byte) []byte
+	Open(packetNumber uint64, ciphertext, header []byte) ([]byte, error)
+	Overhead() int
+}
+
+// Builder assembles fully protected datagrams, keeping the header
+// serialization, frame packing and AEAD sealing in one place.
+type Builder struct {
+	// Sealer protects the payload.
+	Sealer Sealer
+	// MaxSize caps the size of the built datagram; zero means unbounded.
+	MaxSize int
+}
+
+// Build serializes the header, packs the frames with space accounting and
+// seals the payload, returning a datagram ready to send. A frame that does
+// not fit within MaxSize surfaces as ErrFrameTooLarge.
+func (b *Builder) Build(header *Header, frames []Frame) ([]byte, error) {
+	headerBytes, err := header.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("serializing header: %w", err)
+	}
+	limit := int(^uint(0) >> 1)
+	if b.MaxSize > 0 {
+		limit = b.MaxSize - len(headerBytes) - b.Sealer.Overhead()
+	}
+	writer := NewFrameWriter(limit)
+	for _, frame := range frames {
+		if err := writer.WriteFrame(frame); err != nil {
+			return nil, fmt.Errorf("packing frame: %w", err)
+		}
+	}
+	sealed := b.Sealer.Seal(header.PacketNumber, writer.Bytes(), headerBytes)
+	return append(headerBytes, sealed...), nil
+}
+
+// Unprotect reverses Build: it parses the header, opens the sealed payload
+// and parses the contained frames, tolerating up to maxFrames of them (zero
+// or less means DefaultMaxFramesPerPacket). Like ParseHeader it returns the
+// header together with ErrFixedBitZero or ErrReservedBitsSet when those are
+// the only problems, leaving the judgment to the caller; any other error
+// means the datagram could not be deciphered.
+func Unprotect(sealer Sealer, data []byte, connIDLen, maxFrames int) (*Header, []Frame, error) {
+	header, headerLen, hdrErr := ParseHeader(data, connIDLen)
+	if hdrErr != nil && !errors.Is(hdrErr, ErrFixedBitZero) && !errors.Is(hdrErr, ErrReservedBitsSet) {
+		return nil, nil, hdrErr
+	}
+	payload, err := sealer.Open(header.PacketNumber, data[headerLen:], data[:headerLen])
+	if err != nil {
+		return header, nil, err
+	}
+	frames, err := ParseFramesLimit(payload, maxFrames)
+	if err != nil {
+		return header, nil, err
+	}
+	return header, frames, hdrErr
+}
@@ -0,0 +1,90 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/phuhao00/QUIC/crypto"
+)
+
+func newTestSealer(t *testing.T) *crypto.CryptoState {
+	t.Helper()
+	state, err := crypto.NewCryptoState([]byte{1, 2, 3, 4, 5, 6, 7, 8}, crypto.LevelApplication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestBuilderRoundTrip(t *testing.T) {
+	sealer := newTestSealer(t)
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	header := &Header{
+		Type:         PacketType1RTT,
+		DestConnID:   connID,
+		PacketNumber: 42,
+	}
+	frames := []Frame{
+		&PingFrame{},
+		&StreamFrame{StreamID: 4, Data: []byte("hello"), Fin: true},
+	}
+
+	builder := &Builder{Sealer: sealer, MaxSize: 1200}
+	datagram, err := builder.Build(header, frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotHeader, gotFrames, err := Unprotect(sealer, datagram, len(connID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotHeader.PacketNumber != 42 {
+		t.Errorf("PacketNumber = %d, want 42", gotHeader.PacketNumber)
+	}
+	if !bytes.Equal(gotHeader.DestConnID, connID) {
+		t.Errorf("DestConnID = %x, want %x", gotHeader.DestConnID, connID)
+	}
+	if len(gotFrames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(gotFrames))
+	}
+	if _, ok := gotFrames[0].(*PingFrame); !ok {
+		t.Errorf("frame 0 is %T, want *PingFrame", gotFrames[0])
+	}
+	stream, ok := gotFrames[1].(*StreamFrame)
+	if !ok {
+		t.Fatalf("frame 1 is %T, want *StreamFrame", gotFrames[1])
+	}
+	if stream.StreamID != 4 || !stream.Fin || !bytes.Equal(stream.Data, []byte("hello")) {
+		t.Errorf("stream frame = %+v, want stream 4, fin, data %q", stream, "hello")
+	}
+}
+
+func TestUnprotectRejectsTamperedDatagram(t *testing.T) {
+	sealer := newTestSealer(t)
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	header := &Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 7}
+
+	builder := &Builder{Sealer: sealer}
+	datagram, err := builder.Build(header, []Frame{&PingFrame{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagram[len(datagram)-1] ^= 0xff
+	if _, _, err := Unprotect(sealer, datagram, len(connID), 0); err == nil {
+		t.Fatal("Unprotect accepted a tampered datagram")
+	}
+}
+
+func TestBuilderRejectsFrameBeyondMaxSize(t *testing.T) {
+	sealer := newTestSealer(t)
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	header := &Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 1}
+	frame := &StreamFrame{StreamID: 4, Data: make([]byte, 200)}
+
+	builder := &Builder{Sealer: sealer, MaxSize: 100}
+	if _, err := builder.Build(header, []Frame{frame}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("Build = %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -0,0 +1,101 @@
+package packet
+
+import "fmt"
+
+// QUICErrorCode is a QUIC transport error code as carried in a
+// transport-level CONNECTION_CLOSE frame.
+type QUICErrorCode uint64
+
+// Transport error codes from RFC 9000, section 20.1. They appear in
+// transport-level CONNECTION_CLOSE frames.
+const (
+	NoError                 = 0x00
+	InternalError           = 0x01
+	ConnectionRefused       = 0x02
+	FlowControlError        = 0x03
+	StreamLimitError        = 0x04
+	StreamStateError        = 0x05
+	FinalSizeError          = 0x06
+	FrameEncodingError      = 0x07
+	TransportParameterError = 0x08
+	ConnectionIDLimitError  = 0x09
+	ProtocolViolation       = 0x0a
+	InvalidToken            = 0x0b
+	ApplicationError        = 0x0c
+	CryptoBufferExceeded    = 0x0d
+	KeyUpdateError          = 0x0e
+	AEADLimitReached        = 0x0f
+	NoViablePath            = 0x10
+
+	// CryptoError is the base of the range 0x0100-0x01ff reserved for TLS
+	// alerts raised during the handshake.
+	CryptoError = 0x0100
+)
+
+var errorCodeNames = map[uint64]string{
+	NoError:                 "NO_ERROR",
+	InternalError:           "INTERNAL_ERROR",
+	ConnectionRefused:       "CONNECTION_REFUSED",
+	FlowControlError:        "FLOW_CONTROL_ERROR",
+	StreamLimitError:        "STREAM_LIMIT_ERROR",
+	StreamStateError:        "STREAM_STATE_ERROR",
+	FinalSizeError:          "FINAL_SIZE_ERROR",
+	FrameEncodingError:      "FRAME_ENCODING_ERROR",
+	TransportParameterError: "TRANSPORT_PARAMETER_ERROR",
+	ConnectionIDLimitError:  "CONNECTION_ID_LIMIT_ERROR",
+	ProtocolViolation:       "PROTOCOL_VIOLATION",
+	InvalidToken:            "INVALID_TOKEN",
+	ApplicationError:        "APPLICATION_ERROR",
+	CryptoBufferExceeded:    "CRYPTO_BUFFER_EXCEEDED",
+	KeyUpdateError:          "KEY_UPDATE_ERROR",
+	AEADLimitReached:        "AEAD_LIMIT_REACHED",
+	NoViablePath:            "NO_VIABLE_PATH",
+}
+
+// tlsAlertNames covers the TLS alerts most likely to surface during a QUIC
+// handshake (RFC 8446, section 6).
+var tlsAlertNames = map[uint8]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	40:  "handshake_failure",
+	42:  "bad_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	90:  "user_canceled",
+	109: "missing_extension",
+	110: "unsupported_extension",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+// ErrorCodeName returns the RFC 9000 name of a transport error code, or the
+// empty string if the code is not registered.
+func ErrorCodeName(code uint64) string {
+	return errorCodeNames[code]
+}
+
+// String renders the error code by its RFC 9000 name. Codes in the
+// CRYPTO_ERROR range decode the embedded TLS alert.
+func (e QUICErrorCode) String() string {
+	if name := errorCodeNames[uint64(e)]; name != "" {
+		return name
+	}
+	if e >= CryptoError && e <= CryptoError+0xff {
+		alert := uint8(e - CryptoError)
+		if name, ok := tlsAlertNames[alert]; ok {
+			return fmt.Sprintf("CRYPTO_ERROR (%s)", name)
+		}
+		return fmt.Sprintf("CRYPTO_ERROR (alert %d)", alert)
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint64(e))
+}
@@ -0,0 +1,54 @@
+package packet
+
+import "testing"
+
+func TestErrorCodeName(t *testing.T) {
+	tests := []struct {
+		code uint64
+		name string
+	}{
+		{NoError, "NO_ERROR"},
+		{InternalError, "INTERNAL_ERROR"},
+		{ConnectionRefused, "CONNECTION_REFUSED"},
+		{FlowControlError, "FLOW_CONTROL_ERROR"},
+		{StreamLimitError, "STREAM_LIMIT_ERROR"},
+		{StreamStateError, "STREAM_STATE_ERROR"},
+		{FinalSizeError, "FINAL_SIZE_ERROR"},
+		{FrameEncodingError, "FRAME_ENCODING_ERROR"},
+		{TransportParameterError, "TRANSPORT_PARAMETER_ERROR"},
+		{ConnectionIDLimitError, "CONNECTION_ID_LIMIT_ERROR"},
+		{ProtocolViolation, "PROTOCOL_VIOLATION"},
+		{InvalidToken, "INVALID_TOKEN"},
+		{ApplicationError, "APPLICATION_ERROR"},
+		{CryptoBufferExceeded, "CRYPTO_BUFFER_EXCEEDED"},
+		{KeyUpdateError, "KEY_UPDATE_ERROR"},
+		{AEADLimitReached, "AEAD_LIMIT_REACHED"},
+		{NoViablePath, "NO_VIABLE_PATH"},
+	}
+	for _, tt := range tests {
+		if got := ErrorCodeName(tt.code); got != tt.name {
+			t.Errorf("ErrorCodeName(%#x) = %q, want %q", tt.code, got, tt.name)
+		}
+	}
+	if got := ErrorCodeName(0x1f); got != "" {
+		t.Errorf("ErrorCodeName(unregistered) = %q, want empty", got)
+	}
+}
+
+func TestQUICErrorCodeString(t *testing.T) {
+	tests := []struct {
+		code QUICErrorCode
+		want string
+	}{
+		{FlowControlError, "FLOW_CONTROL_ERROR"},
+		{CryptoError + 40, "CRYPTO_ERROR (handshake_failure)"},
+		{CryptoError + 120, "CRYPTO_ERROR (no_application_protocol)"},
+		{CryptoError + 17, "CRYPTO_ERROR (alert 17)"},
+		{0x1f, "unknown error code 0x1f"},
+	}
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.want {
+			t.Errorf("(%#x).String() = %q, want %q", uint64(tt.code), got, tt.want)
+		}
+	}
+}
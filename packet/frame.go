@@ -0,0 +1,946 @@
+package packet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Frame type identifiers from RFC 9000 section 19.
+const (
+	FrameTypePadding            = 0x00
+	FrameTypePing               = 0x01
+	FrameTypeAck                = 0x02
+	FrameTypeAckECN             = 0x03
+	FrameTypeResetStream        = 0x04
+	FrameTypeStopSending        = 0x05
+	FrameTypeCrypto             = 0x06
+	FrameTypeNewToken           = 0x07
+	FrameTypeStream             = 0x08 // 0x08 - 0x0f depending on OFF/LEN/FIN bits
+	FrameTypeStreamMax          = 0x0f
+	FrameTypeMaxData            = 0x10
+	FrameTypeMaxStreamData      = 0x11
+	FrameTypeMaxStreamsBidi     = 0x12
+	FrameTypeMaxStreamsUni      = 0x13
+	FrameTypeDataBlocked        = 0x14
+	FrameTypeStreamDataBlocked  = 0x15
+	FrameTypeStreamsBlockedBidi = 0x16
+	FrameTypeStreamsBlockedUni  = 0x17
+	FrameTypeNewConnectionID    = 0x18
+	FrameTypeRetireConnectionID = 0x19
+	FrameTypePathChallenge      = 0x1a
+	FrameTypePathResponse       = 0x1b
+	FrameTypeConnectionClose    = 0x1c
+	FrameTypeConnectionCloseApp = 0x1d
+	FrameTypeHandshakeDone      = 0x1e
+	// FrameTypeStreamChecksum is a private extension frame carrying a
+	// debug checksum over a stream's data; see StreamChecksumFrame.
+	FrameTypeStreamChecksum = 0x3a5a
+)
+
+// Frame is the interface implemented by all QUIC frames.
+type Frame interface {
+	// Type returns the frame type identifier as it appears on the wire.
+	Type() uint64
+	// Length returns the serialized size of the frame in bytes.
+	Length() int
+	// Serialize returns the wire encoding of the frame.
+	Serialize() ([]byte, error)
+	// IsAckEliciting reports whether the frame elicits an acknowledgment
+	// from the receiver (RFC 9002 section 2).
+	IsAckEliciting() bool
+}
+
+// PaddingFrame is a single PADDING byte.
+type PaddingFrame struct{}
+
+func (f *PaddingFrame) Type() uint64         { return FrameTypePadding }
+func (f *PaddingFrame) IsAckEliciting() bool { return false }
+func (f *PaddingFrame) Length() int          { return 1 }
+func (f *PaddingFrame) Serialize() ([]byte, error) {
+	return []byte{FrameTypePadding}, nil
+}
+
+// PingFrame elicits an acknowledgment from the peer.
+type PingFrame struct{}
+
+func (f *PingFrame) Type() uint64         { return FrameTypePing }
+func (f *PingFrame) IsAckEliciting() bool { return true }
+func (f *PingFrame) Length() int          { return 1 }
+func (f *PingFrame) Serialize() ([]byte, error) {
+	return []byte{FrameTypePing}, nil
+}
+
+// AckRange describes one additional range of acknowledged packets, as a gap
+// from the previous range and the length of the range.
+type AckRange struct {
+	Gap    uint64
+	Length uint64
+}
+
+// AckFrame acknowledges received packets.
+type AckFrame struct {
+	LargestAcked  uint64
+	AckDelay      uint64
+	FirstAckRange uint64
+	AckRanges     []AckRange
+	// HasECN marks the frame as ACK_ECN even when every count is zero, so
+	// a parsed ACK_ECN re-serializes as one. Nonzero counts imply it.
+	HasECN     bool
+	ECT0Count  uint64
+	ECT1Count  uint64
+	ECNCECount uint64
+}
+
+func (f *AckFrame) Type() uint64 {
+	if f.HasECN || f.ECT0Count > 0 || f.ECT1Count > 0 || f.ECNCECount > 0 {
+		return FrameTypeAckECN
+	}
+	return FrameTypeAck
+}
+
+func (f *AckFrame) IsAckEliciting() bool { return false }
+
+func (f *AckFrame) Length() int {
+	l := 1 + varintLen(f.LargestAcked) + varintLen(f.AckDelay) +
+		varintLen(uint64(len(f.AckRanges))) + varintLen(f.FirstAckRange)
+	for _, r := range f.AckRanges {
+		l += varintLen(r.Gap) + varintLen(r.Length)
+	}
+	if f.Type() == FrameTypeAckECN {
+		l += varintLen(f.ECT0Count) + varintLen(f.ECT1Count) + varintLen(f.ECNCECount)
+	}
+	return l
+}
+
+func (f *AckFrame) Serialize() ([]byte, error) {
+	buf := make([]byte, f.Length()+8)
+	pos := 0
+	n, err := putVarint(buf[pos:], f.Type())
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	for _, v := range []uint64{f.LargestAcked, f.AckDelay, uint64(len(f.AckRanges)), f.FirstAckRange} {
+		n, err = putVarint(buf[pos:], v)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	for _, r := range f.AckRanges {
+		for _, v := range []uint64{r.Gap, r.Length} {
+			n, err = putVarint(buf[pos:], v)
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+		}
+	}
+	if f.Type() == FrameTypeAckECN {
+		for _, v := range []uint64{f.ECT0Count, f.ECT1Count, f.ECNCECount} {
+			n, err = putVarint(buf[pos:], v)
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+		}
+	}
+	return buf[:pos], nil
+}
+
+// ResetStreamFrame abruptly terminates the sending part of a stream.
+type ResetStreamFrame struct {
+	StreamID  uint64
+	ErrorCode uint64
+	FinalSize uint64
+}
+
+func (f *ResetStreamFrame) Type() uint64         { return FrameTypeResetStream }
+func (f *ResetStreamFrame) IsAckEliciting() bool { return true }
+func (f *ResetStreamFrame) Length() int {
+	return 1 + varintLen(f.StreamID) + varintLen(f.ErrorCode) + varintLen(f.FinalSize)
+}
+func (f *ResetStreamFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeResetStream, f.StreamID, f.ErrorCode, f.FinalSize)
+}
+
+// StopSendingFrame asks the peer to stop sending on a stream.
+type StopSendingFrame struct {
+	StreamID  uint64
+	ErrorCode uint64
+}
+
+func (f *StopSendingFrame) Type() uint64         { return FrameTypeStopSending }
+func (f *StopSendingFrame) IsAckEliciting() bool { return true }
+func (f *StopSendingFrame) Length() int {
+	return 1 + varintLen(f.StreamID) + varintLen(f.ErrorCode)
+}
+func (f *StopSendingFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeStopSending, f.StreamID, f.ErrorCode)
+}
+
+// CryptoFrame carries TLS handshake data.
+type CryptoFrame struct {
+	Offset uint64
+	Data   []byte
+}
+
+func (f *CryptoFrame) Type() uint64         { return FrameTypeCrypto }
+func (f *CryptoFrame) IsAckEliciting() bool { return true }
+func (f *CryptoFrame) Length() int {
+	return 1 + varintLen(f.Offset) + varintLen(uint64(len(f.Data))) + len(f.Data)
+}
+func (f *CryptoFrame) Serialize() ([]byte, error) {
+	buf, err := serializeVarints(FrameTypeCrypto, f.Offset, uint64(len(f.Data)))
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, f.Data...), nil
+}
+
+// NewTokenFrame delivers an address validation token for future connections.
+type NewTokenFrame struct {
+	Token []byte
+}
+
+func (f *NewTokenFrame) Type() uint64         { return FrameTypeNewToken }
+func (f *NewTokenFrame) IsAckEliciting() bool { return true }
+func (f *NewTokenFrame) Length() int {
+	return 1 + varintLen(uint64(len(f.Token))) + len(f.Token)
+}
+func (f *NewTokenFrame) Serialize() ([]byte, error) {
+	buf, err := serializeVarints(FrameTypeNewToken, uint64(len(f.Token)))
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, f.Token...), nil
+}
+
+// StreamFrame carries application data for a stream.
+type StreamFrame struct {
+	StreamID uint64
+	Offset   uint64
+	Data     []byte
+	Fin      bool
+	// OmitLength drops the length field, so the data runs to the end of
+	// the packet (LEN bit clear, RFC 9000 section 19.8). That saves the
+	// length varint, but the frame is only valid as the last one in a
+	// packet.
+	OmitLength bool
+}
+
+func (f *StreamFrame) Type() uint64 {
+	t := uint64(FrameTypeStream)
+	if f.Offset > 0 {
+		t |= 0x04
+	}
+	if len(f.Data) > 0 && !f.OmitLength {
+		t |= 0x02
+	}
+	if f.Fin {
+		t |= 0x01
+	}
+	return t
+}
+
+func (f *StreamFrame) IsAckEliciting() bool { return true }
+
+func (f *StreamFrame) Length() int {
+	l := 1 + varintLen(f.StreamID)
+	if f.Offset > 0 {
+		l += varintLen(f.Offset)
+	}
+	if len(f.Data) > 0 && !f.OmitLength {
+		l += varintLen(uint64(len(f.Data)))
+	}
+	return l + len(f.Data)
+}
+
+func (f *StreamFrame) Serialize() ([]byte, error) {
+	buf := make([]byte, f.Length()+8)
+	pos := 0
+	n, err := putVarint(buf[pos:], f.Type())
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	n, err = putVarint(buf[pos:], f.StreamID)
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	if f.Offset > 0 {
+		n, err = putVarint(buf[pos:], f.Offset)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	if len(f.Data) > 0 && !f.OmitLength {
+		n, err = putVarint(buf[pos:], uint64(len(f.Data)))
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	pos += copy(buf[pos:], f.Data)
+	return buf[:pos], nil
+}
+
+// MaxDataFrame updates the connection-level flow control limit.
+type MaxDataFrame struct {
+	MaximumData uint64
+}
+
+func (f *MaxDataFrame) Type() uint64         { return FrameTypeMaxData }
+func (f *MaxDataFrame) IsAckEliciting() bool { return true }
+func (f *MaxDataFrame) Length() int          { return 1 + varintLen(f.MaximumData) }
+func (f *MaxDataFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeMaxData, f.MaximumData)
+}
+
+// MaxStreamDataFrame updates a stream-level flow control limit.
+type MaxStreamDataFrame struct {
+	StreamID    uint64
+	MaximumData uint64
+}
+
+func (f *MaxStreamDataFrame) Type() uint64         { return FrameTypeMaxStreamData }
+func (f *MaxStreamDataFrame) IsAckEliciting() bool { return true }
+func (f *MaxStreamDataFrame) Length() int {
+	return 1 + varintLen(f.StreamID) + varintLen(f.MaximumData)
+}
+func (f *MaxStreamDataFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeMaxStreamData, f.StreamID, f.MaximumData)
+}
+
+// MaxStreamsFrame updates the cumulative stream limit.
+type MaxStreamsFrame struct {
+	Uni            bool
+	MaximumStreams uint64
+}
+
+func (f *MaxStreamsFrame) Type() uint64 {
+	if f.Uni {
+		return FrameTypeMaxStreamsUni
+	}
+	return FrameTypeMaxStreamsBidi
+}
+
+func (f *MaxStreamsFrame) IsAckEliciting() bool { return true }
+func (f *MaxStreamsFrame) Length() int          { return 1 + varintLen(f.MaximumStreams) }
+func (f *MaxStreamsFrame) Serialize() ([]byte, error) {
+	return serializeVarints(f.Type(), f.MaximumStreams)
+}
+
+// DataBlockedFrame indicates the sender is blocked on connection flow control.
+type DataBlockedFrame struct {
+	MaximumData uint64
+}
+
+func (f *DataBlockedFrame) Type() uint64         { return FrameTypeDataBlocked }
+func (f *DataBlockedFrame) IsAckEliciting() bool { return true }
+func (f *DataBlockedFrame) Length() int          { return 1 + varintLen(f.MaximumData) }
+func (f *DataBlockedFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeDataBlocked, f.MaximumData)
+}
+
+// StreamDataBlockedFrame indicates the sender is blocked on stream flow control.
+type StreamDataBlockedFrame struct {
+	StreamID    uint64
+	MaximumData uint64
+}
+
+func (f *StreamDataBlockedFrame) Type() uint64         { return FrameTypeStreamDataBlocked }
+func (f *StreamDataBlockedFrame) IsAckEliciting() bool { return true }
+func (f *StreamDataBlockedFrame) Length() int {
+	return 1 + varintLen(f.StreamID) + varintLen(f.MaximumData)
+}
+func (f *StreamDataBlockedFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeStreamDataBlocked, f.StreamID, f.MaximumData)
+}
+
+// StreamsBlockedFrame indicates the sender is blocked on the stream limit.
+type StreamsBlockedFrame struct {
+	Uni            bool
+	MaximumStreams uint64
+}
+
+func (f *StreamsBlockedFrame) Type() uint64 {
+	if f.Uni {
+		return FrameTypeStreamsBlockedUni
+	}
+	return FrameTypeStreamsBlockedBidi
+}
+
+func (f *StreamsBlockedFrame) IsAckEliciting() bool { return true }
+func (f *StreamsBlockedFrame) Length() int          { return 1 + varintLen(f.MaximumStreams) }
+func (f *StreamsBlockedFrame) Serialize() ([]byte, error) {
+	return serializeVarints(f.Type(), f.MaximumStreams)
+}
+
+// NewConnectionIDFrame provides the peer with an alternative connection ID.
+type NewConnectionIDFrame struct {
+	SequenceNumber      uint64
+	RetirePriorTo       uint64
+	ConnectionID        []byte
+	StatelessResetToken [16]byte
+}
+
+func (f *NewConnectionIDFrame) Type() uint64         { return FrameTypeNewConnectionID }
+func (f *NewConnectionIDFrame) IsAckEliciting() bool { return true }
+func (f *NewConnectionIDFrame) Length() int {
+	return 1 + varintLen(f.SequenceNumber) + varintLen(f.RetirePriorTo) +
+		1 + len(f.ConnectionID) + 16
+}
+func (f *NewConnectionIDFrame) Serialize() ([]byte, error) {
+	if len(f.ConnectionID) == 0 || len(f.ConnectionID) > 20 {
+		return nil, fmt.Errorf("invalid connection ID length %d", len(f.ConnectionID))
+	}
+	buf, err := serializeVarints(FrameTypeNewConnectionID, f.SequenceNumber, f.RetirePriorTo)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, byte(len(f.ConnectionID)))
+	buf = append(buf, f.ConnectionID...)
+	buf = append(buf, f.StatelessResetToken[:]...)
+	return buf, nil
+}
+
+// RetireConnectionIDFrame retires a connection ID issued by the peer.
+type RetireConnectionIDFrame struct {
+	SequenceNumber uint64
+}
+
+func (f *RetireConnectionIDFrame) Type() uint64         { return FrameTypeRetireConnectionID }
+func (f *RetireConnectionIDFrame) IsAckEliciting() bool { return true }
+func (f *RetireConnectionIDFrame) Length() int          { return 1 + varintLen(f.SequenceNumber) }
+func (f *RetireConnectionIDFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeRetireConnectionID, f.SequenceNumber)
+}
+
+// PathChallengeFrame probes a network path.
+type PathChallengeFrame struct {
+	Data [8]byte
+}
+
+func (f *PathChallengeFrame) Type() uint64         { return FrameTypePathChallenge }
+func (f *PathChallengeFrame) IsAckEliciting() bool { return true }
+func (f *PathChallengeFrame) Length() int          { return 9 }
+func (f *PathChallengeFrame) Serialize() ([]byte, error) {
+	buf := make([]byte, 0, 9)
+	buf = append(buf, FrameTypePathChallenge)
+	return append(buf, f.Data[:]...), nil
+}
+
+// PathResponseFrame answers a PATH_CHALLENGE.
+type PathResponseFrame struct {
+	Data [8]byte
+}
+
+func (f *PathResponseFrame) Type() uint64         { return FrameTypePathResponse }
+func (f *PathResponseFrame) IsAckEliciting() bool { return true }
+func (f *PathResponseFrame) Length() int          { return 9 }
+func (f *PathResponseFrame) Serialize() ([]byte, error) {
+	buf := make([]byte, 0, 9)
+	buf = append(buf, FrameTypePathResponse)
+	return append(buf, f.Data[:]...), nil
+}
+
+// ConnectionCloseFrame signals that the connection is being closed.
+// IsApplication distinguishes the 0x1d application variant from the 0x1c
+// transport variant; only the transport variant carries FrameType.
+type ConnectionCloseFrame struct {
+	IsApplication bool
+	ErrorCode     uint64
+	FrameType     uint64
+	ReasonPhrase  string
+}
+
+func (f *ConnectionCloseFrame) Type() uint64 {
+	if f.IsApplication {
+		return FrameTypeConnectionCloseApp
+	}
+	return FrameTypeConnectionClose
+}
+
+func (f *ConnectionCloseFrame) IsAckEliciting() bool { return false }
+
+func (f *ConnectionCloseFrame) Length() int {
+	l := 1 + varintLen(f.ErrorCode) +
+		varintLen(uint64(len(f.ReasonPhrase))) + len(f.ReasonPhrase)
+	if !f.IsApplication {
+		l += varintLen(f.FrameType)
+	}
+	return l
+}
+
+func (f *ConnectionCloseFrame) Serialize() ([]byte, error) {
+	buf := make([]byte, f.Length()+16)
+	pos := 0
+	n, err := putVarint(buf[pos:], f.Type())
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	n, err = putVarint(buf[pos:], f.ErrorCode)
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	if !f.IsApplication {
+		n, err = putVarint(buf[pos:], f.FrameType)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	n, err = putVarint(buf[pos:], uint64(len(f.ReasonPhrase)))
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	pos += copy(buf[pos:], f.ReasonPhrase)
+	return buf[:pos], nil
+}
+
+// HandshakeDoneFrame confirms handshake completion to the client.
+type HandshakeDoneFrame struct{}
+
+func (f *HandshakeDoneFrame) Type() uint64         { return FrameTypeHandshakeDone }
+func (f *HandshakeDoneFrame) IsAckEliciting() bool { return true }
+func (f *HandshakeDoneFrame) Length() int          { return 1 }
+func (f *HandshakeDoneFrame) Serialize() ([]byte, error) {
+	return []byte{FrameTypeHandshakeDone}, nil
+}
+
+// StreamChecksumFrame is a private extension frame used when the config
+// enables debug stream checksums: it carries the sender's CRC-32 over the
+// stream's first FinalSize bytes, so the receiver can verify its reassembly
+// of them.
+type StreamChecksumFrame struct {
+	StreamID  uint64
+	FinalSize uint64
+	Checksum  uint64
+}
+
+func (f *StreamChecksumFrame) Type() uint64         { return FrameTypeStreamChecksum }
+func (f *StreamChecksumFrame) IsAckEliciting() bool { return true }
+func (f *StreamChecksumFrame) Length() int {
+	return varintLen(FrameTypeStreamChecksum) + varintLen(f.StreamID) +
+		varintLen(f.FinalSize) + varintLen(f.Checksum)
+}
+func (f *StreamChecksumFrame) Serialize() ([]byte, error) {
+	return serializeVarints(FrameTypeStreamChecksum, f.StreamID, f.FinalSize, f.Checksum)
+}
+
+// HasAckElicitingFrames reports whether any frame in the slice is
+// ack-eliciting.
+func HasAckElicitingFrames(frames []Frame) bool {
+	for _, f := range frames {
+		if f.IsAckEliciting() {
+			return true
+		}
+	}
+	return false
+}
+
+// serializeVarints encodes a frame that consists only of varint fields.
+func serializeVarints(values ...uint64) ([]byte, error) {
+	buf := make([]byte, 0, 8*len(values))
+	var scratch [8]byte
+	for _, v := range values {
+		n, err := putVarint(scratch[:], v)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, scratch[:n]...)
+	}
+	return buf, nil
+}
+
+// DefaultMaxFramesPerPacket is the frame count ParseFrames tolerates in one
+// packet payload. It is far beyond what a well-behaved peer produces; the
+// bound exists so a packet stuffed with tiny frames cannot soak up unbounded
+// parse work.
+const DefaultMaxFramesPerPacket = 2048
+
+// ErrTooManyFrames is returned when a packet payload contains more frames
+// than the parser's limit allows.
+var ErrTooManyFrames = errors.New("too many frames in packet")
+
+// ParseFrames parses all frames contained in a packet payload, allowing up
+// to DefaultMaxFramesPerPacket of them.
+func ParseFrames(data []byte) ([]Frame, error) {
+	return ParseFramesLimit(data, DefaultMaxFramesPerPacket)
+}
+
+// ParseFramesLimit is ParseFrames with an explicit frame-count limit; zero
+// or less means DefaultMaxFramesPerPacket. PADDING does not count against
+// the limit, so ordinary full-size padded packets are unaffected.
+func ParseFramesLimit(data []byte, maxFrames int) ([]Frame, error) {
+	if maxFrames <= 0 {
+		maxFrames = DefaultMaxFramesPerPacket
+	}
+	var frames []Frame
+	for len(data) > 0 {
+		frame, n, err := parseFrame(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if frame != nil { // padding returns nil
+			if len(frames) >= maxFrames {
+				return nil, ErrTooManyFrames
+			}
+			frames = append(frames, frame)
+		}
+	}
+	return frames, nil
+}
+
+func parseFrame(data []byte) (Frame, int, error) {
+	frameType, n, err := parseVarint(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed frame type: %w", err)
+	}
+	switch {
+	case frameType == FrameTypePadding:
+		return nil, n, nil
+	case frameType == FrameTypePing:
+		return &PingFrame{}, n, nil
+	case frameType == FrameTypeAck || frameType == FrameTypeAckECN:
+		return parseAckFrame(data[n:], frameType, n)
+	case frameType == FrameTypeResetStream:
+		return parseResetStreamFrame(data[n:], n)
+	case frameType == FrameTypeStopSending:
+		return parseStopSendingFrame(data[n:], n)
+	case frameType == FrameTypeCrypto:
+		return parseCryptoFrame(data[n:], n)
+	case frameType == FrameTypeNewToken:
+		return parseNewTokenFrame(data[n:], n)
+	case frameType >= FrameTypeStream && frameType <= FrameTypeStreamMax:
+		return parseStreamFrame(data[n:], frameType, n)
+	case frameType == FrameTypeMaxData:
+		return parseMaxDataFrame(data[n:], n)
+	case frameType == FrameTypeMaxStreamData:
+		return parseMaxStreamDataFrame(data[n:], n)
+	case frameType == FrameTypeMaxStreamsBidi || frameType == FrameTypeMaxStreamsUni:
+		return parseMaxStreamsFrame(data[n:], frameType, n)
+	case frameType == FrameTypeDataBlocked:
+		v, m, err := parseVarint(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return &DataBlockedFrame{MaximumData: v}, n + m, nil
+	case frameType == FrameTypeStreamDataBlocked:
+		id, m1, err := parseVarint(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		v, m2, err := parseVarint(data[n+m1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return &StreamDataBlockedFrame{StreamID: id, MaximumData: v}, n + m1 + m2, nil
+	case frameType == FrameTypeStreamsBlockedBidi || frameType == FrameTypeStreamsBlockedUni:
+		v, m, err := parseVarint(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return &StreamsBlockedFrame{Uni: frameType == FrameTypeStreamsBlockedUni, MaximumStreams: v}, n + m, nil
+	case frameType == FrameTypeNewConnectionID:
+		return parseNewConnectionIDFrame(data[n:], n)
+	case frameType == FrameTypeRetireConnectionID:
+		v, m, err := parseVarint(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return &RetireConnectionIDFrame{SequenceNumber: v}, n + m, nil
+	case frameType == FrameTypePathChallenge:
+		if len(data) < n+8 {
+			return nil, 0, fmt.Errorf("truncated PATH_CHALLENGE")
+		}
+		f := &PathChallengeFrame{}
+		copy(f.Data[:], data[n:n+8])
+		return f, n + 8, nil
+	case frameType == FrameTypePathResponse:
+		if len(data) < n+8 {
+			return nil, 0, fmt.Errorf("truncated PATH_RESPONSE")
+		}
+		f := &PathResponseFrame{}
+		copy(f.Data[:], data[n:n+8])
+		return f, n + 8, nil
+	case frameType == FrameTypeConnectionClose || frameType == FrameTypeConnectionCloseApp:
+		return parseConnectionCloseFrame(data[n:], frameType, n)
+	case frameType == FrameTypeHandshakeDone:
+		return &HandshakeDoneFrame{}, n, nil
+	case frameType == FrameTypeStreamChecksum:
+		f := &StreamChecksumFrame{}
+		pos := n
+		var m int
+		var err error
+		if f.StreamID, m, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += m
+		if f.FinalSize, m, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += m
+		if f.Checksum, m, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		return f, pos + m, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown frame type 0x%x", frameType)
+	}
+}
+
+func parseAckFrame(data []byte, frameType uint64, consumed int) (Frame, int, error) {
+	f := &AckFrame{}
+	pos := 0
+	var err error
+	var n int
+	if f.LargestAcked, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if f.AckDelay, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	rangeCount, n, err := parseVarint(data[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if f.FirstAckRange, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	for i := uint64(0); i < rangeCount; i++ {
+		var r AckRange
+		if r.Gap, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		if r.Length, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		f.AckRanges = append(f.AckRanges, r)
+	}
+	if frameType == FrameTypeAckECN {
+		f.HasECN = true
+		if f.ECT0Count, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		if f.ECT1Count, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		if f.ECNCECount, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+	}
+	return f, consumed + pos, nil
+}
+
+func parseResetStreamFrame(data []byte, consumed int) (Frame, int, error) {
+	f := &ResetStreamFrame{}
+	pos := 0
+	var err error
+	var n int
+	if f.StreamID, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if f.ErrorCode, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if f.FinalSize, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	return f, consumed + pos, nil
+}
+
+func parseStopSendingFrame(data []byte, consumed int) (Frame, int, error) {
+	f := &StopSendingFrame{}
+	pos := 0
+	var err error
+	var n int
+	if f.StreamID, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if f.ErrorCode, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	return f, consumed + pos, nil
+}
+
+func parseCryptoFrame(data []byte, consumed int) (Frame, int, error) {
+	f := &CryptoFrame{}
+	pos := 0
+	var err error
+	var n int
+	if f.Offset, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	length, n, err := parseVarint(data[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if uint64(len(data)-pos) < length {
+		return nil, 0, fmt.Errorf("truncated CRYPTO frame")
+	}
+	f.Data = append([]byte(nil), data[pos:pos+int(length)]...)
+	pos += int(length)
+	return f, consumed + pos, nil
+}
+
+func parseNewTokenFrame(data []byte, consumed int) (Frame, int, error) {
+	length, n, err := parseVarint(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if length == 0 {
+		return nil, 0, fmt.Errorf("empty NEW_TOKEN")
+	}
+	if uint64(len(data)-n) < length {
+		return nil, 0, fmt.Errorf("truncated NEW_TOKEN frame")
+	}
+	f := &NewTokenFrame{Token: append([]byte(nil), data[n:n+int(length)]...)}
+	return f, consumed + n + int(length), nil
+}
+
+func parseStreamFrame(data []byte, frameType uint64, consumed int) (Frame, int, error) {
+	f := &StreamFrame{}
+	hasOffset := frameType&0x04 != 0
+	hasLength := frameType&0x02 != 0
+	f.Fin = frameType&0x01 != 0
+
+	pos := 0
+	var err error
+	var n int
+	if f.StreamID, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if hasOffset {
+		if f.Offset, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+	}
+	if hasLength {
+		length, n, err := parseVarint(data[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		if uint64(len(data)-pos) < length {
+			return nil, 0, fmt.Errorf("truncated STREAM frame")
+		}
+		f.Data = append([]byte(nil), data[pos:pos+int(length)]...)
+		pos += int(length)
+	} else {
+		// Without a length field the data extends to the end of the packet.
+		f.OmitLength = true
+		f.Data = append([]byte(nil), data[pos:]...)
+		pos = len(data)
+	}
+	return f, consumed + pos, nil
+}
+
+func parseMaxDataFrame(data []byte, consumed int) (Frame, int, error) {
+	v, n, err := parseVarint(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &MaxDataFrame{MaximumData: v}, consumed + n, nil
+}
+
+func parseMaxStreamDataFrame(data []byte, consumed int) (Frame, int, error) {
+	id, n1, err := parseVarint(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	v, n2, err := parseVarint(data[n1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return &MaxStreamDataFrame{StreamID: id, MaximumData: v}, consumed + n1 + n2, nil
+}
+
+func parseMaxStreamsFrame(data []byte, frameType uint64, consumed int) (Frame, int, error) {
+	v, n, err := parseVarint(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &MaxStreamsFrame{Uni: frameType == FrameTypeMaxStreamsUni, MaximumStreams: v}, consumed + n, nil
+}
+
+func parseNewConnectionIDFrame(data []byte, consumed int) (Frame, int, error) {
+	f := &NewConnectionIDFrame{}
+	pos := 0
+	var err error
+	var n int
+	if f.SequenceNumber, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if f.RetirePriorTo, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if len(data) <= pos {
+		return nil, 0, fmt.Errorf("truncated NEW_CONNECTION_ID frame")
+	}
+	cidLen := int(data[pos])
+	pos++
+	if cidLen == 0 || cidLen > 20 || len(data) < pos+cidLen+16 {
+		return nil, 0, fmt.Errorf("malformed NEW_CONNECTION_ID frame")
+	}
+	f.ConnectionID = append([]byte(nil), data[pos:pos+cidLen]...)
+	pos += cidLen
+	copy(f.StatelessResetToken[:], data[pos:pos+16])
+	pos += 16
+	return f, consumed + pos, nil
+}
+
+func parseConnectionCloseFrame(data []byte, frameType uint64, consumed int) (Frame, int, error) {
+	f := &ConnectionCloseFrame{IsApplication: frameType == FrameTypeConnectionCloseApp}
+	pos := 0
+	var err error
+	var n int
+	if f.ErrorCode, n, err = parseVarint(data[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if !f.IsApplication {
+		if f.FrameType, n, err = parseVarint(data[pos:]); err != nil {
+			return nil, 0, err
+		}
+		pos += n
+	}
+	length, n, err := parseVarint(data[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if uint64(len(data)-pos) < length {
+		return nil, 0, fmt.Errorf("truncated CONNECTION_CLOSE frame")
+	}
+	f.ReasonPhrase = string(data[pos : pos+int(length)])
+	pos += int(length)
+	return f, consumed + pos, nil
+}
@@ -0,0 +1,176 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIsAckEliciting(t *testing.T) {
+	tests := []struct {
+		frame Frame
+		want  bool
+	}{
+		{&PaddingFrame{}, false},
+		{&PingFrame{}, true},
+		{&AckFrame{LargestAcked: 1}, false},
+		{&AckFrame{LargestAcked: 1, ECT0Count: 1}, false},
+		{&ResetStreamFrame{}, true},
+		{&StopSendingFrame{}, true},
+		{&CryptoFrame{Data: []byte{1}}, true},
+		{&NewTokenFrame{Token: []byte{1}}, true},
+		{&StreamFrame{StreamID: 0, Data: []byte{1}}, true},
+		{&MaxDataFrame{}, true},
+		{&MaxStreamDataFrame{}, true},
+		{&MaxStreamsFrame{}, true},
+		{&DataBlockedFrame{}, true},
+		{&StreamDataBlockedFrame{}, true},
+		{&StreamsBlockedFrame{}, true},
+		{&NewConnectionIDFrame{ConnectionID: []byte{1}}, true},
+		{&RetireConnectionIDFrame{}, true},
+		{&PathChallengeFrame{}, true},
+		{&PathResponseFrame{}, true},
+		{&ConnectionCloseFrame{}, false},
+		{&ConnectionCloseFrame{IsApplication: true}, false},
+		{&HandshakeDoneFrame{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.frame.IsAckEliciting(); got != tt.want {
+			t.Errorf("%T.IsAckEliciting() = %v, want %v", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestHasAckElicitingFrames(t *testing.T) {
+	if HasAckElicitingFrames([]Frame{&AckFrame{}, &PaddingFrame{}}) {
+		t.Error("ACK+PADDING should not be ack-eliciting")
+	}
+	if !HasAckElicitingFrames([]Frame{&AckFrame{}, &PingFrame{}}) {
+		t.Error("a packet containing a PING is ack-eliciting")
+	}
+	if HasAckElicitingFrames(nil) {
+		t.Error("an empty packet is not ack-eliciting")
+	}
+}
+
+func TestAckECNWithZeroCountsRoundTrips(t *testing.T) {
+	f := &AckFrame{LargestAcked: 9, FirstAckRange: 2, HasECN: true}
+	if f.Type() != FrameTypeAckECN {
+		t.Fatalf("Type = %#x, want ACK_ECN", f.Type())
+	}
+	data, err := f.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := ParseFrames(data)
+	if err != nil {
+		t.Fatalf("ParseFrames: %v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("parsed %d frames, want 1", len(frames))
+	}
+	ack, ok := frames[0].(*AckFrame)
+	if !ok {
+		t.Fatalf("parsed %T, want *AckFrame", frames[0])
+	}
+	// The all-zero counts must not demote the frame to a plain ACK on the
+	// next serialization.
+	if !ack.HasECN || ack.Type() != FrameTypeAckECN {
+		t.Errorf("parsed ACK_ECN lost its ECN marking: %+v", ack)
+	}
+	reserialized, err := ack.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reserialized) != len(data) {
+		t.Errorf("re-serialized to %d bytes, want %d", len(reserialized), len(data))
+	}
+}
+
+func TestFrameLengthMatchesSerialized(t *testing.T) {
+	// Values spanning every varint size tier, including both sides of each
+	// boundary, so a varintLen/putVarint disagreement shows up as a length
+	// mismatch.
+	values := []uint64{0, 1, maxVarint1, maxVarint1 + 1, maxVarint2,
+		maxVarint2 + 1, maxVarint4, maxVarint4 + 1, maxVarint8}
+
+	var frames []Frame
+	for _, v := range values {
+		frames = append(frames,
+			&AckFrame{LargestAcked: v, AckDelay: v, FirstAckRange: 0},
+			&AckFrame{LargestAcked: v, FirstAckRange: 0,
+				AckRanges: []AckRange{{Gap: v, Length: 0}}, ECT0Count: v},
+			&ResetStreamFrame{StreamID: v, ErrorCode: v, FinalSize: v},
+			&StopSendingFrame{StreamID: v, ErrorCode: v},
+			&CryptoFrame{Offset: v, Data: []byte("crypto")},
+			&MaxDataFrame{MaximumData: v},
+			&MaxStreamDataFrame{StreamID: v, MaximumData: v},
+			&MaxStreamsFrame{MaximumStreams: v},
+			&MaxStreamsFrame{Uni: true, MaximumStreams: v},
+			&DataBlockedFrame{MaximumData: v},
+			&StreamDataBlockedFrame{StreamID: v, MaximumData: v},
+			&StreamsBlockedFrame{MaximumStreams: v},
+			&NewConnectionIDFrame{SequenceNumber: v, RetirePriorTo: v, ConnectionID: []byte{1, 2, 3, 4}},
+			&RetireConnectionIDFrame{SequenceNumber: v},
+			&ConnectionCloseFrame{IsApplication: true, ErrorCode: v, ReasonPhrase: "going away"},
+			&ConnectionCloseFrame{ErrorCode: v, FrameType: v, ReasonPhrase: "protocol violation"},
+			&ConnectionCloseFrame{ErrorCode: v, FrameType: 0},
+		)
+		if v <= maxVarint8/2 { // stream IDs and offsets stay below the data length
+			frames = append(frames,
+				&StreamFrame{StreamID: v, Offset: v, Data: []byte("stream data")},
+				&StreamFrame{StreamID: v, Fin: true})
+		}
+	}
+	frames = append(frames,
+		&PaddingFrame{}, &PingFrame{}, &HandshakeDoneFrame{},
+		&PathChallengeFrame{Data: [8]byte{1}}, &PathResponseFrame{Data: [8]byte{2}},
+		&NewTokenFrame{Token: []byte("token")})
+
+	for _, f := range frames {
+		data, err := f.Serialize()
+		if err != nil {
+			t.Errorf("%T (type %#x): Serialize: %v", f, f.Type(), err)
+			continue
+		}
+		if len(data) != f.Length() {
+			t.Errorf("%T (type %#x): serialized %d bytes but Length() = %d",
+				f, f.Type(), len(data), f.Length())
+		}
+	}
+}
+
+func TestStreamFrameOmitLengthRunsToEndOfPacket(t *testing.T) {
+	w := NewFrameWriter(100)
+	if err := w.WriteFrame(&PingFrame{}); err != nil {
+		t.Fatal(err)
+	}
+	frame := &StreamFrame{StreamID: 4, Offset: 10, Data: []byte("payload"), Fin: true, OmitLength: true}
+	if err := w.WriteFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := w.Bytes()
+	// The second byte is the STREAM frame's type: LEN must be clear.
+	if payload[1]&0x02 != 0 {
+		t.Errorf("STREAM type byte = %#x, want the LEN bit clear", payload[1])
+	}
+	explicit := &StreamFrame{StreamID: 4, Offset: 10, Data: []byte("payload"), Fin: true}
+	if got, want := frame.Length(), explicit.Length()-1; got != want {
+		t.Errorf("omitted-length frame is %d bytes, want %d (one varint shorter)", got, want)
+	}
+
+	frames, err := ParseFrames(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	parsed, ok := frames[1].(*StreamFrame)
+	if !ok {
+		t.Fatalf("frame 1 is %T, want *StreamFrame", frames[1])
+	}
+	if !bytes.Equal(parsed.Data, frame.Data) || parsed.Offset != 10 || !parsed.Fin || !parsed.OmitLength {
+		t.Errorf("parsed frame = %+v, want data %q at offset 10 with FIN, to end of packet", parsed, frame.Data)
+	}
+}
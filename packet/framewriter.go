@@ -0,0 +1,46 @@
+package packet
+
+import "errors"
+
+// ErrFrameTooLarge is returned by FrameWriter.WriteFrame when the frame does
+// not fit in the remaining space.
+var ErrFrameTooLarge = errors.New("frame does not fit in remaining space")
+
+// FrameWriter packs frames into a payload of bounded size, tracking the
+// space left so callers can fill a packet without overflowing it.
+type FrameWriter struct {
+	buf  []byte
+	size int
+}
+
+// NewFrameWriter returns a writer for a payload of at most size bytes.
+func NewFrameWriter(size int) *FrameWriter {
+	return &FrameWriter{size: size}
+}
+
+// Remaining returns how many payload bytes are still free.
+func (w *FrameWriter) Remaining() int {
+	return w.size - len(w.buf)
+}
+
+// WriteFrame appends the frame's serialization to the payload, or returns
+// ErrFrameTooLarge — leaving the payload untouched — when it does not fit.
+func (w *FrameWriter) WriteFrame(f Frame) error {
+	if f.Length() > w.Remaining() {
+		return ErrFrameTooLarge
+	}
+	data, err := f.Serialize()
+	if err != nil {
+		return err
+	}
+	if len(data) > w.Remaining() {
+		return ErrFrameTooLarge
+	}
+	w.buf = append(w.buf, data...)
+	return nil
+}
+
+// Bytes returns the packed payload.
+func (w *FrameWriter) Bytes() []byte {
+	return w.buf
+}
@@ -0,0 +1,58 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFrameWriterPacksUntilFull(t *testing.T) {
+	ping := &PingFrame{}
+	stream := &StreamFrame{StreamID: 1, Data: []byte("abcdef")}
+	w := NewFrameWriter(ping.Length() + stream.Length())
+
+	if err := w.WriteFrame(ping); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Remaining(); got != stream.Length() {
+		t.Errorf("Remaining after PING = %d, want %d", got, stream.Length())
+	}
+	if err := w.WriteFrame(stream); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Remaining(); got != 0 {
+		t.Errorf("Remaining after filling the writer = %d, want 0", got)
+	}
+
+	pingBytes, _ := ping.Serialize()
+	streamBytes, _ := stream.Serialize()
+	want := append(pingBytes, streamBytes...)
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("Bytes = %x, want %x", w.Bytes(), want)
+	}
+}
+
+func TestFrameWriterRejectsFrameOneByteTooLarge(t *testing.T) {
+	frame := &StreamFrame{StreamID: 1, Data: []byte("abcdef")}
+	w := NewFrameWriter(frame.Length() - 1)
+
+	if err := w.WriteFrame(frame); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("WriteFrame = %v, want ErrFrameTooLarge", err)
+	}
+	// A rejected frame leaves the payload and the space accounting alone.
+	if got := w.Remaining(); got != frame.Length()-1 {
+		t.Errorf("Remaining after rejection = %d, want %d", got, frame.Length()-1)
+	}
+	if len(w.Bytes()) != 0 {
+		t.Errorf("payload is %d bytes after rejection, want empty", len(w.Bytes()))
+	}
+
+	// Exactly fitting succeeds.
+	w = NewFrameWriter(frame.Length())
+	if err := w.WriteFrame(frame); err != nil {
+		t.Fatalf("WriteFrame at exact capacity = %v", err)
+	}
+	if got := w.Remaining(); got != 0 {
+		t.Errorf("Remaining = %d, want 0", got)
+	}
+}
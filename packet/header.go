@@ -0,0 +1,256 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Packet types. Initial, Handshake, ZeroRTT and Retry use the long header;
+// 1-RTT packets use the short header.
+const (
+	PacketTypeInitial   = 0x00
+	PacketTypeZeroRTT   = 0x01
+	PacketTypeHandshake = 0x02
+	PacketTypeRetry     = 0x03
+	PacketType1RTT      = 0x04
+)
+
+// Version1 is the QUIC version carried in long headers.
+const Version1 = uint32(0x00000001)
+
+// Header describes a parsed or to-be-serialized packet header.
+type Header struct {
+	Type         uint8
+	Version      uint32
+	DestConnID   []byte
+	SrcConnID    []byte
+	Token        []byte
+	PacketNumber uint64
+	// PacketNumberLen, when 1 through 4, fixes the encoded packet number
+	// length instead of deriving the shortest one from the value, e.g. to
+	// reproduce wire examples with a fixed 4-byte packet number. Zero lets
+	// Serialize pick the shortest encoding. ParseHeader records the length
+	// found on the wire, so a parsed header re-serializes byte for byte.
+	PacketNumberLen int
+}
+
+// IsLongHeader reports whether the header uses the long form.
+func (h *Header) IsLongHeader() bool {
+	return h.Type != PacketType1RTT
+}
+
+// getPacketNumberLength returns the number of bytes used to encode pn.
+func getPacketNumberLength(pn uint64) int {
+	switch {
+	case pn < 1<<8:
+		return 1
+	case pn < 1<<16:
+		return 2
+	case pn < 1<<24:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// packetNumberLength returns the encoded packet number length: the fixed
+// PacketNumberLen when set, otherwise the shortest encoding of the value.
+func (h *Header) packetNumberLength() (int, error) {
+	if h.PacketNumberLen == 0 {
+		return getPacketNumberLength(h.PacketNumber), nil
+	}
+	if h.PacketNumberLen < 1 || h.PacketNumberLen > 4 {
+		return 0, fmt.Errorf("packet number length must be 1 to 4 bytes, got %d", h.PacketNumberLen)
+	}
+	if getPacketNumberLength(h.PacketNumber) > h.PacketNumberLen {
+		return 0, fmt.Errorf("packet number %d does not fit in %d bytes", h.PacketNumber, h.PacketNumberLen)
+	}
+	return h.PacketNumberLen, nil
+}
+
+// Serialize writes the header into a freshly allocated buffer.
+func (h *Header) Serialize() ([]byte, error) {
+	if h.IsLongHeader() {
+		return h.serializeLongHeader()
+	}
+	return h.serializeShortHeader()
+}
+
+func (h *Header) serializeLongHeader() ([]byte, error) {
+	if len(h.DestConnID) > 20 || len(h.SrcConnID) > 20 {
+		return nil, fmt.Errorf("connection ID too long")
+	}
+	pnLen, err := h.packetNumberLength()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 7+len(h.DestConnID)+len(h.SrcConnID)+len(h.Token)+8+pnLen)
+
+	firstByte := byte(0x80) | byte(0x40) | h.Type<<4 | byte(pnLen-1)
+	buf = append(buf, firstByte)
+	buf = binary.BigEndian.AppendUint32(buf, h.Version)
+	buf = append(buf, byte(len(h.DestConnID)))
+	buf = append(buf, h.DestConnID...)
+	buf = append(buf, byte(len(h.SrcConnID)))
+	buf = append(buf, h.SrcConnID...)
+	if h.Type == PacketTypeInitial || h.Type == PacketTypeRetry {
+		var tokenLen [8]byte
+		n, err := putVarint(tokenLen[:], uint64(len(h.Token)))
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, tokenLen[:n]...)
+		buf = append(buf, h.Token...)
+	}
+	buf = appendPacketNumber(buf, h.PacketNumber, pnLen)
+	return buf, nil
+}
+
+func (h *Header) serializeShortHeader() ([]byte, error) {
+	pnLen, err := h.packetNumberLength()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 1+len(h.DestConnID)+pnLen)
+	firstByte := byte(0x40) | byte(pnLen-1)
+	buf = append(buf, firstByte)
+	buf = append(buf, h.DestConnID...)
+	buf = appendPacketNumber(buf, h.PacketNumber, pnLen)
+	return buf, nil
+}
+
+func appendPacketNumber(buf []byte, pn uint64, pnLen int) []byte {
+	for i := pnLen - 1; i >= 0; i-- {
+		buf = append(buf, byte(pn>>(8*i)))
+	}
+	return buf
+}
+
+// ErrFixedBitZero reports a packet whose fixed bit (0x40) is cleared. Such a
+// packet is not valid QUIC unless the peers negotiated grease_quic_bit
+// (RFC 9287).
+var ErrFixedBitZero = errors.New("fixed bit is zero")
+
+// ErrReservedBitsSet reports a packet with nonzero reserved bits in its first
+// byte, which is a PROTOCOL_VIOLATION once the packet authenticates
+// (RFC 9000 section 17).
+var ErrReservedBitsSet = errors.New("reserved bits set")
+
+// Reserved bits of the first byte: bits 3-2 in the long header, bits 4-3 in
+// the short header (where bit 5 is the spin bit).
+const (
+	reservedBitsLong  = 0x0c
+	reservedBitsShort = 0x18
+)
+
+// ParseHeader parses a packet header from data. connIDLen is the length of
+// connection IDs this endpoint hands out; it is needed to parse the short
+// header, which does not carry an explicit length. It returns the header and
+// the number of bytes consumed.
+//
+// A header violating the fixed-bit or reserved-bits invariants is still
+// returned, along with ErrFixedBitZero or ErrReservedBitsSet; the caller
+// decides whether to drop the packet, tolerate it (grease_quic_bit), or close
+// the connection.
+func ParseHeader(data []byte, connIDLen int) (*Header, int, error) {
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("empty packet")
+	}
+	var (
+		h   *Header
+		pos int
+		err error
+	)
+	reserved := byte(reservedBitsShort)
+	if data[0]&0x80 != 0 {
+		h, pos, err = parseLongHeader(data)
+		reserved = reservedBitsLong
+	} else {
+		h, pos, err = parseShortHeader(data, connIDLen)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if data[0]&0x40 == 0 {
+		return h, pos, ErrFixedBitZero
+	}
+	if data[0]&reserved != 0 {
+		return h, pos, ErrReservedBitsSet
+	}
+	return h, pos, nil
+}
+
+func parseLongHeader(data []byte) (*Header, int, error) {
+	if len(data) < 7 {
+		return nil, 0, fmt.Errorf("long header too short")
+	}
+	h := &Header{}
+	firstByte := data[0]
+	h.Type = (firstByte >> 4) & 0x03
+	pnLen := int(firstByte&0x03) + 1
+	pos := 1
+
+	h.Version = binary.BigEndian.Uint32(data[pos:])
+	pos += 4
+
+	dcidLen := int(data[pos])
+	pos++
+	if dcidLen > 20 || len(data) < pos+dcidLen+1 {
+		return nil, 0, fmt.Errorf("malformed destination connection ID")
+	}
+	h.DestConnID = append([]byte(nil), data[pos:pos+dcidLen]...)
+	pos += dcidLen
+
+	scidLen := int(data[pos])
+	pos++
+	if scidLen > 20 || len(data) < pos+scidLen {
+		return nil, 0, fmt.Errorf("malformed source connection ID")
+	}
+	h.SrcConnID = append([]byte(nil), data[pos:pos+scidLen]...)
+	pos += scidLen
+
+	if h.Type == PacketTypeInitial || h.Type == PacketTypeRetry {
+		tokenLen, n, err := parseVarint(data[pos:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("malformed token length: %w", err)
+		}
+		pos += n
+		if uint64(len(data)) < uint64(pos)+tokenLen {
+			return nil, 0, fmt.Errorf("malformed token")
+		}
+		h.Token = append([]byte(nil), data[pos:pos+int(tokenLen)]...)
+		pos += int(tokenLen)
+	}
+
+	if len(data) < pos+pnLen {
+		return nil, 0, fmt.Errorf("truncated packet number")
+	}
+	h.PacketNumber = parsePacketNumber(data[pos:pos+pnLen], pnLen)
+	h.PacketNumberLen = pnLen
+	pos += pnLen
+	return h, pos, nil
+}
+
+func parseShortHeader(data []byte, connIDLen int) (*Header, int, error) {
+	h := &Header{Type: PacketType1RTT}
+	pnLen := int(data[0]&0x03) + 1
+	pos := 1
+	if len(data) < pos+connIDLen+pnLen {
+		return nil, 0, fmt.Errorf("short header too short")
+	}
+	h.DestConnID = append([]byte(nil), data[pos:pos+connIDLen]...)
+	pos += connIDLen
+	h.PacketNumber = parsePacketNumber(data[pos:pos+pnLen], pnLen)
+	h.PacketNumberLen = pnLen
+	pos += pnLen
+	return h, pos, nil
+}
+
+func parsePacketNumber(data []byte, pnLen int) uint64 {
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		pn = pn<<8 | uint64(data[i])
+	}
+	return pn
+}
@@ -0,0 +1,118 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseHeaderRoundTrip(t *testing.T) {
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tests := []struct {
+		name   string
+		header Header
+	}{
+		{"short", Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 7}},
+		{"long", Header{Type: PacketTypeHandshake, Version: Version1,
+			DestConnID: connID, SrcConnID: connID, PacketNumber: 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.header.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, n, err := ParseHeader(data, len(connID))
+			if err != nil {
+				t.Fatalf("ParseHeader: %v", err)
+			}
+			if n != len(data) {
+				t.Errorf("consumed %d bytes, want %d", n, len(data))
+			}
+			if parsed.Type != tt.header.Type || parsed.PacketNumber != tt.header.PacketNumber ||
+				!bytes.Equal(parsed.DestConnID, tt.header.DestConnID) {
+				t.Errorf("parsed %+v, want %+v", parsed, tt.header)
+			}
+		})
+	}
+}
+
+func TestFixedPacketNumberLength(t *testing.T) {
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	h := &Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 1, PacketNumberLen: 4}
+	data, err := h.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fixed bit plus the encoded length 4 in the low bits, then the
+	// connection ID and the value 1 padded to four bytes.
+	want := append([]byte{0x43}, connID...)
+	want = append(want, 0, 0, 0, 1)
+	if !bytes.Equal(data, want) {
+		t.Fatalf("Serialize = %x, want %x", data, want)
+	}
+
+	parsed, n, err := ParseHeader(data, len(connID))
+	if err != nil {
+		t.Fatalf("ParseHeader: %v", err)
+	}
+	if n != len(data) || parsed.PacketNumber != 1 || parsed.PacketNumberLen != 4 {
+		t.Errorf("parsed %+v consuming %d bytes, want packet number 1 in 4 bytes", parsed, n)
+	}
+
+	// A value that does not fit the fixed length is rejected rather than
+	// silently truncated.
+	bad := &Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 1 << 16, PacketNumberLen: 2}
+	if _, err := bad.Serialize(); err == nil {
+		t.Error("Serialize accepted a packet number wider than its fixed length")
+	}
+}
+
+func TestParseHeaderReservedBits(t *testing.T) {
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	short, err := (&Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 1}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, err := (&Header{Type: PacketTypeInitial, Version: Version1,
+		DestConnID: connID, SrcConnID: connID, PacketNumber: 1}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		bit  byte
+	}{
+		{"short", short, 0x10},
+		{"long", long, 0x04},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.data[0] |= tt.bit
+			header, _, err := ParseHeader(tt.data, len(connID))
+			if !errors.Is(err, ErrReservedBitsSet) {
+				t.Errorf("ParseHeader = %v, want ErrReservedBitsSet", err)
+			}
+			if header == nil {
+				t.Error("header not returned alongside the error")
+			}
+		})
+	}
+}
+
+func TestParseHeaderFixedBitZero(t *testing.T) {
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	data, err := (&Header{Type: PacketType1RTT, DestConnID: connID, PacketNumber: 1}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] &^= 0x40
+	header, _, err := ParseHeader(data, len(connID))
+	if !errors.Is(err, ErrFixedBitZero) {
+		t.Errorf("ParseHeader = %v, want ErrFixedBitZero", err)
+	}
+	if header == nil || header.PacketNumber != 1 {
+		t.Errorf("greased header not parsed: %+v", header)
+	}
+}
@@ -0,0 +1,99 @@
+package packet
+
+import "fmt"
+
+// QUIC variable-length integers use the two most significant bits of the
+// first byte to encode the length of the encoding (1, 2, 4 or 8 bytes).
+const (
+	maxVarint1 = 63
+	maxVarint2 = 16383
+	maxVarint4 = 0x3FFFFFFF
+	maxVarint8 = 0x3FFFFFFFFFFFFFFF
+)
+
+// varintLen returns the number of bytes needed to encode v. The bounds are
+// inclusive, matching putVarint: each threshold value itself still fits in
+// the smaller encoding.
+func varintLen(v uint64) int {
+	if v <= maxVarint1 {
+		return 1
+	}
+	if v <= maxVarint2 {
+		return 2
+	}
+	if v <= maxVarint4 {
+		return 4
+	}
+	return 8
+}
+
+// putVarint encodes v into b and returns the number of bytes written.
+func putVarint(b []byte, v uint64) (int, error) {
+	switch {
+	case v <= maxVarint1:
+		if len(b) < 1 {
+			return 0, fmt.Errorf("buffer too small for varint")
+		}
+		b[0] = byte(v)
+		return 1, nil
+	case v <= maxVarint2:
+		if len(b) < 2 {
+			return 0, fmt.Errorf("buffer too small for varint")
+		}
+		b[0] = 0x40 | byte(v>>8)
+		b[1] = byte(v)
+		return 2, nil
+	case v <= maxVarint4:
+		if len(b) < 4 {
+			return 0, fmt.Errorf("buffer too small for varint")
+		}
+		b[0] = 0x80 | byte(v>>24)
+		b[1] = byte(v >> 16)
+		b[2] = byte(v >> 8)
+		b[3] = byte(v)
+		return 4, nil
+	case v <= maxVarint8:
+		if len(b) < 8 {
+			return 0, fmt.Errorf("buffer too small for varint")
+		}
+		b[0] = 0xC0 | byte(v>>56)
+		b[1] = byte(v >> 48)
+		b[2] = byte(v >> 40)
+		b[3] = byte(v >> 32)
+		b[4] = byte(v >> 24)
+		b[5] = byte(v >> 16)
+		b[6] = byte(v >> 8)
+		b[7] = byte(v)
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("value %d exceeds maximum varint", v)
+	}
+}
+
+// parseVarint decodes a variable-length integer from b and returns the
+// value together with the number of bytes consumed.
+func parseVarint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, fmt.Errorf("empty buffer")
+	}
+	switch b[0] >> 6 {
+	case 0:
+		return uint64(b[0] & 0x3F), 1, nil
+	case 1:
+		if len(b) < 2 {
+			return 0, 0, fmt.Errorf("truncated varint")
+		}
+		return uint64(b[0]&0x3F)<<8 | uint64(b[1]), 2, nil
+	case 2:
+		if len(b) < 4 {
+			return 0, 0, fmt.Errorf("truncated varint")
+		}
+		return uint64(b[0]&0x3F)<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3]), 4, nil
+	default:
+		if len(b) < 8 {
+			return 0, 0, fmt.Errorf("truncated varint")
+		}
+		return uint64(b[0]&0x3F)<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+			uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7]), 8, nil
+	}
+}
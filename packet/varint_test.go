@@ -0,0 +1,71 @@
+package packet
+
+import "testing"
+
+func TestVarintBoundaries(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want int
+	}{
+		{0, 1},
+		{maxVarint1, 1},
+		{maxVarint1 + 1, 2},
+		{maxVarint2, 2},
+		{maxVarint2 + 1, 4},
+		{maxVarint4, 4},
+		{maxVarint4 + 1, 8},
+		{maxVarint8, 8},
+	}
+	for _, tt := range tests {
+		if got := varintLen(tt.v); got != tt.want {
+			t.Errorf("varintLen(%#x) = %d, want %d", tt.v, got, tt.want)
+		}
+		buf := make([]byte, 8)
+		n, err := putVarint(buf, tt.v)
+		if err != nil {
+			t.Errorf("putVarint(%#x): %v", tt.v, err)
+			continue
+		}
+		if n != tt.want {
+			t.Errorf("putVarint(%#x) wrote %d bytes, varintLen says %d", tt.v, n, tt.want)
+		}
+		got, consumed, err := parseVarint(buf[:n])
+		if err != nil || got != tt.v || consumed != n {
+			t.Errorf("parseVarint round-trip of %#x = %#x, %d, %v", tt.v, got, consumed, err)
+		}
+	}
+}
+
+func TestPutVarintRejectsOversized(t *testing.T) {
+	buf := make([]byte, 8)
+	if _, err := putVarint(buf, maxVarint8+1); err == nil {
+		t.Error("putVarint accepted a value above the 62-bit maximum")
+	}
+}
+
+func FuzzVarintRoundTrip(f *testing.F) {
+	for _, seed := range []uint64{0, maxVarint1, maxVarint1 + 1, maxVarint2,
+		maxVarint2 + 1, maxVarint4, maxVarint4 + 1, maxVarint8} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, v uint64) {
+		if v > maxVarint8 {
+			t.Skip()
+		}
+		buf := make([]byte, 8)
+		n, err := putVarint(buf, v)
+		if err != nil {
+			t.Fatalf("putVarint(%#x): %v", v, err)
+		}
+		if n != varintLen(v) {
+			t.Fatalf("putVarint(%#x) wrote %d bytes, varintLen says %d", v, n, varintLen(v))
+		}
+		got, consumed, err := parseVarint(buf[:n])
+		if err != nil {
+			t.Fatalf("parseVarint of %#x: %v", v, err)
+		}
+		if got != v || consumed != n {
+			t.Fatalf("round-trip of %#x = %#x, consumed %d of %d", v, got, consumed, n)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+// Package quictest provides simulated network conditions for deterministic
+// QUIC tests: a net.PacketConn wrapper that drops, delays and reorders
+// datagrams on their way to the real socket.
+package quictest
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn wraps a net.PacketConn and impairs outgoing traffic. Reads pass
+// through untouched, so wrapping both endpoints of an exchange impairs both
+// directions. The zero rates leave traffic intact; fields must be set before
+// the Conn is used and not changed afterwards.
+type Conn struct {
+	net.PacketConn
+
+	// LossRate is the probability in [0, 1] that a written datagram is
+	// silently dropped.
+	LossRate float64
+	// ReorderRate is the probability that a written datagram is held back
+	// and delivered after the following one.
+	ReorderRate float64
+	// Latency is an added one-way delay for every delivered datagram.
+	Latency time.Duration
+
+	mutex sync.Mutex
+	rng   *rand.Rand
+	held  *datagram
+}
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// NewConn wraps pc with the given deterministic seed. Impairment rates start
+// at zero; set the exported fields before use.
+func NewConn(pc net.PacketConn, seed int64) *Conn {
+	return &Conn{
+		PacketConn: pc,
+		rng:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// WriteTo applies loss, reordering and latency before handing the datagram
+// to the wrapped socket. It always reports the full length as written, as a
+// lossy network would.
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	drop := c.rng.Float64() < c.LossRate
+	hold := !drop && c.held == nil && c.rng.Float64() < c.ReorderRate
+	var due []datagram
+	if hold {
+		c.held = &datagram{data: append([]byte(nil), p...), addr: addr}
+	} else if !drop {
+		due = append(due, datagram{data: append([]byte(nil), p...), addr: addr})
+		if c.held != nil {
+			due = append(due, *c.held)
+			c.held = nil
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, d := range due {
+		c.deliver(d)
+	}
+	return len(p), nil
+}
+
+// deliver writes one datagram to the wrapped socket after the configured
+// latency.
+func (c *Conn) deliver(d datagram) {
+	if c.Latency <= 0 {
+		c.PacketConn.WriteTo(d.data, d.addr)
+		return
+	}
+	time.AfterFunc(c.Latency, func() {
+		c.PacketConn.WriteTo(d.data, d.addr)
+	})
+}
@@ -0,0 +1,63 @@
+package quic
+
+import "net"
+
+// rawConn is the socket surface connections and the transport need. A
+// *net.UDPConn satisfies it directly; any other net.PacketConn is lifted by
+// wrapPacketConn, trading away ECN support.
+type rawConn interface {
+	ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+	WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// wrapPacketConn returns conn itself for UDP sockets and a generic adapter
+// for everything else, such as the simulated transports in quictest.
+func wrapPacketConn(conn net.PacketConn) rawConn {
+	if udp, ok := conn.(*net.UDPConn); ok {
+		return udp
+	}
+	return &packetConnAdapter{conn: conn}
+}
+
+// packetConnAdapter lifts a generic net.PacketConn to rawConn. Control
+// messages are dropped on both paths: ECN needs a real UDP socket.
+type packetConnAdapter struct {
+	conn net.PacketConn
+}
+
+func (a *packetConnAdapter) ReadMsgUDP(b, oob []byte) (int, int, int, *net.UDPAddr, error) {
+	n, addr, err := a.conn.ReadFrom(b)
+	return n, 0, 0, asUDPAddr(addr), err
+}
+
+func (a *packetConnAdapter) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (int, int, error) {
+	n, err := a.conn.WriteTo(b, addr)
+	return n, 0, err
+}
+
+func (a *packetConnAdapter) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return a.conn.WriteTo(b, addr)
+}
+
+func (a *packetConnAdapter) LocalAddr() net.Addr { return a.conn.LocalAddr() }
+func (a *packetConnAdapter) Close() error        { return a.conn.Close() }
+
+// asUDPAddr coerces an address to *net.UDPAddr, resolving the textual form
+// of addresses from non-UDP transports.
+func asUDPAddr(addr net.Addr) *net.UDPAddr {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a
+	case nil:
+		return nil
+	default:
+		udp, err := net.ResolveUDPAddr("udp", a.String())
+		if err != nil {
+			return nil
+		}
+		return udp
+	}
+}
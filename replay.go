@@ -0,0 +1,47 @@
+package quic
+
+import "sync"
+
+// replayWindow is a sliding anti-replay window over packet numbers: it
+// tracks the highest number seen and a bitmap of the 64 numbers below it,
+// in the style of RFC 4303 section 3.4.3.
+type replayWindow struct {
+	mutex   sync.Mutex
+	highest uint64
+	bitmap  uint64
+	started bool
+}
+
+// Seen records pn and reports whether it was already recorded. Numbers more
+// than 64 below the highest seen are conservatively treated as replays.
+func (w *replayWindow) Seen(pn uint64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.started {
+		w.started = true
+		w.highest = pn
+		w.bitmap = 1
+		return false
+	}
+	switch {
+	case pn > w.highest:
+		if shift := pn - w.highest; shift >= 64 {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.highest = pn
+		return false
+	case w.highest-pn >= 64:
+		return true
+	default:
+		bit := uint64(1) << (w.highest - pn)
+		if w.bitmap&bit != 0 {
+			return true
+		}
+		w.bitmap |= bit
+		return false
+	}
+}
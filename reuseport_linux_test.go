@@ -0,0 +1,29 @@
+//go:build linux
+
+package quic
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestReusePortBindsTwoListeners(t *testing.T) {
+	cfg := &Config{ReusePort: true}
+	first, err := ListenAddr("127.0.0.1:0", nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	port := first.Addr().(*net.UDPAddr).Port
+	second, err := ListenAddr(fmt.Sprintf("127.0.0.1:%d", port), nil, cfg)
+	if err != nil {
+		t.Fatalf("second listener on shared port: %v", err)
+	}
+	defer second.Close()
+
+	if got := second.Addr().(*net.UDPAddr).Port; got != port {
+		t.Errorf("second listener bound port %d, want %d", got, port)
+	}
+}
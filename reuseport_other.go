@@ -0,0 +1,14 @@
+//go:build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+// listenReusePort is unsupported on this platform; Config.ReusePort cannot
+// be honored.
+func listenReusePort(network, addr string) (*net.UDPConn, error) {
+	return nil, errors.New("SO_REUSEPORT not supported on this platform")
+}
@@ -0,0 +1,77 @@
+package quic
+
+import (
+	"sync"
+	"time"
+)
+
+// RTTStats tracks round-trip time estimates following RFC 9002 section 5.
+type RTTStats struct {
+	mutex       sync.RWMutex
+	hasSample   bool
+	latestRTT   time.Duration
+	minRTT      time.Duration
+	smoothedRTT time.Duration
+	rttVar      time.Duration
+}
+
+// UpdateRTT folds a new RTT sample into the estimator. ackDelay is the time
+// the peer reports having held the acknowledgment back; it is subtracted from
+// the sample so that delayed ACKs do not inflate the RTT estimate.
+func (r *RTTStats) UpdateRTT(sample, ackDelay time.Duration) {
+	if sample <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Only subtract the ack delay if doing so does not take the sample
+	// below the minimum RTT (RFC 9002 section 5.3).
+	if ackDelay > 0 && sample-ackDelay >= r.minRTT {
+		sample -= ackDelay
+	}
+	r.latestRTT = sample
+	if !r.hasSample {
+		r.hasSample = true
+		r.minRTT = sample
+		r.smoothedRTT = sample
+		r.rttVar = sample / 2
+		return
+	}
+	if sample < r.minRTT {
+		r.minRTT = sample
+	}
+	diff := r.smoothedRTT - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	r.rttVar = (3*r.rttVar + diff) / 4
+	r.smoothedRTT = (7*r.smoothedRTT + sample) / 8
+}
+
+// SmoothedRTT returns the exponentially weighted RTT estimate.
+func (r *RTTStats) SmoothedRTT() time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.smoothedRTT
+}
+
+// LatestRTT returns the most recent RTT sample.
+func (r *RTTStats) LatestRTT() time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.latestRTT
+}
+
+// MinRTT returns the minimum RTT observed on this connection.
+func (r *RTTStats) MinRTT() time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.minRTT
+}
+
+// RTTVariation returns the mean deviation of RTT samples.
+func (r *RTTStats) RTTVariation() time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.rttVar
+}
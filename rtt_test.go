@@ -0,0 +1,66 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTExcludesAckDelay(t *testing.T) {
+	r := &RTTStats{}
+	r.UpdateRTT(100*time.Millisecond, 0)
+	if got := r.LatestRTT(); got != 100*time.Millisecond {
+		t.Fatalf("LatestRTT = %v, want 100ms", got)
+	}
+
+	// A 150ms sample with 40ms of reported ack delay is a 110ms RTT.
+	r.UpdateRTT(150*time.Millisecond, 40*time.Millisecond)
+	if got := r.LatestRTT(); got != 110*time.Millisecond {
+		t.Errorf("LatestRTT = %v, want 110ms", got)
+	}
+}
+
+func TestRTTAckDelayClampedToMinRTT(t *testing.T) {
+	r := &RTTStats{}
+	r.UpdateRTT(100*time.Millisecond, 0)
+	// The ack delay must not push the sample below the minimum RTT.
+	r.UpdateRTT(110*time.Millisecond, 50*time.Millisecond)
+	if got := r.LatestRTT(); got != 110*time.Millisecond {
+		t.Errorf("LatestRTT = %v, want 110ms (ack delay ignored)", got)
+	}
+}
+
+func TestAckDelayEncoding(t *testing.T) {
+	tracker := newReceivedPacketTracker(defaultAckDelayExponent, 0)
+	tracker.OnPacketReceived(1)
+	tracker.mutex.Lock()
+	tracker.largestRecvTime = time.Now().Add(-8 * time.Millisecond)
+	tracker.mutex.Unlock()
+
+	frame := tracker.BuildAckFrame(0)
+	// 8ms is 8000us, scaled down by 2^3 on the wire.
+	if frame.AckDelay < 1000 || frame.AckDelay > 1100 {
+		t.Errorf("AckDelay = %d, want roughly 1000", frame.AckDelay)
+	}
+}
+
+func TestConnectionRTTAccessors(t *testing.T) {
+	c := &Connection{rttStats: &RTTStats{}}
+	c.rttStats.UpdateRTT(100*time.Millisecond, 0)
+	c.rttStats.UpdateRTT(60*time.Millisecond, 0)
+
+	if got := c.MinRTT(); got != 60*time.Millisecond {
+		t.Errorf("MinRTT = %v, want 60ms", got)
+	}
+	if got := c.SmoothedRTT(); got != 95*time.Millisecond {
+		t.Errorf("SmoothedRTT = %v, want 95ms", got)
+	}
+	if got := c.RTTVariation(); got <= 0 {
+		t.Errorf("RTTVariation = %v, want > 0", got)
+	}
+
+	stats := c.GetStats()
+	if stats.SmoothedRTT != c.SmoothedRTT() || stats.MinRTT != c.MinRTT() || stats.RTTVar != c.RTTVariation() {
+		t.Errorf("stats RTT fields %v/%v/%v do not match accessors",
+			stats.SmoothedRTT, stats.MinRTT, stats.RTTVar)
+	}
+}
@@ -0,0 +1,210 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/phuhao00/QUIC/crypto"
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// ErrListenerClosed is returned by Accept after the listener is closed.
+var ErrListenerClosed = errors.New("listener closed")
+
+// Listener accepts incoming QUIC connections. The underlying transport's
+// read loop routes datagrams to the owning connection; the listener only
+// handles Initial packets for unknown connection IDs.
+type Listener struct {
+	transport     *Transport
+	ownsTransport bool
+	tlsConf       *tls.Config
+	config        *Config
+
+	// tokens validates Retry tokens; nil unless the config requires
+	// address validation.
+	tokens *crypto.TokenManager
+
+	connsMutex sync.Mutex
+	conns      map[string]*Connection
+
+	acceptQueue chan *Connection
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// ListenAddr starts a listener on the given UDP address with its own
+// transport.
+func ListenAddr(addr string, tlsConf *tls.Config, config *Config) (*Listener, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config = populateConfig(config)
+	udpAddr, err := net.ResolveUDPAddr(config.Network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", addr, err)
+	}
+	var udpConn *net.UDPConn
+	if config.ReusePort {
+		udpConn, err = listenReusePort(config.Network, udpAddr.String())
+	} else {
+		udpConn, err = net.ListenUDP(config.Network, udpAddr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	setSocketBuffers(udpConn, config)
+	t := &Transport{Conn: udpConn}
+	l, err := t.Listen(tlsConf, config)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	l.ownsTransport = true
+	return l, nil
+}
+
+// Addr returns the listener's local address.
+func (l *Listener) Addr() net.Addr {
+	return l.transport.udpConn.LocalAddr()
+}
+
+// Accept waits for the next incoming connection.
+func (l *Listener) Accept(ctx context.Context) (*Connection, error) {
+	select {
+	case conn := <-l.acceptQueue:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+// AcceptWithInfo waits for the next incoming connection and additionally
+// returns what its client announced in the ClientHello, for routing and
+// logging on multi-tenant servers.
+func (l *Listener) AcceptWithInfo(ctx context.Context) (*Connection, ClientHelloInfo, error) {
+	conn, err := l.Accept(ctx)
+	if err != nil {
+		return nil, ClientHelloInfo{}, err
+	}
+	info := ClientHelloInfo{
+		ServerName:       conn.tlsManager.ServerName(),
+		OfferedProtocols: conn.tlsManager.OfferedProtocols(),
+		RemoteAddr:       conn.RemoteAddr(),
+	}
+	return conn, info, nil
+}
+
+// Close shuts the listener down and closes all accepted connections. If the
+// listener owns its transport, the socket is closed as well.
+func (l *Listener) Close() error {
+	l.shutdown()
+	if l.ownsTransport {
+		l.transport.Close()
+	}
+	return nil
+}
+
+// shutdown stops accepting and closes the listener's connections without
+// touching the transport, so Transport.Close can call it re-entrantly.
+func (l *Listener) shutdown() {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.connsMutex.Lock()
+		conns := make([]*Connection, 0, len(l.conns))
+		for _, c := range l.conns {
+			conns = append(conns, c)
+		}
+		l.connsMutex.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+}
+
+// acceptInitial creates a server connection for an Initial packet with an
+// unknown destination connection ID.
+func (l *Listener) acceptInitial(header *packet.Header, data []byte, addr *net.UDPAddr) {
+	select {
+	case <-l.closed:
+		return
+	default:
+	}
+	if l.tokens != nil {
+		if err := l.tokens.ValidateToken(header.Token, addr.IP); err != nil {
+			l.sendRetry(header, addr)
+			return
+		}
+	}
+	conn, err := l.newServerConnection(header, addr)
+	if err != nil {
+		return
+	}
+	conn.handleDatagram(data)
+	select {
+	case l.acceptQueue <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// retryTokenLifetime is how long a minted Retry token stays valid. Tokens are
+// echoed within one round trip, so the window only needs to absorb retries.
+const retryTokenLifetime = 30 * time.Second
+
+// sendRetry answers an unvalidated Initial with a Retry carrying a fresh
+// token. The source connection ID echoes the client's original destination ID
+// so the retried Initial derives the same keys.
+func (l *Listener) sendRetry(header *packet.Header, addr *net.UDPAddr) {
+	retry := &packet.Header{
+		Type:       packet.PacketTypeRetry,
+		Version:    packet.Version1,
+		DestConnID: header.SrcConnID,
+		SrcConnID:  header.DestConnID,
+		Token:      l.tokens.MintToken(addr.IP),
+	}
+	data, err := retry.Serialize()
+	if err != nil {
+		return
+	}
+	l.transport.udpConn.WriteToUDP(data, addr)
+}
+
+func (l *Listener) newServerConnection(header *packet.Header, addr *net.UDPAddr) (*Connection, error) {
+	localConnID, err := generateConnectionIDFor(l.config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newConnection(l.transport.udpConn, addr, false, false, l.config, l.tlsConf,
+		localConnID, header.SrcConnID, header.DestConnID)
+	if err != nil {
+		return nil, err
+	}
+	// The client addresses us by its original destination connection ID for
+	// the connection's lifetime, so route on that.
+	key := string(header.DestConnID)
+	conn.onClosed = func(c *Connection) {
+		l.transport.removeConn(key)
+		l.connsMutex.Lock()
+		delete(l.conns, key)
+		l.connsMutex.Unlock()
+	}
+	if err := l.transport.addConn(key, conn); err != nil {
+		return nil, err
+	}
+	l.connsMutex.Lock()
+	if l.conns == nil {
+		l.conns = make(map[string]*Connection)
+	}
+	l.conns[key] = conn
+	l.connsMutex.Unlock()
+	conn.Start()
+	return conn, nil
+}
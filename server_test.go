@@ -0,0 +1,249 @@
+package quic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// generateTestCert returns a self-signed certificate valid for the given DNS
+// name.
+func generateTestCert(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestGetConfigForClientAppliesPerSNILimits(t *testing.T) {
+	const bulkWindow = 2 * 1024 * 1024
+	infos := make(chan ClientHelloInfo, 2)
+	cfg := &Config{
+		GetConfigForClient: func(info ClientHelloInfo) (*Config, error) {
+			infos <- info
+			if info.ServerName == "bulk.example" {
+				return &Config{InitialStreamReceiveWindow: bulkWindow}, nil
+			}
+			return nil, nil
+		},
+	}
+	ln, err := ListenAddr("127.0.0.1:0", nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	// sendLimit dials with the given SNI and returns the per-stream send
+	// window the server advertised.
+	sendLimit := func(serverName string) uint64 {
+		conn, err := DialAddr(ln.Addr().String(),
+			&tls.Config{InsecureSkipVerify: true, ServerName: serverName}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		s, err := conn.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.sendMutex.Lock()
+		defer s.sendMutex.Unlock()
+		return s.maxStreamData
+	}
+
+	if got := sendLimit("bulk.example"); got != bulkWindow {
+		t.Errorf("send limit for bulk.example = %d, want %d", got, bulkWindow)
+	}
+	if got := sendLimit("other.example"); got != defaultInitialStreamWindow {
+		t.Errorf("send limit for other.example = %d, want %d", got, defaultInitialStreamWindow)
+	}
+
+	for _, want := range []string{"bulk.example", "other.example"} {
+		select {
+		case info := <-infos:
+			if info.ServerName != want {
+				t.Errorf("ClientHelloInfo.ServerName = %q, want %q", info.ServerName, want)
+			}
+			if info.RemoteAddr == nil {
+				t.Error("ClientHelloInfo.RemoteAddr is nil")
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("GetConfigForClient not invoked for %s", want)
+		}
+	}
+}
+
+func TestSNISelectsCertificate(t *testing.T) {
+	alpha := generateTestCert(t, "alpha.example")
+	beta := generateTestCert(t, "beta.example")
+	tlsConf := &tls.Config{
+		GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if chi.ServerName == "beta.example" {
+				return &beta, nil
+			}
+			return &alpha, nil
+		},
+	}
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true, ServerName: "beta.example"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	certs := conn.tlsManager.PeerCertificates()
+	if len(certs) != 1 || certs[0].Subject.CommonName != "beta.example" {
+		t.Errorf("client received certificate %+v, want CN beta.example", certs)
+	}
+	if name := conn.ConnectionState().ServerName; name != "beta.example" {
+		t.Errorf("client ServerName = %q, want beta.example", name)
+	}
+
+	select {
+	case server := <-accepted:
+		if name := server.ConnectionState().ServerName; name != "beta.example" {
+			t.Errorf("server ServerName = %q, want beta.example", name)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not accept the connection")
+	}
+}
+
+func TestRequireAddressValidationRetries(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, &Config{RequireAddressValidation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go echoFirstStream(conn)
+		}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The handshake can only have completed via the token-bearing retry: the
+	// first Initial is answered with a Retry, never a connection.
+	if conn.currentRetryToken() == nil {
+		t.Error("client holds no Retry token after connecting")
+	}
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("validated")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "validated" {
+		t.Errorf("echoed %q, want %q", buf, "validated")
+	}
+}
+
+func TestAcceptWithInfoReportsClientHello(t *testing.T) {
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		conn *Connection
+		info ClientHelloInfo
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, info, err := ln.AcceptWithInfo(context.Background())
+		accepted <- result{conn, info, err}
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "tenant.example",
+		NextProtos:         []string{"h3", "hq-interop"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	res := <-accepted
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer res.conn.Close()
+	if res.info.ServerName != "tenant.example" {
+		t.Errorf("ServerName = %q, want %q", res.info.ServerName, "tenant.example")
+	}
+	if len(res.info.OfferedProtocols) != 2 ||
+		res.info.OfferedProtocols[0] != "h3" || res.info.OfferedProtocols[1] != "hq-interop" {
+		t.Errorf("OfferedProtocols = %v, want [h3 hq-interop]", res.info.OfferedProtocols)
+	}
+	// The client binds a wildcard socket, so only the port is comparable.
+	if res.info.RemoteAddr == nil ||
+		res.info.RemoteAddr.(*net.UDPAddr).Port != conn.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("RemoteAddr = %v, want port %d", res.info.RemoteAddr, conn.LocalAddr().(*net.UDPAddr).Port)
+	}
+}
@@ -0,0 +1,31 @@
+package quic
+
+import "net"
+
+// setSocketBuffers applies the configured kernel buffer sizes to the socket.
+// When the OS grants less than requested — rmem_max and wmem_max cap the
+// request on Linux — the clamp is reported through the tracer so operators
+// can tell silent packet drops from a short buffer apart.
+func setSocketBuffers(conn *net.UDPConn, config *Config) {
+	report := func(kind string, requested, granted uint64) {
+		if t := config.ConnectionTracer; t != nil && t.UDPBufferClamped != nil && granted < requested {
+			t.UDPBufferClamped(kind, requested, granted)
+		}
+	}
+	if size := config.ReceiveBufferSize; size > 0 {
+		if err := conn.SetReadBuffer(int(size)); err != nil {
+			return
+		}
+		if granted, err := socketBufferSize(conn, true); err == nil {
+			report("receive", size, granted)
+		}
+	}
+	if size := config.SendBufferSize; size > 0 {
+		if err := conn.SetWriteBuffer(int(size)); err != nil {
+			return
+		}
+		if granted, err := socketBufferSize(conn, false); err == nil {
+			report("send", size, granted)
+		}
+	}
+}
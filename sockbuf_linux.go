@@ -0,0 +1,33 @@
+//go:build linux
+
+package quic
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketBufferSize reads back the socket's receive or send buffer size. The
+// kernel doubles the value set for bookkeeping overhead and reports the
+// doubled amount, so it is halved here to compare against the request.
+func socketBufferSize(conn *net.UDPConn, receive bool) (uint64, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	opt := syscall.SO_SNDBUF
+	if receive {
+		opt = syscall.SO_RCVBUF
+	}
+	var size int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		size, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	}); err != nil {
+		return 0, err
+	}
+	if sockErr != nil {
+		return 0, sockErr
+	}
+	return uint64(size / 2), nil
+}
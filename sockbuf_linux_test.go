@@ -0,0 +1,51 @@
+//go:build linux
+
+package quic
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReceiveBufferSizeAppliedOrClampReported(t *testing.T) {
+	// Far above the usual rmem_max, so either the kernel grants it or the
+	// tracer must report the clamp.
+	const requested = 64 << 20
+
+	type clamp struct {
+		kind      string
+		requested uint64
+		granted   uint64
+	}
+	var clamps []clamp
+	ln, err := ListenAddr("127.0.0.1:0", nil, &Config{
+		ReceiveBufferSize: requested,
+		ConnectionTracer: &ConnectionTracer{
+			UDPBufferClamped: func(kind string, requested, granted uint64) {
+				clamps = append(clamps, clamp{kind, requested, granted})
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	granted, err := socketBufferSize(ln.transport.Conn.(*net.UDPConn), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clamps) == 0 {
+		if granted < requested {
+			t.Fatalf("buffer is %d bytes, %d requested, and no clamp was reported", granted, requested)
+		}
+		return
+	}
+	c := clamps[0]
+	if c.kind != "receive" || c.requested != requested {
+		t.Errorf("clamp report = %+v, want kind \"receive\" and requested %d", c, requested)
+	}
+	if c.granted >= requested {
+		t.Errorf("clamp reported granted %d, which is not below the requested %d", c.granted, requested)
+	}
+}
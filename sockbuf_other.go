@@ -0,0 +1,14 @@
+//go:build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+// socketBufferSize cannot be read back on this platform, so clamping goes
+// unreported; the buffer sizes are still applied best effort.
+func socketBufferSize(conn *net.UDPConn, receive bool) (uint64, error) {
+	return 0, errors.New("socket buffer introspection not supported on this platform")
+}
@@ -0,0 +1,26 @@
+package quic
+
+import "time"
+
+// ConnectionStats is a point-in-time snapshot of connection counters.
+type ConnectionStats struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+	StreamsOpened   uint64
+	// LastPacketSentAt is the send timestamp of the most recently sent
+	// packet, so applications measuring one-way delay can correlate their
+	// probes with when the wire actually carried them.
+	LastPacketSentAt time.Time
+	// SendRate is the observed average send rate in bytes per second.
+	SendRate    uint64
+	SmoothedRTT time.Duration
+	MinRTT      time.Duration
+	RTTVar      time.Duration
+	// Congestion controller state at the time of the snapshot.
+	CongestionWindow uint64
+	BytesInFlight    uint64
+	SlowStart        bool
+	Recovery         bool
+}
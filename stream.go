@@ -0,0 +1,956 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// StreamState is the lifecycle state of a stream.
+type StreamState int
+
+const (
+	StateOpen StreamState = iota
+	StateHalfClosedLocal
+	StateHalfClosedRemote
+	StateClosed
+	StateReset
+)
+
+func (s StreamState) String() string {
+	switch s {
+	case StateOpen:
+		return "Open"
+	case StateHalfClosedLocal:
+		return "HalfClosedLocal"
+	case StateHalfClosedRemote:
+		return "HalfClosedRemote"
+	case StateClosed:
+		return "Closed"
+	case StateReset:
+		return "Reset"
+	default:
+		return "unknown"
+	}
+}
+
+// Stream is a bidirectional QUIC stream. Reads and writes are buffered; the
+// connection's send loop drains the send buffer and the receive path fills
+// the reassembly buffer.
+type Stream struct {
+	id   uint64
+	conn *Connection
+
+	// ctx is cancelled when the stream is closed or reset; a reset carries
+	// a *StreamError as the cancellation cause.
+	ctx       context.Context
+	cancelCtx context.CancelCauseFunc
+
+	// The two directions of a bidirectional stream close independently:
+	// sendClosed is set by a local Close, recvClosed by the peer's FIN. The
+	// visible StreamState is derived from the pair; state itself only holds
+	// the terminal Reset and Closed-by-teardown values.
+	stateMutex sync.Mutex
+	state      StreamState
+	sendClosed bool
+	recvClosed bool
+	closeErr   error // set when the owning connection is torn down
+
+	// Receive side. recvBuffer maps stream offsets to data chunks awaiting
+	// in-order delivery to the application.
+	recvBufferMutex sync.Mutex
+	recvBuffer      map[uint64][]byte
+	readOffset      uint64
+	finReceived     bool
+	finalSize       uint64
+	finalSizeKnown  bool // a FIN or RESET_STREAM fixed finalSize
+	recvData        uint64
+	readData        uint64 // bytes the application has consumed in order
+	maxData         uint64 // highest receive offset we advertise to the peer
+	recvWindow      uint64 // window size used when extending maxData
+	readCond        *sync.Cond
+	// readScratch is reused by Read to assemble in-order data, so steady
+	// reads do not allocate. It is only valid while recvBufferMutex is held.
+	readScratch []byte
+	// Debug checksum state (Config.DebugStreamChecksums): the CRC-32 over
+	// consumed bytes and the sender's expected value, pending until the
+	// covered bytes have been read.
+	recvChecksum uint32
+	expectSumSet bool
+	expectSumAt  uint64
+	expectSum    uint32
+	// gapStart is when a hole at the read offset began stalling in-order
+	// delivery; zero while delivery is not stalled.
+	gapStart time.Time
+
+	// Send side.
+	sendMutex    sync.Mutex
+	sendBuffer   []byte
+	sendOffset   uint64
+	sendFinished bool
+	finSent      bool // a frame carrying the FIN has been handed out
+	sentData     uint64
+	ackedData    uint64 // bytes the peer has acknowledged
+	// checksums enables the debug stream checksums; sendChecksum is the
+	// CRC-32 over data handed to the send loop so far.
+	checksums     bool
+	sendChecksum  uint32
+	maxStreamData uint64 // send limit imposed by the peer
+	writeCond     *sync.Cond
+	// noDelay disables write coalescing; it defaults to true, matching
+	// net.TCPConn. coalesceUntil holds small writes back while coalescing.
+	noDelay       bool
+	coalesceUntil time.Time
+	// linger controls how Close treats data the peer has not acknowledged;
+	// see SetLinger. lingerSet distinguishes a zero linger from the
+	// default.
+	linger    time.Duration
+	lingerSet bool
+	// sendBacking, when pre-sized via SetBuffers, is the array the send
+	// buffer returns to whenever it drains, so steady writes reuse one
+	// allocation.
+	sendBacking []byte
+	// retransBuffer holds sent-but-unacknowledged data; OnAck releases it
+	// and OnLoss moves it to lostQueue for retransmission.
+	retransBuffer []sentRange
+	lostQueue     []sentRange
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	resetErrorCode uint64
+}
+
+// sentRange is a contiguous chunk of stream data that has been handed to the
+// send loop but not yet acknowledged by the peer.
+type sentRange struct {
+	offset uint64
+	data   []byte
+	sentAt time.Time // when the range was last handed to the send loop
+}
+
+// StreamStats is a snapshot of a stream's byte counters. The buffered
+// counters grow as data enters the stream's buffers; the delivered ones lag
+// behind until the peer acknowledges sent data or the application reads
+// received data.
+type StreamStats struct {
+	// BytesSent counts data handed to the send loop.
+	BytesSent uint64
+	// BytesAcked counts sent data the peer has acknowledged.
+	BytesAcked uint64
+	// BytesReceived counts data buffered from the peer.
+	BytesReceived uint64
+	// BytesRead counts data the application has consumed.
+	BytesRead uint64
+}
+
+// GetStats returns a snapshot of the stream's byte counters.
+func (s *Stream) GetStats() StreamStats {
+	var stats StreamStats
+	s.sendMutex.Lock()
+	stats.BytesSent = s.sentData
+	stats.BytesAcked = s.ackedData
+	s.sendMutex.Unlock()
+	s.recvBufferMutex.Lock()
+	stats.BytesReceived = s.recvData
+	stats.BytesRead = s.readData
+	s.recvBufferMutex.Unlock()
+	return stats
+}
+
+func newStream(id uint64, conn *Connection, recvWindow, sendWindow uint64) *Stream {
+	s := &Stream{
+		id:            id,
+		conn:          conn,
+		state:         StateOpen,
+		recvBuffer:    make(map[uint64][]byte),
+		maxData:       recvWindow,
+		recvWindow:    recvWindow,
+		noDelay:       true,
+		maxStreamData: sendWindow,
+	}
+	s.ctx, s.cancelCtx = context.WithCancelCause(context.Background())
+	s.readCond = sync.NewCond(&s.recvBufferMutex)
+	s.writeCond = sync.NewCond(&s.sendMutex)
+	if conn != nil {
+		s.SetBuffers(int(conn.config.StreamReadBufferSize), int(conn.config.StreamWriteBufferSize))
+		s.checksums = conn.config.DebugStreamChecksums
+	}
+	return s
+}
+
+// SetBuffers pre-sizes the stream's internal buffers: read is the capacity of
+// the scratch buffer Read assembles in-order data into, write the capacity
+// the send buffer keeps across drains. Streams start with the connection's
+// Config.StreamReadBufferSize and StreamWriteBufferSize; zero leaves the
+// corresponding buffer growing on demand.
+func (s *Stream) SetBuffers(read, write int) {
+	if read > 0 {
+		s.recvBufferMutex.Lock()
+		s.readScratch = make([]byte, 0, read)
+		s.recvBufferMutex.Unlock()
+	}
+	if write > 0 {
+		s.sendMutex.Lock()
+		s.sendBacking = make([]byte, 0, write)
+		if len(s.sendBuffer) == 0 {
+			s.sendBuffer = s.sendBacking
+		}
+		s.sendMutex.Unlock()
+	}
+}
+
+// Context returns a context that is cancelled when the stream is closed or
+// reset. For a reset, the cancellation cause (see context.Cause) is a
+// *StreamError carrying the reset error code.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamID returns the stream's identifier.
+func (s *Stream) StreamID() uint64 {
+	return s.id
+}
+
+// State returns the current stream state.
+func (s *Stream) State() StreamState {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.stateLocked()
+}
+
+func (s *Stream) stateLocked() StreamState {
+	switch {
+	case s.state == StateReset || s.state == StateClosed:
+		return s.state
+	case s.sendClosed && s.recvClosed:
+		return StateClosed
+	case s.sendClosed:
+		return StateHalfClosedLocal
+	case s.recvClosed:
+		return StateHalfClosedRemote
+	default:
+		return StateOpen
+	}
+}
+
+func (s *Stream) setState(state StreamState) {
+	s.stateMutex.Lock()
+	s.state = state
+	s.stateMutex.Unlock()
+}
+
+// closeSendSide marks the local half finished and reports whether both halves
+// are now done.
+func (s *Stream) closeSendSide() bool {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.sendClosed = true
+	return s.stateLocked() == StateClosed
+}
+
+// closeRecvSide marks the peer's half finished and reports whether both
+// halves are now done.
+func (s *Stream) closeRecvSide() bool {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.recvClosed = true
+	return s.stateLocked() == StateClosed
+}
+
+// connError returns the owning connection's close error, if the connection
+// has been torn down.
+func (s *Stream) connError() error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.closeErr
+}
+
+// closeWithError terminates both directions because the owning connection is
+// going away, unblocking any Read, Write or Flush calls with err.
+func (s *Stream) closeWithError(err error) {
+	s.stateMutex.Lock()
+	if s.closeErr == nil {
+		s.closeErr = err
+	}
+	s.state = StateClosed
+	s.stateMutex.Unlock()
+
+	s.sendMutex.Lock()
+	s.sendFinished = true
+	s.finSent = true // nothing further goes out, not even a bare FIN
+	s.sendBuffer = nil
+	s.retransBuffer = nil
+	s.lostQueue = nil
+	s.writeCond.Broadcast()
+	s.sendMutex.Unlock()
+
+	s.recvBufferMutex.Lock()
+	s.readCond.Broadcast()
+	s.recvBufferMutex.Unlock()
+
+	s.cancelCtx(err)
+}
+
+// Read reads in-order stream data into p, blocking until data is available,
+// the peer finishes the stream, or the deadline passes. Peeking, copying and
+// consuming happen under one hold of recvBufferMutex, so concurrent readers
+// each receive distinct bytes.
+func (s *Stream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	s.recvBufferMutex.Lock()
+	defer s.recvBufferMutex.Unlock()
+
+	for {
+		if err := s.connError(); err != nil {
+			return 0, err
+		}
+
+		// Buffered in-order data is always delivered first; EOF or a reset
+		// error is only reported once delivered data has been consumed.
+		data := s.getOrderedDataLocked(len(p))
+		if len(data) > 0 {
+			n := copy(p, data)
+			s.consumeDataLocked(n)
+			if s.checksums {
+				s.recvChecksum = crc32.Update(s.recvChecksum, crc32.IEEETable, p[:n])
+				s.checkRecvChecksumLocked()
+			}
+			return n, nil
+		}
+
+		if s.State() == StateReset {
+			return 0, &StreamError{StreamID: s.id, ErrorCode: s.resetErrorCode}
+		}
+		if s.finReceived && s.readOffset >= s.finalSize {
+			return 0, io.EOF
+		}
+		if !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		s.readCond.Wait()
+	}
+}
+
+// handleChecksum records the sender's debug checksum over the stream's data
+// and verifies it once the covered bytes have been consumed.
+func (s *Stream) handleChecksum(finalSize uint64, sum uint32) {
+	s.recvBufferMutex.Lock()
+	defer s.recvBufferMutex.Unlock()
+	s.expectSumSet = true
+	s.expectSumAt = finalSize
+	s.expectSum = sum
+	s.checkRecvChecksumLocked()
+}
+
+// checkRecvChecksumLocked compares the running receive checksum against the
+// sender's once the application has consumed all covered bytes, tearing the
+// connection down on a mismatch. The close runs on its own goroutine because
+// teardown re-enters the stream's locks. recvBufferMutex must be held.
+func (s *Stream) checkRecvChecksumLocked() {
+	if !s.expectSumSet || s.readOffset < s.expectSumAt {
+		return
+	}
+	s.expectSumSet = false
+	if s.recvChecksum != s.expectSum && s.conn != nil {
+		go s.conn.closeWithProtocolViolation(packet.FrameTypeStreamChecksum, "stream checksum mismatch")
+	}
+}
+
+// getOrderedDataLocked returns up to max bytes of contiguous data starting at
+// the read offset without consuming it. recvBufferMutex must be held.
+func (s *Stream) getOrderedDataLocked(max int) []byte {
+	out := s.readScratch[:0]
+	offset := s.readOffset
+	for len(out) < max {
+		chunk, ok := s.recvBuffer[offset]
+		if !ok {
+			break
+		}
+		out = append(out, chunk...)
+		offset += uint64(len(chunk))
+	}
+	if len(out) > max {
+		out = out[:max]
+	}
+	// The scratch buffer keeps whatever growth this call caused; the caller
+	// copies out of it before releasing recvBufferMutex.
+	s.readScratch = out[:0]
+	return out
+}
+
+// consumeDataLocked advances the read offset by n bytes, dropping fully
+// consumed chunks and reslicing a partially consumed one. recvBufferMutex
+// must be held.
+func (s *Stream) consumeDataLocked(n int) {
+	remaining := uint64(n)
+	for remaining > 0 {
+		chunk, ok := s.recvBuffer[s.readOffset]
+		if !ok {
+			break
+		}
+		if uint64(len(chunk)) <= remaining {
+			delete(s.recvBuffer, s.readOffset)
+			s.readOffset += uint64(len(chunk))
+			remaining -= uint64(len(chunk))
+		} else {
+			delete(s.recvBuffer, s.readOffset)
+			s.recvBuffer[s.readOffset+remaining] = chunk[remaining:]
+			s.readOffset += remaining
+			remaining = 0
+		}
+	}
+	s.readData += uint64(n) - remaining
+	if _, ok := s.recvBuffer[s.readOffset]; !ok && len(s.recvBuffer) > 0 && s.gapStart.IsZero() {
+		// Consumption exposed a hole at the read offset; time the stall for
+		// the tracer's reassembly-gap samples.
+		s.gapStart = time.Now()
+	}
+	if s.conn != nil {
+		s.conn.onStreamDataConsumed(s)
+	}
+}
+
+// SetReceiveWindow changes the stream's receive window, e.g. to give a bulk
+// transfer stream more credit than a control stream. Growing the window
+// immediately advertises the new limit to the peer with a MAX_STREAM_DATA
+// frame. The window is capped at Config.MaxStreamReceiveWindow.
+func (s *Stream) SetReceiveWindow(bytes uint64) {
+	if s.conn != nil && bytes > s.conn.config.MaxStreamReceiveWindow {
+		bytes = s.conn.config.MaxStreamReceiveWindow
+	}
+	s.recvBufferMutex.Lock()
+	s.recvWindow = bytes
+	newLimit := s.readOffset + bytes
+	grown := newLimit > s.maxData
+	if grown {
+		s.maxData = newLimit
+	}
+	s.recvBufferMutex.Unlock()
+
+	if grown && s.conn != nil {
+		s.conn.sendMaxStreamData(s.id, newLimit)
+	}
+}
+
+// errFinalSizeViolated reports data received past a stream's known final
+// size, which the peer must never send (RFC 9000 section 4.5).
+var errFinalSizeViolated = errors.New("data received past the stream's final size")
+
+// ReceiveData inserts data received from the peer into the reassembly buffer.
+func (s *Stream) ReceiveData(offset uint64, data []byte, fin bool) error {
+	state := s.State()
+	if state == StateReset {
+		// Data racing a reset is expected — the peer may not have seen the
+		// RESET_STREAM or STOP_SENDING yet — and is dropped. Data past the
+		// known final size is a protocol violation even then.
+		s.recvBufferMutex.Lock()
+		known, final := s.finalSizeKnown, s.finalSize
+		s.recvBufferMutex.Unlock()
+		end := offset + uint64(len(data))
+		if known && (end > final || (fin && end != final)) {
+			return fmt.Errorf("stream %d: %w", s.id, errFinalSizeViolated)
+		}
+		return nil
+	}
+
+	s.recvBufferMutex.Lock()
+	if offset+uint64(len(data)) > s.maxData {
+		s.recvBufferMutex.Unlock()
+		return fmt.Errorf("stream %d flow control violated: %d > %d",
+			s.id, offset+uint64(len(data)), s.maxData)
+	}
+	if len(data) > 0 && offset+uint64(len(data)) > s.readOffset {
+		if _, exists := s.recvBuffer[offset]; !exists {
+			s.recvBuffer[offset] = append([]byte(nil), data...)
+			s.recvData += uint64(len(data))
+		}
+	}
+	if fin {
+		s.finReceived = true
+		s.finalSize = offset + uint64(len(data))
+		s.finalSizeKnown = true
+	}
+	// Track how long a hole at the read offset stalls in-order delivery, so
+	// the tracer can report reassembly gaps.
+	var gap time.Duration
+	if _, ok := s.recvBuffer[s.readOffset]; ok {
+		if !s.gapStart.IsZero() {
+			gap = time.Since(s.gapStart)
+			s.gapStart = time.Time{}
+		}
+	} else if len(s.recvBuffer) > 0 && s.gapStart.IsZero() {
+		s.gapStart = time.Now()
+	}
+	s.readCond.Broadcast()
+	s.recvBufferMutex.Unlock()
+
+	if gap > 0 {
+		if tracer := s.tracer(); tracer != nil && tracer.StreamReassemblyGap != nil {
+			tracer.StreamReassemblyGap(s.id, gap)
+		}
+	}
+	if fin && s.closeRecvSide() {
+		s.cancelCtx(nil)
+	}
+	return nil
+}
+
+// Coalescing parameters for streams with no-delay disabled: a small write is
+// held back until coalesceDelay has passed or coalesceBytes are buffered,
+// whichever comes first.
+const (
+	coalesceDelay = 5 * time.Millisecond
+	coalesceBytes = 1200
+)
+
+// SetNoDelay controls write coalescing. With no-delay enabled (the default),
+// every Write is eligible for transmission immediately. Disabling no-delay
+// briefly holds small writes back so several of them are batched into one
+// STREAM frame, trading a little latency for fewer packets.
+func (s *Stream) SetNoDelay(noDelay bool) {
+	s.sendMutex.Lock()
+	s.noDelay = noDelay
+	s.sendMutex.Unlock()
+}
+
+// Write buffers p for transmission and returns once it is queued. Buffering
+// is bounded by the peer's flow-control window: once that much data is
+// waiting, Write blocks until the send loop drains it or more credit
+// arrives, honouring the write deadline.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	return s.writeLocked(p)
+}
+
+// WriteBuffers queues each buffer in bufs for transmission, in order, without
+// first concatenating them into a single allocation — analogous to
+// net.Buffers.WriteTo. It returns the total number of bytes queued and stops
+// at the first error.
+func (s *Stream) WriteBuffers(bufs net.Buffers) (int64, error) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	var total int64
+	for _, b := range bufs {
+		n, err := s.writeLocked(b)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// writeLocked is the body of Write; sendMutex must be held.
+func (s *Stream) writeLocked(p []byte) (int, error) {
+	written := 0
+	for {
+		if err := s.connError(); err != nil {
+			return written, err
+		}
+		if s.State() == StateReset {
+			return written, fmt.Errorf("stream %d is reset", s.id)
+		}
+		if s.sendFinished {
+			return written, fmt.Errorf("stream %d: write after close", s.id)
+		}
+		if written == len(p) {
+			return written, nil
+		}
+
+		if room := s.sendRoomLocked(); room > 0 {
+			n := len(p) - written
+			if n > room {
+				n = room
+			}
+			if !s.noDelay && len(s.sendBuffer) == 0 {
+				s.coalesceUntil = time.Now().Add(coalesceDelay)
+			}
+			s.sendBuffer = append(s.sendBuffer, p[written:written+n]...)
+			s.sentData += uint64(n)
+			written += n
+			if s.conn != nil {
+				s.conn.signalSend()
+			}
+			continue
+		}
+
+		if !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+		s.writeCond.Wait()
+	}
+}
+
+// sendRoomLocked returns how many more bytes Write may buffer: the part of
+// the peer's flow-control window neither sent nor already sitting in the
+// buffer. Buffering past the window would only hold memory the send loop
+// cannot drain anyway.
+func (s *Stream) sendRoomLocked() int {
+	if s.maxStreamData <= s.sendOffset {
+		return 0
+	}
+	window := s.maxStreamData - s.sendOffset
+	if uint64(len(s.sendBuffer)) >= window {
+		return 0
+	}
+	return int(window) - len(s.sendBuffer)
+}
+
+// GetSendData returns up to maxSize bytes of buffered data together with its
+// stream offset and whether this is the final data of the stream. It is
+// called by the connection's send loop.
+func (s *Stream) GetSendData(maxSize int) ([]byte, uint64, bool, error) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	// Lost data is retransmitted before any new data.
+	if len(s.lostQueue) > 0 {
+		r := s.lostQueue[0]
+		data := r.data
+		if len(data) > maxSize {
+			data = data[:maxSize]
+			s.lostQueue[0] = sentRange{offset: r.offset + uint64(maxSize), data: r.data[maxSize:]}
+		} else {
+			s.lostQueue = s.lostQueue[1:]
+		}
+		s.retransBuffer = append(s.retransBuffer, sentRange{offset: r.offset, data: data, sentAt: time.Now()})
+		fin := s.sendFinished && len(s.sendBuffer) == 0 && len(s.lostQueue) == 0 &&
+			r.offset+uint64(len(data)) == s.sendOffset
+		if fin {
+			s.finSent = true
+		}
+		return data, r.offset, fin, nil
+	}
+
+	if len(s.sendBuffer) == 0 {
+		// A stream closed after its buffer drained still owes the peer a
+		// FIN; offer it once as an empty final frame.
+		if s.sendFinished && !s.finSent && s.State() != StateReset {
+			s.finSent = true
+			return nil, s.sendOffset, true, nil
+		}
+		return nil, 0, false, nil
+	}
+	// With no-delay disabled, keep a small fresh buffer around a little
+	// longer so subsequent writes can ride in the same frame. A FIN always
+	// flushes immediately.
+	if !s.noDelay && !s.sendFinished &&
+		len(s.sendBuffer) < coalesceBytes && time.Now().Before(s.coalesceUntil) {
+		return nil, 0, false, nil
+	}
+
+	n := maxSize
+	if n > len(s.sendBuffer) {
+		n = len(s.sendBuffer)
+	}
+	// Never send past the peer's flow control limit; the remainder stays
+	// buffered until a MAX_STREAM_DATA frame raises it.
+	if avail := s.maxStreamData - s.sendOffset; uint64(n) > avail {
+		n = int(avail)
+	}
+	if n <= 0 {
+		return nil, 0, false, nil
+	}
+	// Keep a private copy of the sent data until the peer acknowledges it,
+	// so OnLoss can re-queue it.
+	data := append([]byte(nil), s.sendBuffer[:n]...)
+	if s.checksums {
+		s.sendChecksum = crc32.Update(s.sendChecksum, crc32.IEEETable, data)
+	}
+	offset := s.sendOffset
+	s.sendBuffer = s.sendBuffer[n:]
+	if len(s.sendBuffer) == 0 && s.sendBacking != nil {
+		// A fully drained buffer returns to its pre-sized backing array
+		// instead of eroding capacity with every drain.
+		s.sendBuffer = s.sendBacking[:0]
+	}
+	s.sendOffset += uint64(n)
+	s.retransBuffer = append(s.retransBuffer, sentRange{offset: offset, data: data, sentAt: time.Now()})
+	fin := s.sendFinished && len(s.sendBuffer) == 0
+	if fin {
+		s.finSent = true
+	}
+	s.writeCond.Broadcast()
+	return data, offset, fin, nil
+}
+
+// SendChecksum returns the stream's send offset and the CRC-32 over the data
+// handed to the send loop so far. With Config.DebugStreamChecksums the
+// connection attaches them to the stream's final frame.
+func (s *Stream) SendChecksum() (uint64, uint32) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	return s.sendOffset, s.sendChecksum
+}
+
+// OnAck releases sent data fully covered by the acknowledged range
+// [offset, offset+length).
+func (s *Stream) OnAck(offset uint64, length int) {
+	tracer := s.tracer()
+	reportLatency := tracer != nil && tracer.StreamWriteAckLatency != nil
+
+	s.sendMutex.Lock()
+	end := offset + uint64(length)
+	kept := s.retransBuffer[:0]
+	var latencies []time.Duration
+	for _, r := range s.retransBuffer {
+		if r.offset >= offset && r.offset+uint64(len(r.data)) <= end {
+			s.ackedData += uint64(len(r.data))
+			if reportLatency && !r.sentAt.IsZero() {
+				latencies = append(latencies, time.Since(r.sentAt))
+			}
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.retransBuffer = kept
+	// A lingering Close may be waiting for the acknowledgment that just
+	// arrived.
+	s.writeCond.Broadcast()
+	s.sendMutex.Unlock()
+
+	// Tracer callbacks run outside the mutex so they may call back into the
+	// stream.
+	for _, l := range latencies {
+		tracer.StreamWriteAckLatency(s.id, l)
+	}
+}
+
+// tracer returns the owning connection's tracer, or nil for a detached
+// stream.
+func (s *Stream) tracer() *ConnectionTracer {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.config.ConnectionTracer
+}
+
+// OnLoss re-queues sent data fully covered by the lost range
+// [offset, offset+length); a subsequent GetSendData re-offers it.
+func (s *Stream) OnLoss(offset uint64, length int) {
+	s.sendMutex.Lock()
+	end := offset + uint64(length)
+	kept := s.retransBuffer[:0]
+	requeued := false
+	for _, r := range s.retransBuffer {
+		if r.offset >= offset && r.offset+uint64(len(r.data)) <= end {
+			s.lostQueue = append(s.lostQueue, r)
+			requeued = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.retransBuffer = kept
+	s.sendMutex.Unlock()
+
+	if requeued && s.conn != nil {
+		s.conn.signalSend()
+	}
+}
+
+// RequeueOldestUnacked moves the oldest sent-but-unacknowledged range back
+// into the lost queue so the next GetSendData re-offers it. The PTO uses it
+// to build probe packets. It reports whether there was such a range.
+func (s *Stream) RequeueOldestUnacked() bool {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if len(s.retransBuffer) == 0 {
+		return false
+	}
+	oldest := 0
+	for i, r := range s.retransBuffer {
+		if r.offset < s.retransBuffer[oldest].offset {
+			oldest = i
+		}
+	}
+	r := s.retransBuffer[oldest]
+	s.retransBuffer = append(s.retransBuffer[:oldest], s.retransBuffer[oldest+1:]...)
+	s.lostQueue = append(s.lostQueue, r)
+	return true
+}
+
+// Flush blocks until all currently buffered bytes have been handed to the
+// connection's send loop (not necessarily acknowledged by the peer). It
+// honors the write deadline.
+func (s *Stream) Flush() error {
+	if s.conn != nil {
+		s.conn.signalSend()
+	}
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	for len(s.sendBuffer) > 0 || len(s.lostQueue) > 0 {
+		if err := s.connError(); err != nil {
+			return err
+		}
+		if s.State() == StateReset {
+			return fmt.Errorf("stream %d is reset", s.id)
+		}
+		if !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline) {
+			return os.ErrDeadlineExceeded
+		}
+		s.writeCond.Wait()
+	}
+	return nil
+}
+
+// HasSendData reports whether the stream has unsent or lost bytes waiting.
+func (s *Stream) HasSendData() bool {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	return len(s.sendBuffer) > 0 || len(s.lostQueue) > 0
+}
+
+// SetLinger controls how Close treats data the peer has not yet
+// acknowledged, in the spirit of TCP's SO_LINGER: negative restores the
+// default, where Close returns immediately and the send loop delivers the
+// remainder in the background; zero makes Close discard unacknowledged data
+// at once; a positive duration makes Close block until everything sent has
+// been acknowledged or the duration passes, discarding whatever is still
+// outstanding on timeout.
+func (s *Stream) SetLinger(d time.Duration) {
+	s.sendMutex.Lock()
+	s.linger = d
+	s.lingerSet = true
+	s.sendMutex.Unlock()
+}
+
+// Close half-closes the stream's send direction. Buffered data is still
+// delivered; the final STREAM frame carries a FIN. With a linger configured
+// (see SetLinger), Close additionally waits for — or discards — data the
+// peer has not acknowledged, returning os.ErrDeadlineExceeded when the
+// linger expired before delivery was confirmed.
+func (s *Stream) Close() error {
+	s.sendMutex.Lock()
+	s.sendFinished = true
+	linger, lingerSet := s.linger, s.lingerSet
+	s.sendMutex.Unlock()
+
+	if s.closeSendSide() {
+		s.cancelCtx(nil)
+	}
+	if s.conn != nil {
+		s.conn.signalSend()
+	}
+	if !lingerSet || linger < 0 {
+		return nil
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if linger == 0 {
+		s.discardUnackedLocked()
+		return nil
+	}
+	deadline := time.Now().Add(linger)
+	// Acknowledgments wake the wait via OnAck's broadcast; the timer
+	// guarantees a wakeup when none arrive.
+	wake := time.AfterFunc(linger, func() {
+		s.sendMutex.Lock()
+		s.writeCond.Broadcast()
+		s.sendMutex.Unlock()
+	})
+	defer wake.Stop()
+	for len(s.sendBuffer) > 0 || len(s.retransBuffer) > 0 || len(s.lostQueue) > 0 {
+		if err := s.connError(); err != nil {
+			return err
+		}
+		if s.State() == StateReset {
+			return fmt.Errorf("stream %d is reset", s.id)
+		}
+		if !time.Now().Before(deadline) {
+			s.discardUnackedLocked()
+			return os.ErrDeadlineExceeded
+		}
+		s.writeCond.Wait()
+	}
+	return nil
+}
+
+// discardUnackedLocked drops all data the peer has not acknowledged.
+// sendMutex must be held.
+func (s *Stream) discardUnackedLocked() {
+	s.sendBuffer = nil
+	s.retransBuffer = nil
+	s.lostQueue = nil
+	s.writeCond.Broadcast()
+}
+
+// CancelWrite abandons transmission on the stream. The peer is informed with
+// a RESET_STREAM frame.
+func (s *Stream) CancelWrite(errorCode uint64) error {
+	s.sendMutex.Lock()
+	finalSize := s.sendOffset
+	s.sendBuffer = nil
+	s.retransBuffer = nil
+	s.lostQueue = nil
+	s.sendFinished = true
+	s.writeCond.Broadcast()
+	s.sendMutex.Unlock()
+
+	s.setState(StateReset)
+	s.resetErrorCode = errorCode
+	s.cancelCtx(&StreamError{StreamID: s.id, ErrorCode: errorCode})
+	if s.conn != nil {
+		return s.conn.sendResetStream(s.id, errorCode, finalSize)
+	}
+	return nil
+}
+
+// handleReset processes a RESET_STREAM frame from the peer.
+func (s *Stream) handleReset(errorCode, finalSize uint64) {
+	s.resetErrorCode = errorCode
+	s.setState(StateReset)
+	s.cancelCtx(&StreamError{StreamID: s.id, ErrorCode: errorCode})
+	s.recvBufferMutex.Lock()
+	s.finalSize = finalSize
+	s.finalSizeKnown = true
+	s.readCond.Broadcast()
+	s.recvBufferMutex.Unlock()
+}
+
+// SetReadDeadline sets the deadline for future Read calls.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.recvBufferMutex.Lock()
+	s.readDeadline = t
+	s.recvBufferMutex.Unlock()
+	if !t.IsZero() {
+		time.AfterFunc(time.Until(t), func() {
+			s.readCond.Broadcast()
+		})
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.sendMutex.Lock()
+	s.writeDeadline = t
+	s.sendMutex.Unlock()
+	if !t.IsZero() {
+		time.AfterFunc(time.Until(t), func() {
+			s.writeCond.Broadcast()
+		})
+	}
+	return nil
+}
+
+// SetDeadline sets both the read and write deadlines.
+func (s *Stream) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
@@ -0,0 +1,912 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/QUIC/crypto"
+	"github.com/phuhao00/QUIC/packet"
+)
+
+func TestFlushWaitsForSendBufferDrain(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if _, err := s.Write(make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drain the buffer from a separate goroutine, as the connection's send
+	// loop would.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		for s.HasSendData() {
+			s.GetSendData(1200)
+		}
+	}()
+
+	start := time.Now()
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Flush returned before the send buffer drained")
+	}
+	if s.HasSendData() {
+		t.Error("send buffer not empty after Flush")
+	}
+}
+
+func TestSetReceiveWindowAdvertisedToPeer(t *testing.T) {
+	cfg := &Config{
+		InitialStreamReceiveWindow: 64 * 1024,
+		MaxStreamReceiveWindow:     4 * 1024 * 1024,
+	}
+	ln, err := ListenAddr("127.0.0.1:0", nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	streamCh := make(chan *Stream, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		streamCh <- s
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	var peer *Stream
+	select {
+	case peer = <-streamCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not accept the stream")
+	}
+
+	const window = 1024 * 1024
+	s.SetReceiveWindow(window)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		peer.sendMutex.Lock()
+		limit := peer.maxStreamData
+		peer.sendMutex.Unlock()
+		if limit >= window {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("peer send limit never reached %d", window)
+}
+
+func TestLargeWriteSplitsAcrossPackets(t *testing.T) {
+	const total = 1 << 20
+	ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		n := 0
+		buf := make([]byte, 64*1024)
+		for n < total {
+			m, err := s.Read(buf)
+			n += m
+			if err != nil {
+				break
+			}
+		}
+		received <- n
+	}()
+
+	conn, err := DialAddr(ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in paced chunks: there is no loss recovery yet, so an unpaced
+	// burst would overrun the receiver's socket buffer and stall forever.
+	const chunk = 8 * 1024
+	for written := 0; written < total; written += chunk {
+		if _, err := s.Write(make([]byte, chunk)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	select {
+	case n := <-received:
+		if n != total {
+			t.Fatalf("server received %d bytes, want %d", n, total)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("transfer did not complete")
+	}
+
+	// Each packet carries at most maxStreamDataPerPacket stream bytes, so
+	// the transfer must have been split over many packets.
+	budget := conn.maxStreamDataPerPacket()
+	if budget >= int(conn.config.MaxUDPPayloadSize) {
+		t.Fatalf("budget %d not below max payload %d", budget, conn.config.MaxUDPPayloadSize)
+	}
+	minPackets := uint64(total / budget)
+	if sent := conn.GetStats().PacketsSent; sent < minPackets {
+		t.Errorf("PacketsSent = %d, want at least %d", sent, minPackets)
+	}
+}
+
+func TestSetNoDelayCoalescesSmallWrites(t *testing.T) {
+	// Performs 100 small writes and returns how many packets they took.
+	run := func(noDelay bool) uint64 {
+		ln, err := ListenAddr("127.0.0.1:0", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		go func() {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			s, err := conn.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4096)
+			for {
+				if _, err := s.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+
+		conn, err := DialAddr(ln.Addr().String(),
+			&tls.Config{InsecureSkipVerify: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		s, err := conn.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetNoDelay(noDelay)
+
+		before := conn.GetStats().PacketsSent
+		for i := 0; i < 100; i++ {
+			if _, err := s.Write([]byte("tick")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return conn.GetStats().PacketsSent - before
+	}
+
+	direct := run(true)
+	coalesced := run(false)
+	if coalesced*2 >= direct {
+		t.Errorf("coalescing sent %d packets against %d without; expected far fewer", coalesced, direct)
+	}
+}
+
+func TestFlushDeadline(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if _, err := s.Write([]byte("stuck")); err != nil {
+		t.Fatal(err)
+	}
+	s.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if err := s.Flush(); err == nil {
+		t.Fatal("Flush succeeded with nothing draining the buffer")
+	}
+}
+
+func TestLostRangeReoffered(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	payload := []byte("hello, retransmission")
+	if _, err := s.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	data, offset, _, err := s.GetSendData(1200)
+	if err != nil || offset != 0 || string(data) != string(payload) {
+		t.Fatalf("GetSendData = %q offset %d err %v", data, offset, err)
+	}
+	if data, _, _, _ := s.GetSendData(1200); data != nil {
+		t.Fatalf("buffer drained but GetSendData returned %q", data)
+	}
+
+	// Declaring the range lost must make GetSendData re-offer it.
+	s.OnLoss(0, len(payload))
+	if !s.HasSendData() {
+		t.Fatal("HasSendData is false after OnLoss")
+	}
+	data, offset, _, err = s.GetSendData(1200)
+	if err != nil || offset != 0 || string(data) != string(payload) {
+		t.Fatalf("retransmission = %q offset %d err %v", data, offset, err)
+	}
+
+	// Once acknowledged, the range is gone for good.
+	s.OnAck(0, len(payload))
+	s.OnLoss(0, len(payload))
+	if data, _, _, _ := s.GetSendData(1200); data != nil {
+		t.Fatalf("acked range re-offered: %q", data)
+	}
+}
+
+func TestResetCancelsStreamContext(t *testing.T) {
+	s := newStream(4, nil, 1<<20, 1<<20)
+	ctx := s.Context()
+	select {
+	case <-ctx.Done():
+		t.Fatal("context cancelled before any close or reset")
+	default:
+	}
+
+	s.handleReset(42, 0)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled by reset")
+	}
+	var streamErr *StreamError
+	if cause := context.Cause(ctx); !errors.As(cause, &streamErr) {
+		t.Fatalf("context cause = %v, want *StreamError", cause)
+	}
+	if streamErr.ErrorCode != 42 || streamErr.StreamID != 4 {
+		t.Errorf("cause = %+v, want error code 42 on stream 4", streamErr)
+	}
+}
+
+func TestCloseCancelsStreamContext(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	s.ReceiveData(0, nil, true)
+	s.Close()
+	select {
+	case <-s.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled by full close")
+	}
+	if cause := context.Cause(s.Context()); cause != context.Canceled {
+		t.Errorf("cause = %v, want context.Canceled for a clean close", cause)
+	}
+}
+
+func TestHalfCloseLocalThenRemote(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.State(); got != StateHalfClosedLocal {
+		t.Fatalf("state after Close = %v, want HalfClosedLocal", got)
+	}
+	if err := s.ReceiveData(0, []byte("bye"), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.State(); got != StateClosed {
+		t.Errorf("state after remote FIN = %v, want Closed", got)
+	}
+}
+
+func TestHalfCloseRemoteThenLocal(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if err := s.ReceiveData(0, []byte("bye"), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.State(); got != StateHalfClosedRemote {
+		t.Fatalf("state after remote FIN = %v, want HalfClosedRemote", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.State(); got != StateClosed {
+		t.Errorf("state after Close = %v, want Closed", got)
+	}
+}
+
+func TestHalfClosedRemoteStillWritable(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if err := s.ReceiveData(0, []byte("request"), true); err != nil {
+		t.Fatal(err)
+	}
+	// The send direction is untouched by the peer's FIN.
+	if _, err := s.Write([]byte("response")); err != nil {
+		t.Errorf("Write in HalfClosedRemote: %v", err)
+	}
+}
+
+func TestConcurrentReadersGetDistinctBytes(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	const total = 64 * 1024
+	payload := make([]byte, total)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	if err := s.ReceiveData(0, payload, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two readers race over the same stream; together they must consume
+	// every byte exactly once, neither duplicating nor losing any.
+	counts := make([]int, 2)
+	var wg sync.WaitGroup
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			buf := make([]byte, 1024)
+			for {
+				n, err := s.Read(buf)
+				counts[r] += n
+				if err != nil {
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if got := counts[0] + counts[1]; got != total {
+		t.Errorf("readers consumed %d bytes in total, want %d", got, total)
+	}
+}
+
+func TestWriteBackpressureBoundsBuffer(t *testing.T) {
+	const (
+		window = 256 * 1024
+		total  = 100 << 20
+	)
+	s := newStream(0, nil, 1<<20, window)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		chunk := make([]byte, 1<<20)
+		for written := 0; written < total; written += len(chunk) {
+			if _, err := s.Write(chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	// Drain slowly relative to the writer, extending credit as the peer's
+	// MAX_STREAM_DATA frames would. The buffer must stay within the window.
+	drained := 0
+	maxBuffered := 0
+	for drained < total {
+		data, offset, _, err := s.GetSendData(64 * 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) == 0 {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		s.OnAck(offset, len(data))
+		drained += len(data)
+
+		s.sendMutex.Lock()
+		s.maxStreamData += uint64(len(data))
+		if buffered := len(s.sendBuffer); buffered > maxBuffered {
+			maxBuffered = buffered
+		}
+		s.writeCond.Broadcast()
+		s.sendMutex.Unlock()
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
+	}
+	if maxBuffered > window {
+		t.Errorf("send buffer grew to %d bytes, want at most %d", maxBuffered, window)
+	}
+}
+
+func TestWriteDeadlineUnblocksBackpressure(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1024)
+	if _, err := s.Write(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	s.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := s.Write([]byte("more")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write = %v, want ErrDeadlineExceeded", err)
+	}
+}
+
+func TestReadDrainsBufferBeforeEOF(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	payload := make([]byte, 256*1024)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	if err := s.ReceiveData(0, payload, true); err != nil {
+		t.Fatal(err)
+	}
+	// Locally closing too makes the stream fully Closed while the payload is
+	// still buffered; it must remain readable.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("read %d bytes, want the full %d-byte payload intact", len(got), len(payload))
+	}
+	if _, err := s.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read after drain = %v, want io.EOF", err)
+	}
+}
+
+func TestCancelWriteAfterPartialSend(t *testing.T) {
+	sender := newStream(0, nil, 1<<20, 1<<20)
+	if _, err := sender.Write(make([]byte, 10*1024)); err != nil {
+		t.Fatal(err)
+	}
+	// 1KB goes on the wire before the application cancels.
+	data, offset, _, err := sender.GetSendData(1024)
+	if err != nil || offset != 0 || len(data) != 1024 {
+		t.Fatalf("GetSendData = %d bytes at %d, err %v", len(data), offset, err)
+	}
+	if err := sender.CancelWrite(42); err != nil {
+		t.Fatal(err)
+	}
+	// The final size reported to the peer is the bytes actually sent, and
+	// nothing more is offered for transmission.
+	sender.sendMutex.Lock()
+	finalSize := sender.sendOffset
+	sender.sendMutex.Unlock()
+	if finalSize != 1024 {
+		t.Errorf("final size = %d, want 1024", finalSize)
+	}
+	if rest, _, _, _ := sender.GetSendData(1200); rest != nil {
+		t.Errorf("GetSendData after cancel returned %d bytes", len(rest))
+	}
+
+	// The receiving side drains the delivered 1KB before surfacing the reset.
+	receiver := newStream(0, nil, 1<<20, 1<<20)
+	if err := receiver.ReceiveData(0, data, false); err != nil {
+		t.Fatal(err)
+	}
+	receiver.handleReset(42, finalSize)
+
+	buf := make([]byte, 2048)
+	n, err := receiver.Read(buf)
+	if n != 1024 || err != nil {
+		t.Fatalf("Read = %d, %v, want the delivered 1024 bytes", n, err)
+	}
+	_, err = receiver.Read(buf)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.ErrorCode != 42 {
+		t.Fatalf("Read after drain = %v, want *StreamError with code 42", err)
+	}
+	receiver.recvBufferMutex.Lock()
+	gotFinal := receiver.finalSize
+	receiver.recvBufferMutex.Unlock()
+	if gotFinal != 1024 {
+		t.Errorf("receiver final size = %d, want 1024", gotFinal)
+	}
+}
+
+func TestCloseEmptyStreamSendsFin(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if _, _, fin, _ := s.GetSendData(64); fin {
+		t.Fatal("FIN offered before the stream was closed")
+	}
+
+	// Closing with nothing buffered must still produce an empty final frame.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, offset, fin, err := s.GetSendData(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 || offset != 0 || !fin {
+		t.Fatalf("GetSendData = %q at %d, fin %v; want empty FIN at 0", data, offset, fin)
+	}
+	if _, _, fin, _ := s.GetSendData(64); fin {
+		t.Error("FIN offered a second time")
+	}
+}
+
+func TestCloseDrainedStreamSendsFinAtFinalOffset(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if _, err := s.Write([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	data, offset, _, err := s.GetSendData(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnAck(offset, len(data))
+
+	// The buffer drained before Close, so the FIN rides an empty frame at
+	// the final offset.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, offset, fin, err := s.GetSendData(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 || offset != 4 || !fin {
+		t.Fatalf("GetSendData = %q at %d, fin %v; want empty FIN at 4", data, offset, fin)
+	}
+}
+
+func TestWriteBuffers(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	bufs := net.Buffers{[]byte("scatter "), []byte("gather "), nil, []byte("io")}
+
+	n, err := s.WriteBuffers(bufs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 17 {
+		t.Fatalf("WriteBuffers = %d, want 17", n)
+	}
+	data, offset, _, err := s.GetSendData(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 0 || string(data) != "scatter gather io" {
+		t.Fatalf("GetSendData = %q at %d, want \"scatter gather io\" at 0", data, offset)
+	}
+}
+
+func benchmarkStreamSend(b *testing.B, send func(s *Stream, bufs net.Buffers)) {
+	s := newStream(0, nil, 1<<20, 1<<30)
+	bufs := make(net.Buffers, 8)
+	for i := range bufs {
+		bufs[i] = make([]byte, 512)
+	}
+	b.SetBytes(8 * 512)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		send(s, bufs)
+		// Drain and acknowledge so the send buffer stays small.
+		for s.HasSendData() {
+			data, offset, _, _ := s.GetSendData(1 << 16)
+			s.OnAck(offset, len(data))
+		}
+	}
+}
+
+func BenchmarkWriteBuffers(b *testing.B) {
+	benchmarkStreamSend(b, func(s *Stream, bufs net.Buffers) {
+		if _, err := s.WriteBuffers(bufs); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkRepeatedWrite(b *testing.B) {
+	benchmarkStreamSend(b, func(s *Stream, bufs net.Buffers) {
+		for _, buf := range bufs {
+			if _, err := s.Write(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// benchmarkStreamEcho pushes data through both halves of a stream: each
+// round a write drains through GetSendData and the same bytes come back
+// through ReceiveData to be consumed by Read.
+func benchmarkStreamEcho(b *testing.B, s *Stream) {
+	chunk := make([]byte, 2048)
+	out := make([]byte, 2048)
+	b.SetBytes(2048)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+		data, offset, _, _ := s.GetSendData(1 << 16)
+		s.OnAck(offset, len(data))
+		if err := s.ReceiveData(uint64(i)*2048, chunk, false); err != nil {
+			b.Fatal(err)
+		}
+		if n, err := s.Read(out); err != nil || n != 2048 {
+			b.Fatalf("Read = %d, %v", n, err)
+		}
+	}
+}
+
+func BenchmarkStreamEcho(b *testing.B) {
+	benchmarkStreamEcho(b, newStream(0, nil, 1<<40, 1<<40))
+}
+
+func BenchmarkStreamEchoPreSizedBuffers(b *testing.B) {
+	s := newStream(0, nil, 1<<40, 1<<40)
+	s.SetBuffers(64*1024, 64*1024)
+	benchmarkStreamEcho(b, s)
+}
+
+func TestStreamStatsDeliveredLagsBuffered(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+
+	// Send side: handing data to the send loop counts it as sent, but not
+	// acknowledged until the peer's ACK covers it.
+	if _, err := s.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	data, offset, _, err := s.GetSendData(1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := s.GetStats()
+	if stats.BytesSent != uint64(len(data)) {
+		t.Errorf("BytesSent = %d, want %d", stats.BytesSent, len(data))
+	}
+	if stats.BytesAcked != 0 {
+		t.Errorf("BytesAcked = %d before any ACK, want 0", stats.BytesAcked)
+	}
+	s.OnAck(offset, len(data))
+	if got := s.GetStats().BytesAcked; got != uint64(len(data)) {
+		t.Errorf("BytesAcked after ACK = %d, want %d", got, len(data))
+	}
+
+	// Receive side: buffered data is only delivered once the app reads it.
+	if err := s.ReceiveData(0, []byte("response"), false); err != nil {
+		t.Fatal(err)
+	}
+	stats = s.GetStats()
+	if stats.BytesReceived != 8 {
+		t.Errorf("BytesReceived = %d, want 8", stats.BytesReceived)
+	}
+	if stats.BytesRead != 0 {
+		t.Errorf("BytesRead = %d before the app reads, want 0", stats.BytesRead)
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetStats().BytesRead; got != 8 {
+		t.Errorf("BytesRead after reading = %d, want 8", got)
+	}
+}
+
+func TestDebugStreamChecksumTripsOnCorruptedReassembly(t *testing.T) {
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+	conn.config.DebugStreamChecksums = true
+
+	payload := []byte("hello world")
+	corrupted := append([]byte(nil), payload...)
+	corrupted[4] ^= 0xff
+	conn.handleFrame(&packet.StreamFrame{StreamID: 0, Data: corrupted, Fin: true},
+		crypto.LevelApplication)
+
+	conn.streamsMutex.RLock()
+	stream := conn.streams[0]
+	conn.streamsMutex.RUnlock()
+	if stream == nil {
+		t.Fatal("STREAM frame did not create stream 0")
+	}
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sender's checksum covers the uncorrupted data, so verification
+	// must fail once it arrives.
+	conn.handleFrame(&packet.StreamChecksumFrame{
+		StreamID:  0,
+		FinalSize: uint64(len(payload)),
+		Checksum:  uint64(crc32.ChecksumIEEE(payload)),
+	}, crypto.LevelApplication)
+
+	// The mismatch close runs on its own goroutine; wait for it.
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn.closeMtx.Lock()
+		frame := conn.closeFrame
+		conn.closeMtx.Unlock()
+		if frame != nil {
+			if frame.ErrorCode != packet.ProtocolViolation {
+				t.Errorf("ErrorCode = %#x, want PROTOCOL_VIOLATION", frame.ErrorCode)
+			}
+			if frame.FrameType != packet.FrameTypeStreamChecksum {
+				t.Errorf("FrameType = %#x, want %#x", frame.FrameType, packet.FrameTypeStreamChecksum)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("checksum mismatch did not close the connection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReadDrainsBufferedDataAfterCleanClose(t *testing.T) {
+	s := newStream(0, nil, 1<<20, 1<<20)
+	if err := s.ReceiveData(0, []byte("leftover"), true); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if got := s.State(); got != StateClosed {
+		t.Fatalf("State = %v, want Closed", got)
+	}
+
+	// Both directions have closed cleanly, but the buffered data must still
+	// be delivered; EOF is reported only once it has been consumed.
+	buf := make([]byte, 16)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "leftover" {
+		t.Fatalf("Read = %q, %v; want the buffered data", buf[:n], err)
+	}
+	if _, err := s.Read(buf); err != io.EOF {
+		t.Fatalf("Read after drain = %v, want io.EOF", err)
+	}
+}
+
+func TestTracerReportsReassemblyGapForDelayedFragment(t *testing.T) {
+	var mu sync.Mutex
+	var gaps []time.Duration
+	conn := newTestConnection(t, false)
+	defer conn.Close()
+	conn.config.ConnectionTracer = &ConnectionTracer{
+		StreamReassemblyGap: func(streamID uint64, gap time.Duration) {
+			mu.Lock()
+			gaps = append(gaps, gap)
+			mu.Unlock()
+		},
+	}
+	s, _ := conn.getOrCreateStream(0)
+
+	// The first and last fragments arrive promptly; the middle one stalls.
+	if err := s.ReceiveData(0, []byte("aaaaa"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReceiveData(10, []byte("ccccc"), false); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := s.ReceiveData(5, []byte("bbbbb"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(gaps) != 1 {
+		t.Fatalf("got %d gap samples, want 1", len(gaps))
+	}
+	if gaps[0] < 15*time.Millisecond {
+		t.Errorf("gap = %v, want at least the fragment's delay", gaps[0])
+	}
+}
+
+func TestTracerReportsWriteAckLatency(t *testing.T) {
+	var mu sync.Mutex
+	var samples []time.Duration
+	conn := newTestConnection(t, true)
+	defer conn.Close()
+	conn.config.ConnectionTracer = &ConnectionTracer{
+		StreamWriteAckLatency: func(streamID uint64, latency time.Duration) {
+			mu.Lock()
+			samples = append(samples, latency)
+			mu.Unlock()
+		},
+	}
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Write([]byte("measured")); err != nil {
+		t.Fatal(err)
+	}
+	data, offset, _, err := s.GetSendData(1200)
+	if err != nil || len(data) == 0 {
+		t.Fatalf("GetSendData = %q, %v", data, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.OnAck(offset, len(data))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(samples) != 1 {
+		t.Fatalf("got %d latency samples, want 1", len(samples))
+	}
+	if samples[0] < 15*time.Millisecond {
+		t.Errorf("latency = %v, want at least the ack's delay", samples[0])
+	}
+}
+
+func TestCloseLingerWaitsForAckOrDiscards(t *testing.T) {
+	// With the peer acknowledging promptly, a lingering Close confirms
+	// delivery and returns nil.
+	s := newStream(0, nil, 1<<20, 1<<20)
+	s.SetLinger(3 * time.Second)
+	if _, err := s.Write([]byte("confirmed")); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// Drain and acknowledge, as the connection's loops would.
+		time.Sleep(20 * time.Millisecond)
+		data, offset, _, _ := s.GetSendData(1200)
+		s.OnAck(offset, len(data))
+	}()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close with acknowledged data: %v", err)
+	}
+
+	// With no acknowledgment arriving, the linger deadline discards the
+	// outstanding data and reports the timeout.
+	u := newStream(4, nil, 1<<20, 1<<20)
+	u.SetLinger(50 * time.Millisecond)
+	if _, err := u.Write([]byte("abandoned")); err != nil {
+		t.Fatal(err)
+	}
+	u.GetSendData(1200) // sent, but never acknowledged
+	if err := u.Close(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Close = %v, want os.ErrDeadlineExceeded", err)
+	}
+	u.sendMutex.Lock()
+	outstanding := len(u.retransBuffer) + len(u.lostQueue) + len(u.sendBuffer)
+	u.sendMutex.Unlock()
+	if outstanding != 0 {
+		t.Errorf("%d unacknowledged ranges survived the linger discard", outstanding)
+	}
+}
@@ -0,0 +1,237 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/phuhao00/QUIC/crypto"
+	"github.com/phuhao00/QUIC/packet"
+)
+
+// ErrTransportClosed is returned by operations on a closed transport.
+var ErrTransportClosed = errors.New("transport closed")
+
+// Transport multiplexes many QUIC connections over a single UDP socket.
+// Incoming datagrams are routed by destination connection ID, so dialed and
+// listened connections can share the socket.
+type Transport struct {
+	// Conn is the packet socket shared by all connections on this
+	// transport. Any net.PacketConn works — quictest provides simulated
+	// ones — but ECN marking and validation need a *net.UDPConn.
+	Conn net.PacketConn
+
+	mutex    sync.Mutex
+	udpConn  rawConn
+	conns    map[string]*Connection // keyed by destination connection ID
+	listener *Listener
+	started  bool
+	closed   bool
+	// connIDLength is the length of local connection IDs on this socket,
+	// needed to parse short headers. The first Dial or Listen fixes it.
+	connIDLength int
+}
+
+// setConnIDLength records the connection ID length used on this socket.
+func (t *Transport) setConnIDLength(config *Config) {
+	t.mutex.Lock()
+	if t.connIDLength == 0 {
+		t.connIDLength = config.connIDLength()
+	}
+	t.mutex.Unlock()
+}
+
+// init validates the socket and starts the shared read loop once.
+func (t *Transport) init() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	if t.started {
+		return nil
+	}
+	if t.Conn == nil {
+		return fmt.Errorf("quic: Transport.Conn is nil")
+	}
+	t.udpConn = wrapPacketConn(t.Conn)
+	t.conns = make(map[string]*Connection)
+	t.started = true
+	go t.readLoop()
+	return nil
+}
+
+// Dial connects to a QUIC server over the transport's shared socket and
+// blocks until the handshake completes.
+func (t *Transport) Dial(ctx context.Context, addr string, tlsConf *tls.Config, config *Config) (*Connection, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config = populateConfig(config)
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	if udp, ok := t.Conn.(*net.UDPConn); ok && !config.DisableECN {
+		enableECNReceiving(udp)
+	}
+
+	remoteAddr, err := net.ResolveUDPAddr(config.Network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", addr, err)
+	}
+	t.setConnIDLength(config)
+	localConnID, err := generateConnectionIDFor(config)
+	if err != nil {
+		return nil, err
+	}
+	destConnID, err := generateConnectionID()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := newConnection(t.udpConn, remoteAddr, true, false, config, tlsConf,
+		localConnID, destConnID, destConnID)
+	if err != nil {
+		return nil, err
+	}
+	// Replies carry our connection ID in the short header.
+	key := string(localConnID)
+	conn.onClosed = func(*Connection) { t.removeConn(key) }
+	if err := t.addConn(key, conn); err != nil {
+		return nil, err
+	}
+	return startClientConnection(ctx, conn, false)
+}
+
+// Listen attaches a listener to the transport so unknown Initial packets
+// create server connections. Only one listener per transport is supported.
+func (t *Transport) Listen(tlsConf *tls.Config, config *Config) (*Listener, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config = populateConfig(config)
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	if udp, ok := t.Conn.(*net.UDPConn); ok && !config.DisableECN {
+		enableECNReceiving(udp)
+	}
+
+	t.setConnIDLength(config)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.listener != nil {
+		return nil, fmt.Errorf("quic: transport already has a listener")
+	}
+	l := &Listener{
+		transport:   t,
+		tlsConf:     tlsConf,
+		config:      config,
+		acceptQueue: make(chan *Connection, 16),
+		closed:      make(chan struct{}),
+	}
+	if config.RequireAddressValidation {
+		tokens, err := crypto.NewTokenManager(retryTokenLifetime)
+		if err != nil {
+			return nil, err
+		}
+		l.tokens = tokens
+	}
+	t.listener = l
+	return l, nil
+}
+
+// Close closes all connections on the transport and the underlying socket.
+func (t *Transport) Close() error {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil
+	}
+	t.closed = true
+	conns := make([]*Connection, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	listener := t.listener
+	t.mutex.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	if listener != nil {
+		listener.shutdown()
+	}
+	if t.Conn != nil {
+		t.Conn.Close()
+	}
+	return nil
+}
+
+func (t *Transport) addConn(key string, conn *Connection) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.conns[key] = conn
+	return nil
+}
+
+func (t *Transport) removeConn(key string) {
+	t.mutex.Lock()
+	delete(t.conns, key)
+	t.mutex.Unlock()
+}
+
+// readLoop is the shared demultiplexer for all connections on the socket.
+func (t *Transport) readLoop() {
+	buf := make([]byte, 65535)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, addr, err := t.udpConn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			t.mutex.Lock()
+			closed := t.closed
+			t.mutex.Unlock()
+			if closed || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		t.handleDatagram(buf[:n], parseECN(oob[:oobn]), addr)
+	}
+}
+
+func (t *Transport) handleDatagram(data []byte, ecn uint8, addr *net.UDPAddr) {
+	t.mutex.Lock()
+	idLen := t.connIDLength
+	t.mutex.Unlock()
+	if idLen == 0 {
+		idLen = connIDLen
+	}
+	// Routing only needs the connection ID, so packets with greased or
+	// invalid header bits are still delivered; the connection judges them.
+	header, _, err := packet.ParseHeader(data, idLen)
+	if err != nil && header == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	conn, ok := t.conns[string(header.DestConnID)]
+	listener := t.listener
+	t.mutex.Unlock()
+	if ok {
+		conn.handleDatagramECN(data, ecn)
+		return
+	}
+	// Unknown destination: only an Initial packet may start a connection,
+	// and only if a listener is attached.
+	if listener == nil || header.Type != packet.PacketTypeInitial {
+		return
+	}
+	listener.acceptInitial(header, data, addr)
+}
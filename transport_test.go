@@ -0,0 +1,172 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/phuhao00/QUIC/quictest"
+)
+
+func echoFirstStream(conn *Connection) {
+	s, err := conn.AcceptStream(context.Background())
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 256)
+	n, _ := s.Read(buf)
+	s.Write(buf[:n])
+}
+
+func TestTransportSharesSocketForDialAndListen(t *testing.T) {
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Transport{Conn: socket}
+	defer tr.Close()
+
+	ln, err := tr.Listen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go echoFirstStream(conn)
+		}
+	}()
+
+	// A remote server for the transport's dialed connection.
+	remote, err := ListenAddr("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	go func() {
+		conn, err := remote.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		echoFirstStream(conn)
+	}()
+
+	// Dialed connection over the shared socket.
+	out, err := tr.Dial(context.Background(), remote.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatalf("Transport.Dial: %v", err)
+	}
+	defer out.Close()
+
+	// Inbound connection to the transport's listener, from a separate
+	// client socket.
+	in, err := DialAddr(socket.LocalAddr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatalf("dialing transport listener: %v", err)
+	}
+	defer in.Close()
+
+	for name, conn := range map[string]*Connection{"dialed": out, "listened": in} {
+		s, err := conn.OpenStream()
+		if err != nil {
+			t.Fatalf("%s: OpenStream: %v", name, err)
+		}
+		if _, err := s.Write([]byte(name)); err != nil {
+			t.Fatalf("%s: Write: %v", name, err)
+		}
+		s.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf := make([]byte, 64)
+		n, err := s.Read(buf)
+		if err != nil || string(buf[:n]) != name {
+			t.Fatalf("%s: echo failed: n=%d err=%v", name, n, err)
+		}
+	}
+}
+
+func TestExchangeOverLossySimulatedConn(t *testing.T) {
+	serverUDP, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientUDP, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both directions lose a tenth of their datagrams; the client side also
+	// reorders and delays. Loss recovery has to fill in the gaps.
+	serverSide := quictest.NewConn(serverUDP, 1)
+	serverSide.LossRate = 0.1
+	clientSide := quictest.NewConn(clientUDP, 2)
+	clientSide.LossRate = 0.1
+	clientSide.ReorderRate = 0.1
+	clientSide.Latency = time.Millisecond
+
+	serverTr := &Transport{Conn: serverSide}
+	defer serverTr.Close()
+	ln, err := serverTr.Listen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const total = 16 * 1024
+	received := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		n := 0
+		buf := make([]byte, 4096)
+		for n < total {
+			m, err := s.Read(buf)
+			n += m
+			if err != nil {
+				break
+			}
+		}
+		received <- n
+	}()
+
+	clientTr := &Transport{Conn: clientSide}
+	defer clientTr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := clientTr.Dial(ctx, ln.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := conn.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const chunk = 2 * 1024
+	for written := 0; written < total; written += chunk {
+		if _, err := s.Write(make([]byte, chunk)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	select {
+	case n := <-received:
+		if n != total {
+			t.Fatalf("server received %d bytes over the lossy path, want %d", n, total)
+		}
+	case <-time.After(60 * time.Second):
+		t.Fatal("transfer never completed over the lossy path")
+	}
+}